@@ -1,9 +1,12 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,7 +19,8 @@ type Auth struct {
 	Secret        string
 	TokenExpiry   time.Duration
 	RefreshExpiry time.Duration
-	CookieDomain  string // what is the domain associated with cookie. Something like example.com
+	VerifyExpiry  time.Duration // how long an email verification link works
+	CookieDomain  string        // what is the domain associated with cookie. Something like example.com
 	CookiePath    string
 	CookieName    string
 }
@@ -30,6 +34,10 @@ type jwtUser struct {
 type TokenPairs struct {
 	Token        string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
+
+	// the id (jti) and expiry of the refresh token, for the server to keep track of it
+	RefreshTokenID string    `json:"-"`
+	RefreshExpires time.Time `json:"-"`
 }
 
 type Claims struct {
@@ -59,14 +67,22 @@ func (j *Auth) GenerateTokenPair(user *jwtUser) (TokenPairs, error) {
 		return TokenPairs{}, err
 	}
 
+	// every refresh token gets a random id, the server keeps it to revoke the token
+	refreshID, err := randomID()
+	if err != nil {
+		return TokenPairs{}, err
+	}
+	refreshExpires := time.Now().UTC().Add(j.RefreshExpiry)
+
 	// Create a refresh token and set claims
 	refreshToken := jwt.New(jwt.SigningMethodHS256)
 	refreshTokenClaims := refreshToken.Claims.(jwt.MapClaims)
 	refreshTokenClaims["sub"] = fmt.Sprint(user.ID)
+	refreshTokenClaims["jti"] = refreshID
 	refreshTokenClaims["iat"] = time.Now().UTC().Unix()
 
 	// Set the expiry for refresh token
-	refreshTokenClaims["exp"] = time.Now().UTC().Add(j.RefreshExpiry).Unix()
+	refreshTokenClaims["exp"] = refreshExpires.Unix()
 
 	// Create signed refresh token
 	signedRefreshToken, err := refreshToken.SignedString([]byte(j.Secret))
@@ -76,14 +92,80 @@ func (j *Auth) GenerateTokenPair(user *jwtUser) (TokenPairs, error) {
 
 	// Create TokenPairs(struct) and populate with signed tokens
 	var tokenPairs = TokenPairs{
-		Token:        signedAccessToken,
-		RefreshToken: signedRefreshToken,
+		Token:          signedAccessToken,
+		RefreshToken:   signedRefreshToken,
+		RefreshTokenID: refreshID,
+		RefreshExpires: refreshExpires,
 	}
 
 	// Return TokenPairs
 	return tokenPairs, nil
 }
 
+// randomID returns 128 random bits in hex, for the ids of tokens and token families
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// verificationClaims are the claims of an email verification token. The email is in there
+// so that the link stops working if the user changes it.
+type verificationClaims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// verificationKey signs email verification tokens. It isn't the secret itself, which the
+// refresh handler would accept: a link leaked from a mailbox must not log anyone in.
+func (j *Auth) verificationKey() []byte {
+	return []byte("email-verification:" + j.Secret)
+}
+
+// GenerateVerificationToken returns the signed token of the link that verifies the email
+// of a user
+func (j *Auth) GenerateVerificationToken(userID int, email string) (string, error) {
+	now := time.Now().UTC()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, verificationClaims{
+		Email: email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   fmt.Sprint(userID),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(j.VerifyExpiry)),
+		},
+	})
+
+	return token.SignedString(j.verificationKey())
+}
+
+// ParseVerificationToken checks the signature and expiry of an email verification token
+// and returns the user and the email it verifies
+func (j *Auth) ParseVerificationToken(token string) (int, string, error) {
+	claims := &verificationClaims{}
+
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return j.verificationKey(), nil
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return 0, "", errors.New("invalid subject")
+	}
+
+	return userID, claims.Email, nil
+}
+
 func (j *Auth) GetRefreshCookie(refreshToken string) *http.Cookie {
 	return &http.Cookie{
 		Name:     j.CookieName,
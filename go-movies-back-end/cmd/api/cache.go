@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// cacheHeaders are the validators and the Cache-Control of a read. Public reads may be
+// kept by any cache for -cache-max-age, or revalidated every time when it is zero; the
+// admin ones only by the browser, and always revalidated.
+//
+// Last-Modified counts in seconds, so it is only sent once the second of lastModified is
+// over: another change in the same second would have the same date, and If-Modified-Since
+// would take it for the copy the client has. Until then the ETag is the only validator.
+func (app *application) cacheHeaders(etag string, lastModified time.Time, public bool) http.Header {
+	headers := http.Header{}
+	headers.Set("ETag", etag)
+
+	if !lastModified.IsZero() && !lastModified.Truncate(time.Second).Add(time.Second).After(time.Now()) {
+		headers.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	switch {
+	case !public:
+		headers.Set("Cache-Control", "private, no-cache")
+	case app.CacheMaxAge > 0:
+		headers.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(app.CacheMaxAge.Seconds())))
+	default:
+		headers.Set("Cache-Control", "public, no-cache")
+	}
+
+	return headers
+}
+
+// notModified answers 304 Not Modified, with the given headers, when the copy the client
+// has is still current. It tells whether it did, in which case there's nothing left to
+// send. If-None-Match wins over If-Modified-Since when both are sent.
+func (app *application) notModified(w http.ResponseWriter, r *http.Request, headers http.Header) bool {
+	if !fresh(r, headers) {
+		return false
+	}
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+	w.WriteHeader(http.StatusNotModified)
+
+	return true
+}
+
+func fresh(r *http.Request, headers http.Header) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		etag := strings.TrimPrefix(headers.Get("ETag"), "W/")
+
+		// the comparison is weak, a W/ in front of a tag doesn't matter
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	lastModified, err := http.ParseTime(headers.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+
+	return !lastModified.After(since)
+}
+
+// listETag is the entity tag of a list of movies: the last change of the catalogue and
+// the query, which picks the page, the order and the filters.
+func listETag(r *http.Request, lastChange time.Time) string {
+	h := fnv.New32a()
+	h.Write([]byte(r.URL.RequestURI()))
+
+	return fmt.Sprintf(`"%d-%08x"`, lastChange.UnixMicro(), h.Sum32())
+}
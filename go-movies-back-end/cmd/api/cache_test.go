@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFresh(t *testing.T) {
+	lastModified := time.Date(2022, time.September, 23, 10, 0, 0, 0, time.UTC)
+
+	headers := http.Header{}
+	headers.Set("ETag", `"1663927200000000"`)
+	headers.Set("Last-Modified", lastModified.Format(http.TimeFormat))
+
+	tests := []struct {
+		name   string
+		header []string
+		fresh  bool
+	}{
+		{"no validator", nil, false},
+		{"same tag", []string{"If-None-Match", `"1663927200000000"`}, true},
+		{"weak tag", []string{"If-None-Match", `W/"1663927200000000"`}, true},
+		{"in a list", []string{"If-None-Match", `"1", "1663927200000000"`}, true},
+		{"any", []string{"If-None-Match", "*"}, true},
+		{"other tag", []string{"If-None-Match", `"1"`}, false},
+		{"same date", []string{"If-Modified-Since", lastModified.Format(http.TimeFormat)}, true},
+		{"later date", []string{"If-Modified-Since", lastModified.Add(time.Hour).Format(http.TimeFormat)}, true},
+		{"earlier date", []string{"If-Modified-Since", lastModified.Add(-time.Second).Format(http.TimeFormat)}, false},
+		{"broken date", []string{"If-Modified-Since", "yesterday"}, false},
+		// If-None-Match wins, the date isn't looked at
+		{"other tag and same date", []string{"If-None-Match", `"1"`, "If-Modified-Since", lastModified.Format(http.TimeFormat)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/movies/1", nil)
+			for i := 0; i+1 < len(tt.header); i += 2 {
+				r.Header.Set(tt.header[i], tt.header[i+1])
+			}
+
+			if got := fresh(r, headers); got != tt.fresh {
+				t.Errorf("fresh = %v, want %v", got, tt.fresh)
+			}
+		})
+	}
+}
+
+// Last-Modified has seconds only: it isn't sent for a change in the current second, which
+// another change could follow with the same date
+func TestCacheHeadersLastModified(t *testing.T) {
+	app := newTestApp(t)
+
+	past := time.Now().Add(-time.Minute)
+	headers := app.cacheHeaders(movieETag(past), past, true)
+	if headers.Get("Last-Modified") != past.UTC().Format(http.TimeFormat) {
+		t.Errorf("Last-Modified of a minute ago = %q", headers.Get("Last-Modified"))
+	}
+
+	now := time.Now()
+	headers = app.cacheHeaders(movieETag(now), now, true)
+	if headers.Get("Last-Modified") != "" {
+		t.Errorf("Last-Modified of now = %q, want none", headers.Get("Last-Modified"))
+	}
+	if headers.Get("ETag") == "" {
+		t.Error("no ETag")
+	}
+}
+
+func TestGetMovieNotModified(t *testing.T) {
+	app := newTestApp(t)
+
+	w := request(t, app, "GET", "/movies/1", "")
+	expectStatus(t, w, http.StatusOK)
+
+	etag := w.Header().Get("ETag")
+	lastModified := w.Header().Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("ETag %q and Last-Modified %q, want both", etag, lastModified)
+	}
+
+	w = request(t, app, "GET", "/movies/1", "", "If-None-Match", etag)
+	expectStatus(t, w, http.StatusNotModified)
+	if w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+		t.Errorf("304 with a body %q or without the ETag", w.Body.String())
+	}
+
+	w = request(t, app, "GET", "/movies/1", "", "If-Modified-Since", lastModified)
+	expectStatus(t, w, http.StatusNotModified)
+
+	// the list has a tag of its own, for each query
+	w = request(t, app, "GET", "/movies?limit=2", "")
+	expectStatus(t, w, http.StatusOK)
+	listTag := w.Header().Get("ETag")
+
+	w = request(t, app, "GET", "/movies?limit=2", "", "If-None-Match", listTag)
+	expectStatus(t, w, http.StatusNotModified)
+	w = request(t, app, "GET", "/movies?limit=3", "", "If-None-Match", listTag)
+	expectStatus(t, w, http.StatusOK)
+
+	// a change gives the movie and the list new tags, and no Last-Modified for now
+	w = request(t, app, "PATCH", "/admin/movies/1", `{"title":"Highlander","release_date":"1986-03-07T00:00:00Z","runtime":117,"mpaa_rating":"R"}`,
+		"Authorization", adminAuth(t, app), "If-Match", etag)
+	expectStatus(t, w, http.StatusAccepted)
+
+	w = request(t, app, "GET", "/movies/1", "", "If-None-Match", etag)
+	expectStatus(t, w, http.StatusOK)
+	if w.Header().Get("ETag") == etag {
+		t.Error("the ETag didn't change")
+	}
+
+	w = request(t, app, "GET", "/movies/1", "", "If-Modified-Since", lastModified)
+	expectStatus(t, w, http.StatusOK)
+	if w.Header().Get("Last-Modified") != "" {
+		t.Errorf("Last-Modified %q in the second of the change", w.Header().Get("Last-Modified"))
+	}
+
+	w = request(t, app, "GET", "/movies?limit=2", "", "If-None-Match", listTag)
+	expectStatus(t, w, http.StatusOK)
+}
@@ -1,17 +1,56 @@
 package main
 
 import (
+	"backend/internal/repository/dbrepo"
+	"backend/internal/repository/memrepo"
 	"database/sql"
+	"fmt"
 	"log"
+	"strings"
 
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
 	_ "github.com/jackc/pgx/v4/stdlib"
+	_ "modernc.org/sqlite"
 )
 
+// sqlitePragmas are applied to every SQLite connection: foreign keys make deleting a
+// movie delete its movies_genres rows (like "on delete cascade" does in postgres), WAL
+// and the busy timeout let readers and a writer work at the same time. Transactions take
+// the write lock when they begin ("begin immediate"), so two of them never deadlock
+// trying to upgrade a read lock; the second one waits for the busy timeout instead.
+// Times are written in a format the date functions of SQLite can read.
+const sqlitePragmas = "_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_txlock=immediate&_time_format=sqlite"
+
+// driverFor picks the database/sql driver from the scheme of the DSN. "sqlite://movies.db"
+// and "file:movies.db" open a SQLite file, anything else (a postgres:// url or the
+// "host=... port=..." form) goes to postgres.
+func driverFor(dsn string) (driver string, source string) {
+	switch {
+	case strings.HasPrefix(dsn, "sqlite://"):
+		source = strings.TrimPrefix(dsn, "sqlite://")
+	case strings.HasPrefix(dsn, "sqlite:"):
+		source = strings.TrimPrefix(dsn, "sqlite:")
+	case strings.HasPrefix(dsn, "file:"):
+		source = dsn
+	default:
+		return "pgx", dsn
+	}
+
+	if strings.Contains(source, "?") {
+		source += "&" + sqlitePragmas
+	} else {
+		source += "?" + sqlitePragmas
+	}
+
+	return "sqlite", source
+}
+
 // *sql.DB is pointer to a pool of database connections
 func openDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("pgx", dsn)
+	driver, source := driverFor(dsn)
+
+	db, err := sql.Open(driver, source)
 	if err != nil {
 		return nil, err
 	}
@@ -30,6 +69,53 @@ func (app *application) connectToDB() (*sql.DB, error) {
 		return nil, err
 	}
 
-	log.Println("Connected to Postgres!")
+	if driver, _ := driverFor(app.DSN); driver == "sqlite" {
+		log.Println("Connected to SQLite!")
+	} else {
+		log.Println("Connected to Postgres!")
+	}
 	return connection, nil
 }
+
+// openRepo sets app.DB up for the repository picked with -repo. The returned function
+// releases the database connections.
+func (app *application) openRepo() (func(), error) {
+	switch app.Repo {
+	case "sql":
+		// connect to the database
+		conn, err := app.connectToDB()
+		if err != nil {
+			return nil, err
+		}
+
+		err = app.checkSchema(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		// the scheme of the DSN tells which database we are talking to
+		if driver, _ := driverFor(app.DSN); driver == "sqlite" {
+			app.DB = &dbrepo.SQLiteDBRepo{DB: conn, Timeouts: app.DBTimeouts, Tx: app.TxOptions}
+		} else {
+			// app.DB = conn
+			app.DB = &dbrepo.PostgresDBRepo{DB: conn, Timeouts: app.DBTimeouts, Tx: app.TxOptions}
+		}
+
+		return func() { conn.Close() }, nil
+	case "memory":
+		// everything lives in memory and is gone when the application stops. Handy for
+		// working on the front end without having to start postgres in docker.
+		repo := memrepo.New()
+		err := repo.Seed(memrepo.DefaultFixtures())
+		if err != nil {
+			return nil, err
+		}
+		app.DB = repo
+		log.Println("Using the in-memory repository")
+
+		return func() {}, nil
+	}
+
+	return nil, fmt.Errorf("unknown repo %q, use sql or memory", app.Repo)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"backend/internal/metadata"
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/revisions"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// enrichment is the answer of EnrichMovie: the match found in the movie database, what it
+// would change and the movie with the changes
+type enrichment struct {
+	Match   *metadata.Movie         `json:"match"`
+	Changes []revisions.FieldChange `json:"changes"`
+	Movie   *models.Movie           `json:"movie"`
+	Saved   bool                    `json:"saved"`
+}
+
+// EnrichMovie looks a movie up in the movie database by title and release year and
+// proposes to fill in its description, runtime, release date, rating and poster, e.g.
+// "POST /admin/movies/1/enrich?fields=description,runtime". An uploaded poster is only
+// replaced when fields names the image. Nothing is saved unless save=true is given, then
+// If-Match is required like for any update.
+func (app *application) EnrichMovie(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid movie id"))
+		return
+	}
+
+	if app.Metadata == nil {
+		app.errorJSON(w, errors.New("no movie database is configured, see -tmdb-token"), http.StatusServiceUnavailable)
+		return
+	}
+
+	fields := metadata.Fields
+	named := r.URL.Query().Get("fields")
+	if named != "" {
+		fields = strings.Split(named, ",")
+	}
+
+	save := false
+	if value := r.URL.Query().Get("save"); value != "" {
+		save, err = strconv.ParseBool(value)
+		if err != nil {
+			app.errorJSON(w, errors.New("save must be true or false"))
+			return
+		}
+	}
+
+	var version time.Time
+	if save {
+		version, err = ifMatchVersion(r)
+		if err != nil {
+			app.errorJSON(w, err, preconditionStatus(err))
+			return
+		}
+	}
+
+	movie, err := app.DB.OneMovie(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("movie not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	// a poster an admin uploaded beats the one of the movie database, unless the image is
+	// asked for by name. The uploaded one stays in the blob store, the revisions of the
+	// movie point to it.
+	if named == "" && strings.HasPrefix(movie.Image, imagesPath) {
+		fields = withoutField(fields, "image")
+	}
+
+	// the lookup is done before the transaction, which shouldn't wait on another server
+	match, err := app.Metadata.FindMovie(r.Context(), movie.Title, movie.ReleaseDate.Year())
+	if err != nil {
+		if errors.Is(err, metadata.ErrNotFound) {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusBadGateway)
+		return
+	}
+
+	proposed := *movie
+	err = metadata.Apply(&proposed, match, fields)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	result := enrichment{
+		Match:   match,
+		Changes: revisions.Diff(movie, &proposed),
+		Movie:   &proposed,
+	}
+
+	if !save {
+		_ = app.writeJSON(w, http.StatusOK, JSONResponse{
+			Error:   false,
+			Message: "proposed changes, nothing was saved",
+			Data:    result,
+		})
+		return
+	}
+
+	proposed.UpdatedAt = time.Now().UTC()
+
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		err := repo.UpdateMovie(r.Context(), proposed, version)
+		if err != nil {
+			return err
+		}
+
+		_, err = revisions.Record(r.Context(), repo, id, userID(r), revisions.ActionEnrich)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("movie not found"), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, repository.ErrVersionMismatch) {
+			app.errorJSON(w, err, http.StatusPreconditionFailed)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	result.Saved = true
+
+	headers := http.Header{}
+	headers.Set("ETag", movieETag(proposed.UpdatedAt))
+
+	app.writeJSON(w, http.StatusAccepted, JSONResponse{
+		Error:   false,
+		Message: "movie enriched",
+		Data:    result,
+	}, headers)
+}
+
+// withoutField returns a copy of fields without name
+func withoutField(fields []string, name string) []string {
+	kept := []string{}
+	for _, field := range fields {
+		if field != name {
+			kept = append(kept, field)
+		}
+	}
+	return kept
+}
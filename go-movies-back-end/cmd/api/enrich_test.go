@@ -0,0 +1,189 @@
+package main
+
+import (
+	"backend/internal/importer"
+	"backend/internal/metadata"
+	"backend/internal/metadata/faketmdb"
+	"backend/internal/models"
+	"backend/internal/revisions"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// enrichResponse is the JSONResponse of EnrichMovie
+type enrichResponse struct {
+	Message string `json:"message"`
+	Data    struct {
+		Match   metadata.Movie `json:"match"`
+		Changes []struct {
+			Field string `json:"field"`
+		} `json:"changes"`
+		Movie models.Movie `json:"movie"`
+		Saved bool         `json:"saved"`
+	} `json:"data"`
+}
+
+func TestEnrichMovie(t *testing.T) {
+	server := faketmdb.NewServer(faketmdb.DefaultMovies)
+	defer server.Close()
+
+	app := newTestApp(t)
+	app.Metadata = metadata.NewTMDB(server.URL+"/3", "", importer.MPAARatings)
+	auth := adminAuth(t, app)
+
+	w := request(t, app, "GET", "/movies/3", "")
+	expectStatus(t, w, http.StatusOK)
+	etag := w.Header().Get("ETag")
+
+	// our release date of The Godfather is off by ten days
+	released := time.Date(1972, time.March, 14, 0, 0, 0, 0, time.UTC)
+
+	var resp enrichResponse
+
+	w = request(t, app, "POST", "/admin/movies/3/enrich", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusOK)
+	decode(t, w, &resp)
+
+	if resp.Data.Saved || resp.Data.Match.ProviderID != "tmdb:238" || !resp.Data.Movie.ReleaseDate.Equal(released) {
+		t.Errorf("enrichment = %+v", resp.Data)
+	}
+	if !hasChange(resp, "release_date") {
+		t.Errorf("changes = %+v, want the release date", resp.Data.Changes)
+	}
+
+	// nothing was saved
+	w = request(t, app, "GET", "/movies/3", "", "If-None-Match", etag)
+	expectStatus(t, w, http.StatusNotModified)
+
+	w = request(t, app, "POST", "/admin/movies/3/enrich?fields=runtime", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusOK)
+	decode(t, w, &resp)
+	if hasChange(resp, "release_date") {
+		t.Errorf("changes = %+v, want the runtime only", resp.Data.Changes)
+	}
+
+	w = request(t, app, "POST", "/admin/movies/3/enrich?fields=title", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusBadRequest)
+}
+
+func TestEnrichMovieSave(t *testing.T) {
+	server := faketmdb.NewServer(faketmdb.DefaultMovies)
+	defer server.Close()
+
+	app := newTestApp(t)
+	app.Metadata = metadata.NewTMDB(server.URL+"/3", "", importer.MPAARatings)
+	auth := adminAuth(t, app)
+
+	w := request(t, app, "GET", "/movies/3", "")
+	expectStatus(t, w, http.StatusOK)
+	etag := w.Header().Get("ETag")
+
+	w = request(t, app, "POST", "/admin/movies/3/enrich?save=true", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusPreconditionRequired)
+
+	w = request(t, app, "POST", "/admin/movies/3/enrich?save=true", "", "Authorization", auth, "If-Match", `"1"`)
+	expectStatus(t, w, http.StatusPreconditionFailed)
+
+	w = request(t, app, "POST", "/admin/movies/3/enrich?save=true", "", "Authorization", auth, "If-Match", etag)
+	expectStatus(t, w, http.StatusAccepted)
+
+	var resp enrichResponse
+	decode(t, w, &resp)
+	if !resp.Data.Saved {
+		t.Error("not saved")
+	}
+
+	w = request(t, app, "GET", "/movies/3", "", "If-None-Match", etag)
+	expectStatus(t, w, http.StatusOK)
+
+	var movie models.Movie
+	decode(t, w, &movie)
+	if !movie.ReleaseDate.Equal(time.Date(1972, time.March, 14, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("release date %v after saving", movie.ReleaseDate)
+	}
+
+	history, err := app.DB.MovieRevisions(context.Background(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Action != revisions.ActionEnrich {
+		t.Errorf("revisions = %+v, want one enrich", history)
+	}
+}
+
+// a poster an admin uploaded stays unless the image is asked for
+func TestEnrichMovieKeepsUploadedPoster(t *testing.T) {
+	server := faketmdb.NewServer(faketmdb.DefaultMovies)
+	defer server.Close()
+
+	app := newTestApp(t)
+	app.Metadata = metadata.NewTMDB(server.URL+"/3", "", importer.MPAARatings)
+	auth := adminAuth(t, app)
+
+	ctx := context.Background()
+	movie, err := app.DB.OneMovie(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploaded := imagesPath + "posters/3-5f2c0e9a11b3d4e7.png"
+	movie.Image = uploaded
+	err = app.DB.UpdateMovie(ctx, *movie, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp enrichResponse
+
+	w := request(t, app, "POST", "/admin/movies/3/enrich", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusOK)
+	decode(t, w, &resp)
+	if hasChange(resp, "image") || resp.Data.Movie.Image != uploaded {
+		t.Errorf("enrichment = %+v, want the uploaded poster kept", resp.Data)
+	}
+	if !hasChange(resp, "release_date") {
+		t.Errorf("changes = %+v, want the other fields still", resp.Data.Changes)
+	}
+
+	w = request(t, app, "POST", "/admin/movies/3/enrich?fields=image,runtime", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusOK)
+	decode(t, w, &resp)
+	if !hasChange(resp, "image") || resp.Data.Movie.Image == uploaded {
+		t.Errorf("enrichment = %+v, want the poster of the movie database", resp.Data)
+	}
+}
+
+func TestEnrichMovieUnavailable(t *testing.T) {
+	app := newTestApp(t)
+	auth := adminAuth(t, app)
+
+	w := request(t, app, "POST", "/admin/movies/3/enrich", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusServiceUnavailable)
+
+	// a database that doesn't know the movie
+	server := faketmdb.NewServer(nil)
+	defer server.Close()
+	app.Metadata = metadata.NewTMDB(server.URL+"/3", "", importer.MPAARatings)
+
+	w = request(t, app, "POST", "/admin/movies/3/enrich", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusNotFound)
+
+	w = request(t, app, "POST", "/admin/movies/99/enrich", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusNotFound)
+
+	// a database that doesn't answer
+	server.Close()
+
+	w = request(t, app, "POST", "/admin/movies/3/enrich", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusBadGateway)
+}
+
+func hasChange(resp enrichResponse, field string) bool {
+	for _, change := range resp.Data.Changes {
+		if change.Field == field {
+			return true
+		}
+	}
+	return false
+}
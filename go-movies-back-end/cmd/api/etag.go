@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errIfMatchMissing = errors.New("If-Match is required, send the ETag the movie was read with")
+	errIfMatchInvalid = errors.New("If-Match is not an ETag of this movie")
+)
+
+// movieETag is the entity tag of a movie: the time it was last changed, in microseconds.
+// Every change of a movie sets updated_at, so the tag changes with it.
+func movieETag(updatedAt time.Time) string {
+	return `"` + strconv.FormatInt(updatedAt.UnixMicro(), 10) + `"`
+}
+
+// ifMatchVersion reads the If-Match header of a request that changes a movie and returns
+// the updated_at the client read the movie with. "*" matches any version and gives the
+// zero time. errIfMatchMissing and errIfMatchInvalid tell what is wrong with the header.
+func ifMatchVersion(r *http.Request) (time.Time, error) {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+
+	switch {
+	case value == "":
+		return time.Time{}, errIfMatchMissing
+	case value == "*":
+		return time.Time{}, nil
+	}
+
+	// a weak tag or a list of tags can't come from movieETag
+	if len(value) < 3 || value[0] != '"' || value[len(value)-1] != '"' {
+		return time.Time{}, errIfMatchInvalid
+	}
+
+	micros, err := strconv.ParseInt(value[1:len(value)-1], 10, 64)
+	if err != nil || micros <= 0 {
+		return time.Time{}, errIfMatchInvalid
+	}
+
+	return time.UnixMicro(micros).UTC(), nil
+}
+
+// preconditionStatus is the status of a request whose If-Match is missing or doesn't
+// match the movie
+func preconditionStatus(err error) int {
+	if errors.Is(err, errIfMatchMissing) {
+		return http.StatusPreconditionRequired
+	}
+	return http.StatusPreconditionFailed
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIfMatchVersion(t *testing.T) {
+	updatedAt := time.Date(2022, time.September, 23, 10, 0, 0, 123456000, time.UTC)
+
+	tests := []struct {
+		ifMatch string
+		version time.Time
+		err     error
+	}{
+		{movieETag(updatedAt), updatedAt, nil},
+		{"*", time.Time{}, nil},
+		{"", time.Time{}, errIfMatchMissing},
+		{`W/"1663927200123456"`, time.Time{}, errIfMatchInvalid},
+		{`"1", "2"`, time.Time{}, errIfMatchInvalid},
+		{`"abc"`, time.Time{}, errIfMatchInvalid},
+		{`"0"`, time.Time{}, errIfMatchInvalid},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("DELETE", "/admin/movies/1", nil)
+		if tt.ifMatch != "" {
+			r.Header.Set("If-Match", tt.ifMatch)
+		}
+
+		version, err := ifMatchVersion(r)
+		if !errors.Is(err, tt.err) || !version.Equal(tt.version) {
+			t.Errorf("If-Match %s: %v, %v; want %v, %v", tt.ifMatch, version, err, tt.version, tt.err)
+		}
+	}
+}
+
+func TestDeleteMovieIfMatch(t *testing.T) {
+	app := newTestApp(t)
+	auth := adminAuth(t, app)
+
+	w := request(t, app, "GET", "/movies/2", "")
+	expectStatus(t, w, http.StatusOK)
+	etag := w.Header().Get("ETag")
+
+	w = request(t, app, "DELETE", "/admin/movies/2", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusPreconditionRequired)
+
+	w = request(t, app, "DELETE", "/admin/movies/2", "", "Authorization", auth, "If-Match", `"1"`)
+	expectStatus(t, w, http.StatusPreconditionFailed)
+
+	w = request(t, app, "DELETE", "/admin/movies/2", "", "Authorization", auth, "If-Match", etag)
+	expectStatus(t, w, http.StatusAccepted)
+
+	w = request(t, app, "GET", "/movies/2", "")
+	expectStatus(t, w, http.StatusNotFound)
+}
+
+// two admins edit the same movie, the second one saves over a version that is gone
+func TestUpdateMovieLostUpdate(t *testing.T) {
+	app := newTestApp(t)
+	auth := adminAuth(t, app)
+
+	w := request(t, app, "GET", "/movies/1", "")
+	expectStatus(t, w, http.StatusOK)
+	etag := w.Header().Get("ETag")
+
+	body := `{"title":"Highlander","release_date":"1986-03-07T00:00:00Z","runtime":117,"mpaa_rating":"R"}`
+
+	w = request(t, app, "PATCH", "/admin/movies/1", body, "Authorization", auth, "If-Match", etag)
+	expectStatus(t, w, http.StatusAccepted)
+	newTag := w.Header().Get("ETag")
+
+	w = request(t, app, "PATCH", "/admin/movies/1", body, "Authorization", auth, "If-Match", etag)
+	expectStatus(t, w, http.StatusPreconditionFailed)
+
+	// the ETag of the answer is the one of the movie now
+	w = request(t, app, "GET", "/movies/1", "", "If-None-Match", newTag)
+	expectStatus(t, w, http.StatusNotModified)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"backend/internal/models"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// movieWriter writes an export in one format. begin and end frame the movies, e.g. the
+// header line of a CSV file or the brackets of a JSON array.
+type movieWriter interface {
+	contentType() string
+	begin() error
+	write(movie *models.Movie) error
+	end() error
+}
+
+func newMovieWriter(format string, w io.Writer) movieWriter {
+	switch format {
+	case "csv":
+		return &csvMovieWriter{w: csv.NewWriter(w)}
+	case "ndjson":
+		return &ndjsonMovieWriter{enc: json.NewEncoder(w)}
+	case "json":
+		return &jsonArrayMovieWriter{w: w}
+	}
+	return nil
+}
+
+// ExportMovies streams the whole catalogue, e.g. "/admin/movies/export?format=csv". The
+// movies are written out as the database returns them. The files have the columns and
+// fields the import reads, so an export can be imported again.
+func (app *application) ExportMovies(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+
+	mw := newMovieWriter(format, w)
+	if mw == nil {
+		app.errorJSON(w, errors.New("format must be csv, ndjson or json"))
+		return
+	}
+
+	// nothing is sent before the first movie arrives, so that a query that fails right
+	// away can still be answered with a proper error
+	started := false
+	start := func() error {
+		started = true
+		w.Header().Set("Content-Type", mw.contentType())
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.`+format+`"`)
+		return mw.begin()
+	}
+
+	err := app.DB.ExportMovies(r.Context(), func(movie *models.Movie) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		return mw.write(movie)
+	})
+
+	if err == nil && !started {
+		err = start()
+	}
+	if err == nil {
+		err = mw.end()
+	}
+
+	if err != nil {
+		if !started {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		// half of the file has gone out already. Cutting the connection is the only way
+		// left to tell the client that what it got is incomplete.
+		log.Println("export stopped:", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// csvMovieWriter writes one line per movie, the genres as names separated by "|"
+type csvMovieWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvMovieWriter) contentType() string {
+	return "text/csv; charset=utf-8"
+}
+
+func (c *csvMovieWriter) begin() error {
+	return c.w.Write([]string{"id", "title", "release_date", "runtime", "mpaa_rating", "description", "image", "genres"})
+}
+
+func (c *csvMovieWriter) write(movie *models.Movie) error {
+	var genres []string
+	for _, g := range movie.Genres {
+		genres = append(genres, g.Genre)
+	}
+
+	return c.w.Write([]string{
+		strconv.Itoa(movie.ID),
+		movie.Title,
+		movie.ReleaseDate.Format("2006-01-02"),
+		strconv.Itoa(movie.RunTime),
+		movie.MPAARating,
+		movie.Description,
+		movie.Image,
+		strings.Join(genres, "|"),
+	})
+}
+
+func (c *csvMovieWriter) end() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// ndjsonMovieWriter writes every movie as a JSON object on a line of its own
+type ndjsonMovieWriter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonMovieWriter) contentType() string {
+	return "application/x-ndjson"
+}
+
+func (n *ndjsonMovieWriter) begin() error {
+	return nil
+}
+
+func (n *ndjsonMovieWriter) write(movie *models.Movie) error {
+	return n.enc.Encode(movie)
+}
+
+func (n *ndjsonMovieWriter) end() error {
+	return nil
+}
+
+// jsonArrayMovieWriter writes a single JSON array, one movie at a time
+type jsonArrayMovieWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (j *jsonArrayMovieWriter) contentType() string {
+	return "application/json"
+}
+
+func (j *jsonArrayMovieWriter) begin() error {
+	_, err := io.WriteString(j.w, "[")
+	return err
+}
+
+func (j *jsonArrayMovieWriter) write(movie *models.Movie) error {
+	out, err := json.Marshal(movie)
+	if err != nil {
+		return err
+	}
+
+	if j.count > 0 {
+		out = append([]byte(",\n"), out...)
+	}
+	j.count++
+
+	_, err = j.w.Write(out)
+	return err
+}
+
+func (j *jsonArrayMovieWriter) end() error {
+	_, err := io.WriteString(j.w, "]\n")
+	return err
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"backend/internal/importer"
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/repository/memrepo"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExportMovies(t *testing.T) {
+	app := newTestApp(t)
+	auth := adminAuth(t, app)
+
+	tests := []struct {
+		format      string
+		contentType string
+		movies      func(t *testing.T, body string) int
+	}{
+		{"csv", "text/csv; charset=utf-8", func(t *testing.T, body string) int {
+			records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(records[0], ",") != "id,title,release_date,runtime,mpaa_rating,description,image,genres" {
+				t.Errorf("header = %v", records[0])
+			}
+			for _, record := range records[1:] {
+				if record[1] == "Highlander" && record[7] != "Action|Fantasy" {
+					t.Errorf("Highlander = %v", record)
+				}
+			}
+			return len(records) - 1
+		}},
+		{"ndjson", "application/x-ndjson", func(t *testing.T, body string) int {
+			lines := strings.Split(strings.TrimSpace(body), "\n")
+			for _, line := range lines {
+				var movie models.Movie
+				err := json.Unmarshal([]byte(line), &movie)
+				if err != nil || movie.Title == "" || len(movie.Genres) == 0 {
+					t.Errorf("line %q: %+v, %v", line, movie, err)
+				}
+			}
+			return len(lines)
+		}},
+		{"json", "application/json", func(t *testing.T, body string) int {
+			var movies []models.Movie
+			err := json.Unmarshal([]byte(body), &movies)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return len(movies)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			w := request(t, app, "GET", "/admin/movies/export?format="+tt.format, "", "Authorization", auth)
+			expectStatus(t, w, http.StatusOK)
+
+			if got := w.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="movies.`+tt.format+`"` {
+				t.Errorf("Content-Disposition = %q", got)
+			}
+
+			body := w.Body.String()
+			if n := tt.movies(t, body); n != 3 {
+				t.Errorf("%d movies, want 3", n)
+			}
+
+			// an export can be imported again, every movie matches the one it came from
+			report, err := importer.Import(context.Background(), app.DB, strings.NewReader(body), importer.Options{Format: tt.format, DryRun: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Updated != 3 || report.Created != 0 || report.Rejected != 0 {
+				t.Errorf("importing the export: %+v", report)
+			}
+		})
+	}
+
+	w := request(t, app, "GET", "/admin/movies/export", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusOK)
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("the default format is %q, want json", w.Header().Get("Content-Type"))
+	}
+
+	w = request(t, app, "GET", "/admin/movies/export?format=xml", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusBadRequest)
+
+	w = request(t, app, "GET", "/admin/movies/export", "")
+	expectStatus(t, w, http.StatusUnauthorized)
+}
+
+func TestExportEmpty(t *testing.T) {
+	app := newTestApp(t)
+	app.DB = memrepo.New()
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"csv", "id,title,release_date,runtime,mpaa_rating,description,image,genres\n"},
+		{"ndjson", ""},
+		{"json", "[]\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			app.ExportMovies(w, httptest.NewRequest("GET", "/admin/movies/export?format="+tt.format, nil))
+
+			expectStatus(t, w, http.StatusOK)
+			if w.Body.String() != tt.want {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+// failingExport hands out some movies, then fails
+type failingExport struct {
+	repository.DatabaseRepo
+	movies int
+}
+
+func (f *failingExport) ExportMovies(ctx context.Context, fn func(movie *models.Movie) error) error {
+	for i := 0; i < f.movies; i++ {
+		err := fn(&models.Movie{ID: i + 1, Title: "Movie"})
+		if err != nil {
+			return err
+		}
+	}
+	return errors.New("connection lost")
+}
+
+func TestExportFails(t *testing.T) {
+	app := newTestApp(t)
+	repo := app.DB
+
+	// before the first movie there is still time for an error
+	app.DB = &failingExport{DatabaseRepo: repo}
+
+	w := httptest.NewRecorder()
+	app.ExportMovies(w, httptest.NewRequest("GET", "/admin/movies/export?format=csv", nil))
+	expectStatus(t, w, http.StatusInternalServerError)
+	if w.Header().Get("Content-Disposition") != "" {
+		t.Error("the error is sent as a file")
+	}
+
+	// after it, the connection is cut
+	app.DB = &failingExport{DatabaseRepo: repo, movies: 2}
+
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", err)
+		}
+	}()
+
+	app.ExportMovies(httptest.NewRecorder(), httptest.NewRequest("GET", "/admin/movies/export?format=csv", nil))
+	t.Error("a broken export ended normally")
+}
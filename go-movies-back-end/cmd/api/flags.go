@@ -0,0 +1,143 @@
+package main
+
+import (
+	"backend/internal/poster"
+	"backend/internal/repository"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// operationTimeouts is the -db-timeouts flag. It reads a comma separated list of
+// Operation=duration pairs into the PerOperation deadlines of the repository.
+type operationTimeouts struct {
+	timeouts *repository.Timeouts
+}
+
+func (o operationTimeouts) String() string {
+	if o.timeouts == nil {
+		return ""
+	}
+
+	var pairs []string
+	for operation, d := range o.timeouts.PerOperation {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", operation, d))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func (o operationTimeouts) Set(value string) error {
+	if o.timeouts.PerOperation == nil {
+		o.timeouts.PerOperation = make(map[string]time.Duration)
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		operation, duration, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("%q should look like Operation=duration", pair)
+		}
+
+		d, err := time.ParseDuration(strings.TrimSpace(duration))
+		if err != nil {
+			return err
+		}
+
+		if d <= 0 {
+			return fmt.Errorf("the timeout of %s must be positive", operation)
+		}
+
+		o.timeouts.PerOperation[strings.TrimSpace(operation)] = d
+	}
+
+	return nil
+}
+
+// isolationLevels are the values accepted by the -tx-isolation flag
+var isolationLevels = map[string]sql.IsolationLevel{
+	"default":          sql.LevelDefault,
+	"read-committed":   sql.LevelReadCommitted,
+	"repeatable-read":  sql.LevelRepeatableRead,
+	"serializable":     sql.LevelSerializable,
+	"read-uncommitted": sql.LevelReadUncommitted,
+}
+
+// isolationLevel is the -tx-isolation flag
+type isolationLevel struct {
+	level *sql.IsolationLevel
+}
+
+func (l isolationLevel) String() string {
+	if l.level == nil {
+		return ""
+	}
+
+	for name, level := range isolationLevels {
+		if level == *l.level {
+			return name
+		}
+	}
+
+	return l.level.String()
+}
+
+func (l isolationLevel) Set(value string) error {
+	level, ok := isolationLevels[value]
+	if !ok {
+		return fmt.Errorf("unknown isolation level %q, use default, read-committed, repeatable-read or serializable", value)
+	}
+
+	*l.level = level
+	return nil
+}
+
+// defaultImageSizes are the sizes of the poster variants when -image-sizes isn't given:
+// the thumbnails of the lists and the poster of the movie page, at 1x and 2x
+var defaultImageSizes = []poster.Size{{Width: 92, Height: 138}, {Width: 185, Height: 278}, {Width: 300, Height: 450}, {Width: 600, Height: 900}}
+
+// imageSizes is the -image-sizes flag, a comma separated list of sizes like 300x450
+type imageSizes struct {
+	sizes *[]poster.Size
+}
+
+func (s imageSizes) String() string {
+	if s.sizes == nil {
+		return ""
+	}
+
+	var names []string
+	for _, size := range *s.sizes {
+		names = append(names, size.String())
+	}
+
+	return strings.Join(names, ",")
+}
+
+func (s imageSizes) Set(value string) error {
+	var sizes []poster.Size
+
+	for _, name := range strings.Split(value, ",") {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+
+		size, err := poster.ParseSize(name)
+		if err != nil {
+			return err
+		}
+
+		if size.Width*size.Height > 4_000_000 {
+			return fmt.Errorf("%s is too large for a poster variant", size)
+		}
+
+		sizes = append(sizes, size)
+	}
+
+	*s.sizes = sizes
+	return nil
+}
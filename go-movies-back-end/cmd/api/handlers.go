@@ -1,11 +1,20 @@
 package main
 
 import (
+	"backend/internal/graph"
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/revisions"
+	"database/sql"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
-	"github.com/golang-jwt/jwt/v4"
+	"github.com/go-chi/chi/v5"
 )
 
 func (app *application) Home(w http.ResponseWriter, r *http.Request) {
@@ -73,7 +82,18 @@ func (app *application) AllMovies(w http.ResponseWriter, r *http.Request) {
 
 	// movies = append(movies, rotla)
 
-	movies, err := app.DB.AllMovies()
+	opts, err := readMovieListOptions(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	headers, done := app.movieListHeaders(w, r, true)
+	if done {
+		return
+	}
+
+	page, err := app.DB.AllMovies(r.Context(), opts)
 	if err != nil {
 		// fmt.Println(err)
 		app.errorJSON(w, err)
@@ -93,9 +113,236 @@ func (app *application) AllMovies(w http.ResponseWriter, r *http.Request) {
 	// w.WriteHeader(http.StatusOK)
 	// w.Write(out)
 
+	_ = app.writeJSON(w, http.StatusOK, page, headers)
+}
+
+// movieListHeaders gives the cache headers of a list of movies. The list isn't read
+// when the client has it already: it gets 304 Not Modified and done is true, as it is
+// when the last change of the catalogue can't be read.
+func (app *application) movieListHeaders(w http.ResponseWriter, r *http.Request, public bool) (headers http.Header, done bool) {
+	lastChange, err := app.DB.LastMovieChange(r.Context())
+	if err != nil {
+		app.errorJSON(w, err)
+		return nil, true
+	}
+
+	headers = app.cacheHeaders(listETag(r, lastChange), lastChange, public)
+
+	return headers, app.notModified(w, r, headers)
+}
+
+// readMovieListOptions reads paging, sorting and filtering of a movie listing from the
+// query string, e.g. "/movies?sort=release_date&order=desc&rating=PG,PG-13&limit=50".
+func readMovieListOptions(r *http.Request) (repository.MovieListOptions, error) {
+	q := r.URL.Query()
+
+	opts := repository.MovieListOptions{
+		After: q.Get("after"),
+		Limit: repository.DefaultPageSize,
+		Sort:  repository.SortByTitle,
+	}
+
+	if sort := q.Get("sort"); sort != "" {
+		if !repository.ValidSort(sort) {
+			return opts, errors.New("sort must be one of title, release_date or runtime")
+		}
+		opts.Sort = sort
+	}
+
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		return opts, errors.New("order must be asc or desc")
+	}
+
+	// ratings can be given as "rating=PG&rating=R" or as "rating=PG,R"
+	for _, rating := range q["rating"] {
+		for _, rt := range strings.Split(rating, ",") {
+			if rt = strings.TrimSpace(rt); rt != "" {
+				opts.MPAARatings = append(opts.MPAARatings, rt)
+			}
+		}
+	}
+
+	numbers := []struct {
+		name string
+		dest *int
+	}{
+		{"limit", &opts.Limit},
+		{"year_from", &opts.YearFrom},
+		{"year_to", &opts.YearTo},
+		{"runtime_min", &opts.RuntimeMin},
+		{"runtime_max", &opts.RuntimeMax},
+	}
+
+	for _, n := range numbers {
+		value := q.Get(n.name)
+		if value == "" {
+			continue
+		}
+
+		i, err := strconv.Atoi(value)
+		if err != nil || i < 0 {
+			return opts, fmt.Errorf("%s must be a non-negative number", n.name)
+		}
+		*n.dest = i
+	}
+
+	if opts.Limit < 1 || opts.Limit > repository.MaxPageSize {
+		return opts, fmt.Errorf("limit must be between 1 and %d", repository.MaxPageSize)
+	}
+
+	if opts.After != "" {
+		if _, err := repository.DecodeCursor(opts.After, opts.Sort); err != nil {
+			return opts, err
+		}
+	}
+
+	return opts, nil
+}
+
+func (app *application) GetMovie(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid movie id"))
+		return
+	}
+
+	movie, err := app.DB.OneMovie(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("movie not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	// the ETag is also the one UpdateMovie and DeleteMovie expect in If-Match
+	headers := app.cacheHeaders(movieETag(movie.UpdatedAt), movie.UpdatedAt, true)
+	if app.notModified(w, r, headers) {
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, movie, headers)
+}
+
+func (app *application) SearchMovies(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	query := strings.TrimSpace(q.Get("q"))
+	if query == "" {
+		app.errorJSON(w, errors.New("q is required"))
+		return
+	}
+
+	opts := repository.SearchOptions{
+		Language: repository.DefaultSearchLanguage,
+		Limit:    repository.DefaultPageSize,
+	}
+
+	if lang := q.Get("lang"); lang != "" {
+		if !repository.ValidSearchLanguage(lang) {
+			app.errorJSON(w, fmt.Errorf("unsupported language %q", lang))
+			return
+		}
+		opts.Language = lang
+	}
+
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > repository.MaxPageSize {
+			app.errorJSON(w, fmt.Errorf("limit must be between 1 and %d", repository.MaxPageSize))
+			return
+		}
+		opts.Limit = n
+	}
+
+	if offset := q.Get("offset"); offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			app.errorJSON(w, errors.New("offset must be a non-negative number"))
+			return
+		}
+		opts.Offset = n
+	}
+
+	results, err := app.DB.SearchMovies(r.Context(), query, opts)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if results == nil {
+		results = []*repository.SearchResult{}
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, results)
+}
+
+const (
+	defaultSuggestions = 10
+	maxSuggestions     = 25
+)
+
+func (app *application) SuggestTitles(w http.ResponseWriter, r *http.Request) {
+	prefix := strings.TrimSpace(r.URL.Query().Get("prefix"))
+	if prefix == "" {
+		app.errorJSON(w, errors.New("prefix is required"))
+		return
+	}
+
+	limit := defaultSuggestions
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxSuggestions {
+			app.errorJSON(w, fmt.Errorf("limit must be between 1 and %d", maxSuggestions))
+			return
+		}
+		limit = n
+	}
+
+	suggestions, err := app.DB.SuggestTitles(r.Context(), prefix, limit)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if suggestions == nil {
+		suggestions = []*repository.Suggestion{}
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, suggestions)
+}
+
+func (app *application) AllMoviesByGenre(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid genre id"))
+		return
+	}
+
+	movies, err := app.DB.AllMoviesByGenre(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
 	_ = app.writeJSON(w, http.StatusOK, movies)
 }
 
+func (app *application) AllGenres(w http.ResponseWriter, r *http.Request) {
+	genres, err := app.DB.AllGenres(r.Context())
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, genres)
+}
+
 func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 	// read json payload
 	var requestPayload struct {
@@ -110,7 +357,7 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// validate user against database
-	user, err := app.DB.GetUserByEmail(requestPayload.Email)
+	user, err := app.DB.GetUserByEmail(r.Context(), requestPayload.Email)
 	if err != nil {
 		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
 		return
@@ -123,15 +370,8 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// create a jwt user
-	u := jwtUser{
-		ID:        user.ID,
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
-	}
-
-	// generate tokens
-	tokens, err := app.auth.GenerateTokenPair(&u)
+	// generate tokens, the refresh token starts a new family
+	tokens, err := app.issueTokens(r.Context(), app.DB, user, "")
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -152,13 +392,8 @@ func (app *application) refreshToken(w http.ResponseWriter, r *http.Request) {
 	// find the cookie with refreshToken among all the cookies that came with user request
 	for _, cookie := range r.Cookies() {
 		if cookie.Name == app.auth.CookieName {
-			claims := &Claims{}
-			refreshToken := cookie.Value
-
 			// parse the refresh token to get the claims. Claims are several information regarding the user
-			_, err := jwt.ParseWithClaims(refreshToken, claims, func(token *jwt.Token) (interface{}, error) {
-				return []byte(app.JWTSecret), nil
-			})
+			claims, err := app.parseRefreshToken(cookie.Value)
 			if err != nil {
 				app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
 				return
@@ -171,23 +406,67 @@ func (app *application) refreshToken(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
+			// the server has the last word on refresh tokens: it revokes them on logout,
+			// password resets and reuse
+			stored, err := app.DB.GetRefreshToken(r.Context(), claims.ID)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+					return
+				}
+				app.errorJSON(w, err, http.StatusInternalServerError)
+				return
+			}
+
+			now := time.Now().UTC()
+			if stored.UserID != userID || stored.RevokedAt != nil || !stored.ExpiresAt.After(now) {
+				app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+				return
+			}
+
+			// every refresh token is good for one refresh, and the ones at the same time as it.
+			// A later one means it was copied.
+			if stored.UsedAt != nil && !reusable(stored, now) {
+				app.revokeReusedFamily(r, stored)
+				app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+				return
+			}
+
 			// get the user by id(the user id from claims) from the database
-			user, err := app.DB.GetUserByID(userID)
+			user, err := app.DB.GetUserByID(r.Context(), userID)
 			if err != nil {
 				app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
 				return
 			}
-
-			// create new jwtuser
-			u := jwtUser{
-				ID:        user.ID,
-				FirstName: user.FirstName,
-				LastName:  user.LastName,
+			if tokenRevoked(user, claims) {
+				app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+				return
 			}
 
-			// now generate token pairs
-			tokenPairs, err := app.auth.GenerateTokenPair(&u)
+			// now use up the token and generate the next token pair of its family
+			var tokenPairs TokenPairs
+			err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+				err := repo.UseRefreshToken(r.Context(), stored.ID, now)
+				if errors.Is(err, sql.ErrNoRows) {
+					// another request used the token, maybe since it was read
+					stored, err = repo.GetRefreshToken(r.Context(), stored.ID)
+					if err == nil && !reusable(stored, now) {
+						err = errRefreshReused
+					}
+				}
+				if err != nil {
+					return err
+				}
+
+				tokenPairs, err = app.issueTokens(r.Context(), repo, user, stored.FamilyID)
+				return err
+			})
 			if err != nil {
+				if errors.Is(err, errRefreshReused) {
+					app.revokeReusedFamily(r, stored)
+					app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+					return
+				}
 				app.errorJSON(w, errors.New("error generating tokens"), http.StatusUnauthorized)
 				return
 			}
@@ -203,12 +482,227 @@ func (app *application) refreshToken(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) logout(w http.ResponseWriter, r *http.Request) {
+	// the refresh token of the cookie stops working on the server too, with the rest of its
+	// family. A token that doesn't parse, e.g. an expired one, can't refresh anyway.
+	if cookie, err := r.Cookie(app.auth.CookieName); err == nil {
+		if claims, err := app.parseRefreshToken(cookie.Value); err == nil {
+			stored, err := app.DB.GetRefreshToken(r.Context(), claims.ID)
+			if err == nil {
+				err = app.DB.RevokeRefreshFamily(r.Context(), stored.FamilyID, time.Now().UTC())
+			}
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				app.errorJSON(w, err, http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
 	http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
 	w.WriteHeader(http.StatusAccepted)
 }
 
 func (app *application) MovieCatalog(w http.ResponseWriter, r *http.Request) {
-	movies, err := app.DB.AllMovies()
+	opts, err := readMovieListOptions(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	headers, done := app.movieListHeaders(w, r, false)
+	if done {
+		return
+	}
+
+	page, err := app.DB.AllMovies(r.Context(), opts)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, page, headers)
+}
+
+func (app *application) InsertMovie(w http.ResponseWriter, r *http.Request) {
+	var movie models.Movie
+
+	err := app.readJSON(w, r, &movie)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if movie.Title == "" {
+		app.errorJSON(w, errors.New("title is required"))
+		return
+	}
+
+	movie.CreatedAt = time.Now().UTC()
+	movie.UpdatedAt = movie.CreatedAt
+
+	// the movie and its genres are saved together, a bad genre id leaves no movie behind
+	var newID int
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		newID, err = repo.InsertMovie(r.Context(), movie)
+		if err != nil {
+			return err
+		}
+
+		// now that the movie has an id we can attach its genres
+		err = repo.UpdateMovieGenres(r.Context(), newID, movie.GenresArray)
+		if err != nil {
+			return err
+		}
+
+		_, err = revisions.Record(r.Context(), repo, newID, userID(r), revisions.ActionCreate)
+		return err
+	})
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "movie created",
+		Data:    newID,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// UpdateMovie changes the fields the body has and keeps the others, a PATCH with only a
+// runtime doesn't clear the image or the genres of the movie.
+func (app *application) UpdateMovie(w http.ResponseWriter, r *http.Request) {
+	// the id of the movie comes from the url, e.g. "/admin/movies/1"
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid movie id"))
+		return
+	}
+
+	// the movie is only saved if nobody else changed it since the client read it
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		app.errorJSON(w, err, preconditionStatus(err))
+		return
+	}
+
+	// the body goes over the movie as it is. Reading it outside the transaction is fine, the
+	// update fails if the movie changed since.
+	movie, err := app.DB.OneMovie(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("movie not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.readJSON(w, r, movie)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if movie.Title == "" {
+		app.errorJSON(w, errors.New("title is required"))
+		return
+	}
+
+	// the id in the url always wins over whatever came in the body. updated_at is in UTC
+	// so that the ETag sent back is the one the database will give.
+	movie.ID = id
+	movie.UpdatedAt = time.Now().UTC()
+
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		err := repo.UpdateMovie(r.Context(), *movie, version)
+		if err != nil {
+			return err
+		}
+
+		// genres_array is optional on update. When it is left out the movie keeps the genres
+		// it already has, when it is sent (even as an empty list) it replaces them.
+		if movie.GenresArray != nil {
+			err = repo.UpdateMovieGenres(r.Context(), movie.ID, movie.GenresArray)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = revisions.Record(r.Context(), repo, movie.ID, userID(r), revisions.ActionUpdate)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("movie not found"), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, repository.ErrVersionMismatch) {
+			app.errorJSON(w, err, http.StatusPreconditionFailed)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "movie updated",
+	}
+
+	headers := http.Header{}
+	headers.Set("ETag", movieETag(movie.UpdatedAt))
+
+	app.writeJSON(w, http.StatusAccepted, resp, headers)
+}
+
+func (app *application) DeleteMovie(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid movie id"))
+		return
+	}
+
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		app.errorJSON(w, err, preconditionStatus(err))
+		return
+	}
+
+	// the revision is taken first, a movie in the trash can't be read any more
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		_, err := revisions.Record(r.Context(), repo, id, userID(r), revisions.ActionDelete)
+		if err != nil {
+			return err
+		}
+
+		return repo.DeleteMovie(r.Context(), id, version)
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("movie not found"), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, repository.ErrVersionMismatch) {
+			app.errorJSON(w, err, http.StatusPreconditionFailed)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "movie moved to the trash",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// TrashedMovies lists the deleted movies that can still be restored
+func (app *application) TrashedMovies(w http.ResponseWriter, r *http.Request) {
+	movies, err := app.DB.TrashedMovies(r.Context())
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -216,3 +710,108 @@ func (app *application) MovieCatalog(w http.ResponseWriter, r *http.Request) {
 
 	_ = app.writeJSON(w, http.StatusOK, movies)
 }
+
+// RestoreMovie takes a movie out of the trash, e.g. "POST /admin/movies/trash/1/restore"
+func (app *application) RestoreMovie(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid movie id"))
+		return
+	}
+
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		err := repo.RestoreMovie(r.Context(), id)
+		if err != nil {
+			return err
+		}
+
+		_, err = revisions.Record(r.Context(), repo, id, userID(r), revisions.ActionRestore)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("movie not found in the trash"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "movie restored",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// PurgeMovie deletes a movie in the trash for good, e.g. "DELETE /admin/movies/trash/1"
+func (app *application) PurgeMovie(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid movie id"))
+		return
+	}
+
+	err = app.DB.PurgeMovie(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("movie not found in the trash"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "movie deleted for good",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+func (app *application) moviesGraphQL(w http.ResponseWriter, r *http.Request) {
+	g := graph.New(app.DB)
+
+	// GraphQL clients send {"query": "...", "variables": {...}} as JSON. For convenience we
+	// also accept the bare query string as the whole body, which is handy with curl.
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var requestPayload struct {
+			Query         string                 `json:"query"`
+			Variables     map[string]interface{} `json:"variables"`
+			OperationName string                 `json:"operationName"`
+		}
+
+		err := app.readJSON(w, r, &requestPayload)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+
+		g.QueryString = requestPayload.Query
+		g.Variables = requestPayload.Variables
+		g.OperationName = requestPayload.OperationName
+	} else {
+		r.Body = http.MaxBytesReader(w, r.Body, 1024*1024)
+		q, err := io.ReadAll(r.Body)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		g.QueryString = string(q)
+	}
+
+	if g.QueryString == "" {
+		app.errorJSON(w, errors.New("query is required"))
+		return
+	}
+
+	resp, err := g.Query(r.Context())
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, resp)
+}
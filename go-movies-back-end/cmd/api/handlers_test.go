@@ -0,0 +1,389 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAllMoviesPages(t *testing.T) {
+	app := newTestApp(t)
+
+	var titles []string
+	target := "/movies?limit=2&sort=release_date&order=desc"
+
+	// follow next_cursor until the last page, which has none
+	for pages := 0; ; pages++ {
+		if pages == 3 {
+			t.Fatal("more pages than movies")
+		}
+
+		w := request(t, app, "GET", target, "")
+		expectStatus(t, w, http.StatusOK)
+
+		var page repository.MoviePage
+		decode(t, w, &page)
+
+		for _, movie := range page.Movies {
+			titles = append(titles, movie.Title)
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		target = "/movies?limit=2&sort=release_date&order=desc&after=" + url.QueryEscape(page.NextCursor)
+	}
+
+	want := []string{"Highlander", "Raiders of the Lost Ark", "The Godfather"}
+	if len(titles) != len(want) {
+		t.Fatalf("got %v, want %v", titles, want)
+	}
+	for i := range want {
+		if titles[i] != want[i] {
+			t.Fatalf("got %v, want %v", titles, want)
+		}
+	}
+}
+
+func TestAllMoviesOptions(t *testing.T) {
+	app := newTestApp(t)
+
+	w := request(t, app, "GET", "/movies?limit=1", "")
+	expectStatus(t, w, http.StatusOK)
+
+	var page repository.MoviePage
+	decode(t, w, &page)
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+	}{
+		{"defaults", "", http.StatusOK},
+		{"filters", "?rating=PG-13,R&year_from=1980&runtime_max=120", http.StatusOK},
+		{"limit too big", "?limit=101", http.StatusBadRequest},
+		{"limit zero", "?limit=0", http.StatusBadRequest},
+		{"negative", "?runtime_min=-1", http.StatusBadRequest},
+		{"unknown sort", "?sort=budget", http.StatusBadRequest},
+		{"unknown order", "?order=up", http.StatusBadRequest},
+		{"broken cursor", "?after=nonsense", http.StatusBadRequest},
+		// a cursor only works with the order it came from
+		{"cursor of another sort", "?sort=runtime&after=" + url.QueryEscape(page.NextCursor), http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(t, app, "GET", "/movies"+tt.query, "")
+			expectStatus(t, w, tt.status)
+		})
+	}
+}
+
+func TestGetMovie(t *testing.T) {
+	app := newTestApp(t)
+
+	w := request(t, app, "GET", "/movies/3", "")
+	expectStatus(t, w, http.StatusOK)
+
+	var movie struct {
+		Title  string `json:"title"`
+		Genres []struct {
+			Genre string `json:"genre"`
+		} `json:"genres"`
+	}
+	decode(t, w, &movie)
+
+	if movie.Title != "The Godfather" || len(movie.Genres) != 2 {
+		t.Errorf("movie 3 = %+v", movie)
+	}
+
+	w = request(t, app, "GET", "/movies/99", "")
+	expectStatus(t, w, http.StatusNotFound)
+}
+
+func TestAllGenres(t *testing.T) {
+	app := newTestApp(t)
+
+	w := request(t, app, "GET", "/genres", "")
+	expectStatus(t, w, http.StatusOK)
+
+	var genres []models.Genre
+	decode(t, w, &genres)
+
+	if len(genres) != 13 || genres[0].Genre != "Action" || genres[12].Genre != "Thriller" {
+		t.Errorf("genres = %+v, want the 13 of the catalogue by name", genres)
+	}
+}
+
+func TestAllMoviesByGenre(t *testing.T) {
+	app := newTestApp(t)
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		want   string
+	}{
+		{"action", "/movies/genres/5", http.StatusOK, "Highlander|Raiders of the Lost Ark"},
+		{"crime", "/movies/genres/9", http.StatusOK, "The Godfather"},
+		{"no movies", "/movies/genres/1", http.StatusOK, ""},
+		{"bad id", "/movies/genres/action", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(t, app, "GET", tt.target, "")
+			expectStatus(t, w, tt.status)
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var movies []models.Movie
+			decode(t, w, &movies)
+
+			var titles []string
+			for _, movie := range movies {
+				titles = append(titles, movie.Title)
+			}
+			if got := strings.Join(titles, "|"); got != tt.want {
+				t.Errorf("titles = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchMovies(t *testing.T) {
+	app := newTestApp(t)
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		want   string
+	}{
+		{"description", "?q=immortal", http.StatusOK, "Highlander"},
+		{"two matches", "?q=new%20york", http.StatusOK, "The Godfather|Highlander"},
+		{"page", "?q=new%20york&limit=1&offset=1", http.StatusOK, "Highlander"},
+		{"language", "?q=immortal&lang=simple", http.StatusOK, "Highlander"},
+		{"no match", "?q=alien", http.StatusOK, ""},
+		{"no query", "", http.StatusBadRequest, ""},
+		{"unknown language", "?q=immortal&lang=klingon", http.StatusBadRequest, ""},
+		{"limit too big", "?q=immortal&limit=101", http.StatusBadRequest, ""},
+		{"negative offset", "?q=immortal&offset=-1", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(t, app, "GET", "/movies/search"+tt.query, "")
+			expectStatus(t, w, tt.status)
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var results []repository.SearchResult
+			decode(t, w, &results)
+			if results == nil {
+				t.Fatal("results = null")
+			}
+
+			var titles []string
+			for _, result := range results {
+				titles = append(titles, result.Movie.Title)
+			}
+			if got := strings.Join(titles, "|"); got != tt.want {
+				t.Errorf("titles = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	w := request(t, app, "GET", "/movies/search?q=immortal%20highlander", "")
+	expectStatus(t, w, http.StatusOK)
+
+	var results []repository.SearchResult
+	decode(t, w, &results)
+	if len(results) != 1 {
+		t.Fatalf("results = %+v", results)
+	}
+	if results[0].TitleHighlight != "<mark>Highlander</mark>" || !strings.Contains(results[0].Snippet, "<mark>immortal</mark>") {
+		t.Errorf("highlights = %q and %q", results[0].TitleHighlight, results[0].Snippet)
+	}
+}
+
+func TestSuggestTitles(t *testing.T) {
+	app := newTestApp(t)
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		want   string
+	}{
+		{"prefix", "?prefix=the%20god", http.StatusOK, "The Godfather"},
+		{"typo", "?prefix=godfater", http.StatusOK, "The Godfather"},
+		{"no match", "?prefix=zzzz", http.StatusOK, ""},
+		{"no prefix", "", http.StatusBadRequest, ""},
+		{"blank prefix", "?prefix=%20%20", http.StatusBadRequest, ""},
+		{"limit zero", "?prefix=god&limit=0", http.StatusBadRequest, ""},
+		{"limit too big", "?prefix=god&limit=26", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(t, app, "GET", "/movies/suggest"+tt.query, "")
+			expectStatus(t, w, tt.status)
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			// no match is an empty list, not null
+			var suggestions []repository.Suggestion
+			decode(t, w, &suggestions)
+			if suggestions == nil {
+				t.Fatal("suggestions = null")
+			}
+
+			if tt.want == "" {
+				if len(suggestions) != 0 {
+					t.Errorf("suggestions = %+v, want none", suggestions)
+				}
+				return
+			}
+			if len(suggestions) == 0 || suggestions[0].Title != tt.want {
+				t.Errorf("suggestions = %+v, want %q first", suggestions, tt.want)
+			}
+		})
+	}
+}
+
+// a PATCH only changes what it sends
+func TestUpdateMoviePartial(t *testing.T) {
+	app := newTestApp(t)
+	auth := adminAuth(t, app)
+
+	w := request(t, app, "GET", "/movies/3", "")
+	expectStatus(t, w, http.StatusOK)
+
+	var before models.Movie
+	decode(t, w, &before)
+
+	w = request(t, app, "PATCH", "/admin/movies/3", `{"runtime":180}`, "Authorization", auth, "If-Match", w.Header().Get("ETag"))
+	expectStatus(t, w, http.StatusAccepted)
+
+	w = request(t, app, "GET", "/movies/3", "")
+	expectStatus(t, w, http.StatusOK)
+
+	var after models.Movie
+	decode(t, w, &after)
+
+	if after.RunTime != 180 {
+		t.Errorf("runtime = %d, want 180", after.RunTime)
+	}
+	if after.Title != before.Title || after.Image != before.Image || after.Description != before.Description ||
+		!after.ReleaseDate.Equal(before.ReleaseDate) || len(after.Genres) != len(before.Genres) {
+		t.Errorf("after the PATCH %+v, want %+v with another runtime", after, before)
+	}
+
+	// sending a field empty still clears it, and a title is still required
+	w = request(t, app, "PATCH", "/admin/movies/3", `{"title":""}`, "Authorization", auth, "If-Match", w.Header().Get("ETag"))
+	expectStatus(t, w, http.StatusBadRequest)
+}
+
+func TestTrash(t *testing.T) {
+	app := newTestApp(t)
+	auth := adminAuth(t, app)
+
+	// etag reads the ETag of a movie that isn't in the trash
+	etag := func(id string) string {
+		w := request(t, app, "GET", "/movies/"+id, "")
+		expectStatus(t, w, http.StatusOK)
+		return w.Header().Get("ETag")
+	}
+
+	// trashed lists the titles in the trash
+	trashed := func() []string {
+		w := request(t, app, "GET", "/admin/movies/trash", "", "Authorization", auth)
+		expectStatus(t, w, http.StatusOK)
+
+		var movies []models.Movie
+		decode(t, w, &movies)
+
+		var titles []string
+		for _, movie := range movies {
+			titles = append(titles, movie.Title)
+		}
+		return titles
+	}
+
+	w := request(t, app, "DELETE", "/admin/movies/1", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusPreconditionRequired)
+
+	w = request(t, app, "DELETE", "/admin/movies/1", "", "Authorization", auth, "If-Match", etag("1"))
+	expectStatus(t, w, http.StatusAccepted)
+
+	w = request(t, app, "GET", "/movies/1", "")
+	expectStatus(t, w, http.StatusNotFound)
+	if got := trashed(); len(got) != 1 || got[0] != "Highlander" {
+		t.Fatalf("trash = %v", got)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		status int
+	}{
+		{"restore", "POST", "/admin/movies/trash/1/restore", http.StatusAccepted},
+		{"restore again", "POST", "/admin/movies/trash/1/restore", http.StatusNotFound},
+		{"purge a movie that isn't in the trash", "DELETE", "/admin/movies/trash/1", http.StatusNotFound},
+		{"restore an unknown movie", "POST", "/admin/movies/trash/99/restore", http.StatusNotFound},
+		{"invalid id", "POST", "/admin/movies/trash/one/restore", http.StatusBadRequest},
+		{"no token", "DELETE", "/admin/movies/trash/1", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := []string{"Authorization", auth}
+			if tt.status == http.StatusUnauthorized {
+				header = nil
+			}
+
+			w := request(t, app, tt.method, tt.target, "", header...)
+			expectStatus(t, w, tt.status)
+		})
+	}
+
+	// restored, the movie is back as it was
+	w = request(t, app, "GET", "/movies/1", "")
+	expectStatus(t, w, http.StatusOK)
+	if got := trashed(); len(got) != 0 {
+		t.Errorf("trash = %v after the restore", got)
+	}
+
+	history, err := app.DB.MovieRevisions(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the latest first
+	if len(history) != 2 || history[0].Action != "restore" || history[1].Action != "delete" {
+		t.Errorf("revisions = %+v, want a delete and a restore", history)
+	}
+
+	w = request(t, app, "DELETE", "/admin/movies/1", "", "Authorization", auth, "If-Match", etag("1"))
+	expectStatus(t, w, http.StatusAccepted)
+
+	w = request(t, app, "DELETE", "/admin/movies/trash/1", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusAccepted)
+
+	// purged, it is gone for good
+	w = request(t, app, "POST", "/admin/movies/trash/1/restore", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusNotFound)
+	w = request(t, app, "DELETE", "/admin/movies/trash/1", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusNotFound)
+	if got := trashed(); len(got) != 0 {
+		t.Errorf("trash = %v after the purge", got)
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"backend/internal/blobstore"
+	"backend/internal/poster"
+	"backend/internal/repository"
+	"backend/internal/revisions"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxPosterSize is the largest poster that can be uploaded
+const maxPosterSize = 10 << 20
+
+// blobDeleteTimeout is how long deleting a blob that isn't needed after all may take
+const blobDeleteTimeout = time.Second * 10
+
+// imagesPath is where ServeImage serves the blobs from, and so how a movie's image
+// starts when it was uploaded rather than taken from TMDB
+const imagesPath = "/images/"
+
+// uploadedImage is the answer of UploadMovieImage
+type uploadedImage struct {
+	Image  string `json:"image"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// UploadMovieImage sets the poster of a movie from the "image" field of a multipart
+// form, e.g. "POST /admin/movies/1/image". The file must be a JPEG, PNG or WebP image; it
+// is stored without its metadata and the movie's image becomes the path it is served
+// from. If-Match is checked when it is sent.
+func (app *application) UploadMovieImage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid movie id"))
+		return
+	}
+
+	var version time.Time
+	if r.Header.Get("If-Match") != "" {
+		version, err = ifMatchVersion(r)
+		if err != nil {
+			app.errorJSON(w, err, preconditionStatus(err))
+			return
+		}
+	}
+
+	// there's no point in storing a poster for a movie we don't have
+	_, err = app.DB.OneMovie(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("movie not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	// the multipart framing and the other fields get a megabyte on top of the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxPosterSize+1<<20)
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			app.errorJSON(w, fmt.Errorf("the image may be up to %d MB", maxPosterSize>>20), http.StatusRequestEntityTooLarge)
+			return
+		}
+		app.errorJSON(w, errors.New(`send the image as the "image" field of a multipart/form-data body`))
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxPosterSize+1))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if len(data) > maxPosterSize {
+		app.errorJSON(w, fmt.Errorf("the image may be up to %d MB", maxPosterSize>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	img, err := poster.Clean(data)
+	if err != nil {
+		if errors.Is(err, poster.ErrFormat) {
+			app.errorJSON(w, err, http.StatusUnsupportedMediaType)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	// the key changes with the content, which lets ServeImage have the file cached for good
+	sum := sha256.Sum256(img.Data)
+	key := fmt.Sprintf("posters/%d-%x%s", id, sum[:8], img.Ext)
+
+	// the same poster uploaded again has the same key, its blob is in use and must stay
+	existed := false
+	if blob, err := app.Blobs.Get(r.Context(), key); err == nil {
+		blob.Close()
+		existed = true
+	}
+
+	err = app.Blobs.Put(r.Context(), key, bytes.NewReader(img.Data))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the previous poster is kept, the revisions of the movie still point to it
+	updatedAt := time.Now().UTC()
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		movie, err := repo.OneMovie(r.Context(), id)
+		if err != nil {
+			return err
+		}
+
+		movie.Image = imagesPath + key
+		movie.UpdatedAt = updatedAt
+
+		err = repo.UpdateMovie(r.Context(), *movie, version)
+		if err != nil {
+			return err
+		}
+
+		_, err = revisions.Record(r.Context(), repo, id, userID(r), revisions.ActionUpdate)
+		return err
+	})
+	if err != nil {
+		// no movie points to the new blob
+		if !existed {
+			app.deleteBlob(key)
+		}
+
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("movie not found"), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, repository.ErrVersionMismatch) {
+			app.errorJSON(w, err, http.StatusPreconditionFailed)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "image uploaded",
+		Data:    uploadedImage{Image: imagesPath + key, Width: img.Width, Height: img.Height},
+	}
+
+	headers := http.Header{}
+	headers.Set("ETag", movieETag(updatedAt))
+
+	app.writeJSON(w, http.StatusAccepted, resp, headers)
+}
+
+// deleteBlob deletes the blob stored under key, even when the request that stored it was
+// canceled. Failures can only be logged, the blob is left behind.
+func (app *application) deleteBlob(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), blobDeleteTimeout)
+	defer cancel()
+
+	err := app.Blobs.Delete(ctx, key)
+	if err != nil {
+		log.Printf("deleting blob %s: %v", key, err)
+	}
+}
+
+// ServeImage serves a stored image, e.g. "/images/posters/1-5f2c0e9a11b3d4e7.jpg", or a
+// resized copy of it when the query asks for one, e.g. "?w=300&h=450&fit=cover". A key
+// is never reused for another content, so the images can be cached for good.
+func (app *application) ServeImage(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "*")
+
+	if blobstore.CheckKey(key) != nil {
+		app.errorJSON(w, errors.New("image not found"), http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	if q.Has("w") || q.Has("h") || q.Has("fit") || q.Has("format") {
+		app.serveVariant(w, r, key)
+		return
+	}
+
+	blob, err := app.Blobs.Get(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, blobstore.ErrNotFound) {
+			app.errorJSON(w, errors.New("image not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+
+	writeBlob(w, r, blob)
+}
+
+// writeBlob sends a blob as it is
+func writeBlob(w http.ResponseWriter, r *http.Request, blob *blobstore.Blob) {
+	w.Header().Set("Content-Type", blob.ContentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	// a store that can seek gets ranges and If-Modified-Since handled too
+	if body, ok := blob.Body.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", blob.ModTime, body)
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatInt(blob.Size, 10))
+	_, _ = io.Copy(w, blob.Body)
+}
+
+// readVariant reads the variant asked for in the query. Only the sizes of -image-sizes
+// can be asked for, any size would let a client have the server make as many images as
+// it likes, each of them kept in the cache.
+func (app *application) readVariant(r *http.Request) (poster.Variant, error) {
+	q := r.URL.Query()
+
+	v := poster.Variant{Fit: poster.FitCover, Format: poster.FormatJPEG}
+	if fit := q.Get("fit"); fit != "" {
+		v.Fit = fit
+	}
+	if format := q.Get("format"); format != "" {
+		v.Format = format
+	}
+
+	var sizes []string
+	for _, size := range app.ImageSizes {
+		sizes = append(sizes, size.String())
+	}
+	errSize := fmt.Errorf("w and h must be one of the sizes %s", strings.Join(sizes, ", "))
+
+	var err1, err2 error
+	v.Width, err1 = strconv.Atoi(q.Get("w"))
+	v.Height, err2 = strconv.Atoi(q.Get("h"))
+	if err1 != nil || err2 != nil {
+		return v, errSize
+	}
+
+	allowed := false
+	for _, size := range app.ImageSizes {
+		if size == v.Size {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return v, errSize
+	}
+
+	return v, v.Check()
+}
+
+// serveVariant sends a resized copy of a stored image. Copies are made the first time
+// they are asked for and kept in the image cache, under a hash of the key and the
+// parameters.
+func (app *application) serveVariant(w http.ResponseWriter, r *http.Request, key string) {
+	v, err := app.readVariant(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	cacheKey := v.Key(key)
+
+	if app.serveCached(w, r, cacheKey) {
+		return
+	}
+
+	// decoding and resizing a poster takes a lot of memory and time, so only a few are
+	// made at once and the other requests wait for their turn
+	select {
+	case app.resizeSlots <- struct{}{}:
+		defer func() { <-app.resizeSlots }()
+	case <-r.Context().Done():
+		return
+	}
+
+	// the requests for the same copy all wait for a slot, the first one makes it and
+	// the others find it in the cache
+	if app.serveCached(w, r, cacheKey) {
+		return
+	}
+
+	source, err := app.Blobs.Get(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, blobstore.ErrNotFound) {
+			app.errorJSON(w, errors.New("image not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	data, err := io.ReadAll(source.Body)
+	source.Close()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	img, err := poster.Decode(data)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	out, err := poster.Render(img, v)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the copy is still sent when it can't be cached, it will be made again next time
+	err = app.ImageCache.Put(r.Context(), cacheKey, bytes.NewReader(out))
+	if err != nil {
+		log.Println("caching image variant:", err)
+	}
+
+	w.Header().Set("Content-Type", v.ContentType())
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", time.Now(), bytes.NewReader(out))
+}
+
+// serveCached sends the copy kept in the image cache under cacheKey. It tells whether
+// the request has been answered, false means the copy still has to be made.
+func (app *application) serveCached(w http.ResponseWriter, r *http.Request, cacheKey string) bool {
+	blob, err := app.ImageCache.Get(r.Context(), cacheKey)
+	if err != nil {
+		if errors.Is(err, blobstore.ErrNotFound) {
+			return false
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return true
+	}
+	defer blob.Close()
+
+	writeBlob(w, r, blob)
+	return true
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"backend/internal/blobstore"
+	"backend/internal/poster"
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// posterKey is where storePoster puts its poster
+const posterKey = "posters/1-5f2c0e9a11b3d4e7.png"
+
+// storePoster puts a small PNG in the blobs of app, as an upload would
+func storePoster(t *testing.T, app *application) {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 40, 60))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 60; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 6), G: uint8(y * 4), B: 128, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Blobs.Put(context.Background(), posterKey, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// multipartImage is a multipart/form-data body with data as the file of the field, and
+// its content type
+func multipartImage(t *testing.T, field string, data []byte) (string, string) {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	part, err := mw.CreateFormFile(field, "poster.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = part.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return body.String(), mw.FormDataContentType()
+}
+
+// pngPoster is a small PNG poster
+func pngPoster(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 20, 30)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestUploadMovieImage(t *testing.T) {
+	app := newTestApp(t)
+	auth := adminAuth(t, app)
+
+	w := request(t, app, "GET", "/movies/1", "")
+	expectStatus(t, w, http.StatusOK)
+	etag := w.Header().Get("ETag")
+
+	body, contentType := multipartImage(t, "image", pngPoster(t))
+
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		header []string
+		status int
+	}{
+		{"no token", "/admin/movies/1/image", body, []string{"Content-Type", contentType}, http.StatusUnauthorized},
+		{"unknown movie", "/admin/movies/42/image", body, []string{"Authorization", auth, "Content-Type", contentType}, http.StatusNotFound},
+		{"stale version", "/admin/movies/1/image", body, []string{"Authorization", auth, "Content-Type", contentType, "If-Match", `"1"`}, http.StatusPreconditionFailed},
+		{"not multipart", "/admin/movies/1/image", string(pngPoster(t)), []string{"Authorization", auth, "Content-Type", "image/png"}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(t, app, "POST", tt.target, tt.body, tt.header...)
+			expectStatus(t, w, tt.status)
+		})
+	}
+
+	w = request(t, app, "POST", "/admin/movies/1/image", body, "Authorization", auth, "Content-Type", contentType, "If-Match", etag)
+	expectStatus(t, w, http.StatusAccepted)
+
+	var resp struct {
+		Data uploadedImage `json:"data"`
+	}
+	decode(t, w, &resp)
+
+	if !strings.HasPrefix(resp.Data.Image, imagesPath+"posters/1-") || resp.Data.Width != 20 || resp.Data.Height != 30 {
+		t.Fatalf("uploaded image = %+v", resp.Data)
+	}
+	if w.Header().Get("ETag") == "" || w.Header().Get("ETag") == etag {
+		t.Errorf("ETag = %q after the upload", w.Header().Get("ETag"))
+	}
+
+	w = request(t, app, "GET", "/movies/1", "")
+	expectStatus(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), resp.Data.Image) {
+		t.Errorf("the movie doesn't point to the upload: %s", w.Body.String())
+	}
+
+	w = request(t, app, "GET", resp.Data.Image, "")
+	expectStatus(t, w, http.StatusOK)
+	if w.Header().Get("Content-Type") != "image/png" || w.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("served with %v", w.Header())
+	}
+
+	// the same poster again gets the same key
+	w = request(t, app, "POST", "/admin/movies/1/image", body, "Authorization", auth, "Content-Type", contentType)
+	expectStatus(t, w, http.StatusAccepted)
+
+	var again struct {
+		Data uploadedImage `json:"data"`
+	}
+	decode(t, w, &again)
+	if again.Data.Image != resp.Data.Image {
+		t.Errorf("the same poster went to %s, then %s", resp.Data.Image, again.Data.Image)
+	}
+}
+
+func TestUploadMovieImageRejects(t *testing.T) {
+	app := newTestApp(t)
+	auth := adminAuth(t, app)
+
+	tests := []struct {
+		name   string
+		field  string
+		data   []byte
+		status int
+	}{
+		{"another field", "poster", pngPoster(t), http.StatusBadRequest},
+		{"not an image", "image", []byte("<svg xmlns=\"http://www.w3.org/2000/svg\"/>"), http.StatusUnsupportedMediaType},
+		{"gif", "image", []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00"), http.StatusUnsupportedMediaType},
+		{"too large", "image", append(pngPoster(t), make([]byte, maxPosterSize)...), http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, contentType := multipartImage(t, tt.field, tt.data)
+
+			w := request(t, app, "POST", "/admin/movies/1/image", body, "Authorization", auth, "Content-Type", contentType)
+			expectStatus(t, w, tt.status)
+		})
+	}
+
+	w := request(t, app, "GET", "/movies/1", "")
+	expectStatus(t, w, http.StatusOK)
+	if strings.Contains(w.Body.String(), imagesPath) {
+		t.Errorf("the movie points to a rejected upload: %s", w.Body.String())
+	}
+}
+
+// countingStore counts the blobs asked for and put in a store
+type countingStore struct {
+	blobstore.BlobStore
+	mu   sync.Mutex
+	gets int
+	puts int
+}
+
+func (s *countingStore) Get(ctx context.Context, key string) (*blobstore.Blob, error) {
+	s.mu.Lock()
+	s.gets++
+	s.mu.Unlock()
+	return s.BlobStore.Get(ctx, key)
+}
+
+func (s *countingStore) Put(ctx context.Context, key string, r io.Reader) error {
+	s.mu.Lock()
+	s.puts++
+	s.mu.Unlock()
+	return s.BlobStore.Put(ctx, key, r)
+}
+
+func TestServeImageVariant(t *testing.T) {
+	app := newTestApp(t)
+	storePoster(t, app)
+
+	tests := []struct {
+		name        string
+		query       string
+		status      int
+		contentType string
+	}{
+		{"original", "", http.StatusOK, "image/png"},
+		{"a size", "?w=185&h=278", http.StatusOK, "image/jpeg"},
+		{"webp", "?w=92&h=138&format=webp", http.StatusOK, "image/webp"},
+		{"contain", "?w=300&h=450&fit=contain", http.StatusOK, "image/jpeg"},
+		{"a size not in the list", "?w=186&h=278", http.StatusBadRequest, ""},
+		{"a huge size", "?w=10000&h=15000", http.StatusBadRequest, ""},
+		{"only a width", "?w=185", http.StatusBadRequest, ""},
+		{"unknown fit", "?w=185&h=278&fit=stretch", http.StatusBadRequest, ""},
+		{"unknown format", "?w=185&h=278&format=png", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(t, app, "GET", imagesPath+posterKey+tt.query, "")
+			expectStatus(t, w, tt.status)
+
+			if tt.contentType != "" && w.Header().Get("Content-Type") != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", w.Header().Get("Content-Type"), tt.contentType)
+			}
+		})
+	}
+
+	w := request(t, app, "GET", imagesPath+"posters/2-5f2c0e9a11b3d4e7.png?w=185&h=278", "")
+	expectStatus(t, w, http.StatusNotFound)
+
+	w = request(t, app, "GET", imagesPath+"posters/../secret.png", "")
+	expectStatus(t, w, http.StatusNotFound)
+}
+
+func TestImageSizesFlag(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+		ok    bool
+	}{
+		{"300x450", "300x450", true},
+		{"92x138, 300x450,", "92x138,300x450", true},
+		{"2000x2000", "2000x2000", true},
+		{"3000x3000", "", false},
+		{"300", "", false},
+		{"300x450,big", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			var sizes []poster.Size
+			err := imageSizes{&sizes}.Set(tt.value)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Set(%q) = %v", tt.value, err)
+			}
+			if got := (imageSizes{&sizes}).String(); tt.ok && got != tt.want {
+				t.Errorf("sizes = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// only the sizes of the flag can be asked for
+	app := newTestApp(t)
+	storePoster(t, app)
+	app.ImageSizes = []poster.Size{{Width: 50, Height: 75}}
+
+	w := request(t, app, "GET", imagesPath+posterKey+"?w=50&h=75", "")
+	expectStatus(t, w, http.StatusOK)
+
+	w = request(t, app, "GET", imagesPath+posterKey+"?w=300&h=450", "")
+	expectStatus(t, w, http.StatusBadRequest)
+}
+
+func TestServeImageVariantOnce(t *testing.T) {
+	app := newTestApp(t)
+	storePoster(t, app)
+
+	cache := &countingStore{BlobStore: app.ImageCache}
+	app.ImageCache = cache
+
+	// the single resize slot of the test app is taken until every request has missed the
+	// cache and waits for it, then only the first one makes the copy
+	app.resizeSlots <- struct{}{}
+
+	const requests = 8
+
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := request(t, app, "GET", imagesPath+posterKey+"?w=300&h=450", "")
+			if w.Code != http.StatusOK {
+				t.Errorf("status %d: %s", w.Code, w.Body.String())
+			}
+		}()
+	}
+
+	for deadline := time.Now().Add(time.Second * 5); ; time.Sleep(time.Millisecond) {
+		cache.mu.Lock()
+		gets := cache.gets
+		cache.mu.Unlock()
+
+		if gets >= requests {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests looked in the cache, want %d", gets, requests)
+		}
+	}
+	<-app.resizeSlots
+
+	wg.Wait()
+
+	if cache.puts != 1 {
+		t.Errorf("the copy was made %d times, want once", cache.puts)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"backend/internal/importer"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const importUsage = "usage: import [-dry-run] [-format csv|ndjson|json] FILE (- reads stdin)"
+
+// maxImportSize is the largest file ImportMovies accepts, a few thousand movies
+const maxImportSize = 10 << 20
+
+// importMovies runs the "import" command, e.g. "api -dsn=... import -dry-run movies.csv"
+func (app *application) importMovies(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only report what would be imported")
+	format := fs.String("format", "", "format of the file, by default guessed from its extension")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return errors.New(importUsage)
+	}
+
+	if fs.NArg() != 1 {
+		return errors.New(importUsage)
+	}
+	name := fs.Arg(0)
+
+	if *format == "" {
+		*format = importer.FormatFromName(name)
+		if *format == "" {
+			return fmt.Errorf("can't tell the format of %s, use -format", name)
+		}
+	}
+
+	var in io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	closeRepo, err := app.openRepo()
+	if err != nil {
+		return err
+	}
+	defer closeRepo()
+
+	report, err := importer.Import(context.Background(), app.DB, in, importer.Options{Format: *format, DryRun: *dryRun})
+	if err != nil {
+		return err
+	}
+
+	return printImportReport(report)
+}
+
+func printImportReport(report *importer.Report) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ROW\tACTION\tID\tTITLE\tERRORS")
+
+	for _, row := range report.Rows {
+		id := ""
+		if row.ID > 0 {
+			id = strconv.Itoa(row.ID)
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", row.Row, row.Action, id, row.Title, strings.Join(row.Errors, "; "))
+	}
+
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	summary := fmt.Sprintf("%d created, %d updated, %d rejected", report.Created, report.Updated, report.Rejected)
+	if report.DryRun {
+		summary += " (dry run, nothing was saved)"
+	}
+	fmt.Println(summary)
+
+	return nil
+}
+
+// ImportMovies loads a CSV, NDJSON or JSON file of movies sent as the request body, e.g.
+// "POST /admin/movies/import?format=csv&dry_run=true". The format can also be given with
+// the Content-Type header. The answer lists what happened to every row.
+func (app *application) ImportMovies(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = importFormat(r.Header.Get("Content-Type"))
+	}
+	if format == "" {
+		app.errorJSON(w, errors.New("format must be csv, ndjson or json"))
+		return
+	}
+
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(value)
+		if err != nil {
+			app.errorJSON(w, errors.New("dry_run must be true or false"))
+			return
+		}
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	report, err := importer.Import(r.Context(), app.DB, body, importer.Options{Format: format, DryRun: dryRun, UserID: userID(r)})
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "movies imported",
+		Data:    report,
+	}
+	if dryRun {
+		resp.Message = "nothing was saved, this is what the import would do"
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// importFormat maps the Content-Type of an upload to an import format
+func importFormat(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+
+	switch mediaType {
+	case "text/csv":
+		return importer.FormatCSV
+	case "application/x-ndjson", "application/jsonl":
+		return importer.FormatNDJSON
+	case "application/json":
+		return importer.FormatJSON
+	}
+	return ""
+}
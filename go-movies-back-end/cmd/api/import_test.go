@@ -0,0 +1,65 @@
+package main
+
+import (
+	"backend/internal/importer"
+	"backend/internal/repository"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestImportMovies(t *testing.T) {
+	app := newTestApp(t)
+	auth := adminAuth(t, app)
+
+	file := "title,release_date,runtime,mpaa_rating,genres\n" +
+		"Alien,1979-05-25,117,R,Horror|Sci-Fi\n" +
+		"Aliens,1986-07-18,long,R,\n"
+
+	var resp struct {
+		Message string          `json:"message"`
+		Data    importer.Report `json:"data"`
+	}
+
+	w := request(t, app, "POST", "/admin/movies/import?dry_run=true", file, "Authorization", auth, "Content-Type", "text/csv")
+	expectStatus(t, w, http.StatusOK)
+	decode(t, w, &resp)
+
+	if resp.Data.Created != 1 || resp.Data.Rejected != 1 || len(resp.Data.Rows) != 2 {
+		t.Fatalf("report = %+v", resp.Data)
+	}
+	if resp.Data.Rows[1].Row != 3 || len(resp.Data.Rows[1].Errors) == 0 {
+		t.Errorf("row 3 = %+v, want an error", resp.Data.Rows[1])
+	}
+
+	if !resp.Data.DryRun || resp.Data.Rows[0].ID != 0 {
+		t.Errorf("report = %+v, want a dry run", resp.Data)
+	}
+
+	w = request(t, app, "GET", "/movies", "")
+	expectStatus(t, w, http.StatusOK)
+
+	var page repository.MoviePage
+	decode(t, w, &page)
+	if len(page.Movies) != 3 {
+		t.Errorf("%d movies after the dry run, want 3", len(page.Movies))
+	}
+
+	// the format can be a parameter too
+	w = request(t, app, "POST", "/admin/movies/import?format=csv", file, "Authorization", auth)
+	expectStatus(t, w, http.StatusOK)
+	decode(t, w, &resp)
+
+	if resp.Data.Created != 1 || resp.Data.Rows[0].ID == 0 {
+		t.Fatalf("report = %+v", resp.Data)
+	}
+
+	w = request(t, app, "GET", "/movies/"+strconv.Itoa(resp.Data.Rows[0].ID), "")
+	expectStatus(t, w, http.StatusOK)
+
+	w = request(t, app, "POST", "/admin/movies/import", file, "Authorization", auth, "Content-Type", "text/plain")
+	expectStatus(t, w, http.StatusBadRequest)
+
+	w = request(t, app, "POST", "/admin/movies/import?format=csv&dry_run=maybe", file, "Authorization", auth)
+	expectStatus(t, w, http.StatusBadRequest)
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"backend/internal/blobstore"
+	"backend/internal/importer"
+	"backend/internal/mailer"
+	"backend/internal/metadata"
+	"backend/internal/poster"
 	"backend/internal/repository"
-	"backend/internal/repository/dbrepo"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"runtime"
 	"time"
 )
 
@@ -14,6 +19,7 @@ const port = 8080
 
 type application struct {
 	DSN    string // DSN = Data Source Name
+	Repo   string // which DatabaseRepo to use: "sql" (picked from the DSN) or "memory"
 	Domain string
 	// DB     *sql.DB
 	DB           repository.DatabaseRepo
@@ -22,6 +28,33 @@ type application struct {
 	JWTIssuer    string
 	JWTAudience  string
 	CookieDomain string
+	DBTimeouts   repository.Timeouts
+	TxOptions    repository.TxOptions
+	CacheMaxAge  time.Duration // how long caches may keep public reads without asking again
+	BlobDir      string        // where the local BlobStore keeps uploaded images
+	Blobs        blobstore.BlobStore
+	ImageSizes   []poster.Size // the only sizes poster variants are made in
+	CacheDir     string        // where the local BlobStore keeps the variants of the posters
+	ImageCache   blobstore.BlobStore
+	TMDBURL      string
+	TMDBToken    string
+	Metadata     metadata.MetadataProvider // nil when no movie database is configured
+	FrontendURL  string                    // where the links in mails point to
+	ResetExpiry  time.Duration             // how long a password reset link works
+	VerifyExpiry time.Duration             // how long an email verification link works
+	MailFrom     string
+	SMTPAddr     string // mails go into OutboxDir when empty
+	SMTPUsername string
+	SMTPPassword string
+	OutboxDir    string
+	Mailer       mailer.Mailer
+
+	// resizeSlots limits how many variants are made at the same time, each of them takes
+	// a whole decoded poster in memory
+	resizeSlots chan struct{}
+
+	// resendLimiter counts the verification mails users ask for
+	resendLimiter *rateLimiter
 }
 
 func main() {
@@ -29,24 +62,88 @@ func main() {
 	var app application
 
 	// read from command line (flags)
-	flag.StringVar(&app.DSN, "dsn", "host=localhost port=5432 user=postgres password=postgres dbname=movies sslmode=disable timezone=UTC connect_timeout=5", "Postgres connection string")
+	flag.StringVar(&app.DSN, "dsn", "host=localhost port=5432 user=postgres password=postgres dbname=movies sslmode=disable timezone=UTC connect_timeout=5", "Postgres connection string, or sqlite://file.db for SQLite")
+	flag.StringVar(&app.Repo, "repo", "sql", "repository to use: sql for the database of -dsn, or memory to run without a database")
 	flag.StringVar(&app.JWTSecret, "jwt-seret", "verysecret", "signing secret")
 	flag.StringVar(&app.JWTIssuer, "jwt-issuer", "example.com", "signing issuer")
 	flag.StringVar(&app.JWTAudience, "jwt-audience", "example.com", "signing audience")
 	flag.StringVar(&app.CookieDomain, "cookie-domain", "localhost", "cookie domain")
 	flag.StringVar(&app.Domain, "domain", "example.com", "domain")
+	flag.DurationVar(&app.DBTimeouts.Default, "db-timeout", repository.DefaultTimeout, "how long a database query may take")
+	flag.Var(operationTimeouts{&app.DBTimeouts}, "db-timeouts", "deadlines of single operations, e.g. SearchMovies=5s,AllGenres=500ms")
+	flag.Var(isolationLevel{&app.TxOptions.Isolation}, "tx-isolation", "isolation level of transactions: default, read-committed, repeatable-read or serializable")
+	flag.IntVar(&app.TxOptions.Retries, "tx-retries", repository.DefaultTxRetries, "how many times a transaction is retried after a serialization failure, -1 to never retry")
+	flag.DurationVar(&app.CacheMaxAge, "cache-max-age", 0, "how long caches may keep the catalogue before revalidating it, 0 to always revalidate")
+	flag.StringVar(&app.BlobDir, "blob-dir", "blobs", "directory uploaded images are kept in")
+	flag.StringVar(&app.CacheDir, "image-cache-dir", "image-cache", "directory resized posters are cached in")
+	app.ImageSizes = defaultImageSizes
+	flag.Var(imageSizes{&app.ImageSizes}, "image-sizes", "sizes posters can be resized to, e.g. 92x138,300x450")
+	flag.StringVar(&app.TMDBURL, "tmdb-url", metadata.DefaultTMDBURL, "API of the movie database movies are enriched from")
+	flag.StringVar(&app.TMDBToken, "tmdb-token", "", "read access token of the movie database API")
+	flag.StringVar(&app.FrontendURL, "frontend-url", "http://localhost:3000", "address of the front end, for the links in mails")
+	flag.DurationVar(&app.ResetExpiry, "password-reset-expiry", time.Hour, "how long a password reset link works")
+	flag.DurationVar(&app.VerifyExpiry, "email-verification-expiry", time.Hour*48, "how long an email verification link works")
+	flag.StringVar(&app.MailFrom, "mail-from", "Go Movies <no-reply@example.com>", "sender of the mails")
+	flag.StringVar(&app.SMTPAddr, "smtp-addr", "", "host:port of the SMTP server mails are sent through, empty to save them into -mail-outbox")
+	flag.StringVar(&app.SMTPUsername, "smtp-username", "", "SMTP user name")
+	flag.StringVar(&app.SMTPPassword, "smtp-password", "", "SMTP password")
+	flag.StringVar(&app.OutboxDir, "mail-outbox", "outbox", "directory mails are saved in when there is no SMTP server")
 	flag.Parse()
 
-	// connect to the database
-	conn, err := app.connectToDB()
+	// commands manage the database instead of starting the server, e.g. "migrate up" or
+	// "import movies.csv"
+	if flag.NArg() > 0 {
+		var err error
+
+		switch flag.Arg(0) {
+		case "migrate":
+			err = app.migrate(flag.Args()[1:])
+		case "import":
+			err = app.importMovies(flag.Args()[1:])
+		default:
+			log.Fatalf("unknown command %q", flag.Arg(0))
+		}
+
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	closeRepo, err := app.openRepo()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closeRepo()
+
+	app.Blobs, err = blobstore.NewLocal(app.BlobDir)
 	if err != nil {
 		log.Fatal(err)
 	}
-	// app.DB = conn
-	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
-	// defer app.DB.Close()
-	// defer conn.Close()
-	defer app.DB.Connection().Close()
+
+	app.ImageCache, err = blobstore.NewLocal(app.CacheDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.resizeSlots = make(chan struct{}, runtime.NumCPU())
+
+	// the real database wants a token, a fake or a proxy given with -tmdb-url may not
+	if app.TMDBToken != "" || app.TMDBURL != metadata.DefaultTMDBURL {
+		app.Metadata = metadata.NewTMDB(app.TMDBURL, app.TMDBToken, importer.MPAARatings)
+	}
+
+	if app.SMTPAddr != "" {
+		app.Mailer = mailer.NewSMTP(app.SMTPAddr, app.SMTPUsername, app.SMTPPassword, app.MailFrom)
+	} else {
+		app.Mailer, err = mailer.NewOutbox(app.OutboxDir, app.MailFrom)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	app.resendLimiter = newRateLimiter(verifyResendLimit, verifyResendWindow)
+
+	go app.purgeRefreshTokens(time.Hour)
 
 	app.auth = Auth{
 		Issuer:        app.JWTIssuer,
@@ -54,6 +151,7 @@ func main() {
 		Secret:        app.JWTSecret,
 		TokenExpiry:   time.Minute * 15,
 		RefreshExpiry: time.Hour * 24,
+		VerifyExpiry:  app.VerifyExpiry,
 		CookiePath:    "/", // "/" means root level of our app which means cookie is good for anywhere in our app.
 		CookieName:    "__Host-refresh_token",
 		CookieDomain:  app.CookieDomain,
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"backend/internal/blobstore"
+	"backend/internal/mailer"
+	"backend/internal/repository/memrepo"
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestApp returns the application the way main sets it up, on a memory repository with
+// the default fixtures. Mails go to an outbox, images to directories, in t.TempDir().
+func newTestApp(t *testing.T) *application {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	app := &application{
+		JWTSecret:    "verysecret",
+		FrontendURL:  "http://localhost:3000",
+		ResetExpiry:  time.Hour,
+		VerifyExpiry: time.Hour * 48,
+		OutboxDir:    filepath.Join(dir, "outbox"),
+		ImageSizes:   defaultImageSizes,
+	}
+
+	repo := memrepo.New()
+	err := repo.Seed(memrepo.DefaultFixtures())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.DB = repo
+
+	app.Blobs, err = blobstore.NewLocal(filepath.Join(dir, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.ImageCache, err = blobstore.NewLocal(filepath.Join(dir, "image-cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.resizeSlots = make(chan struct{}, 1)
+
+	app.Mailer, err = mailer.NewOutbox(app.OutboxDir, "Go Movies <no-reply@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.resendLimiter = newRateLimiter(verifyResendLimit, verifyResendWindow)
+
+	app.auth = Auth{
+		Issuer:        "example.com",
+		Audience:      "example.com",
+		Secret:        app.JWTSecret,
+		TokenExpiry:   time.Minute * 15,
+		RefreshExpiry: time.Hour * 24,
+		VerifyExpiry:  app.VerifyExpiry,
+		CookiePath:    "/",
+		CookieName:    "__Host-refresh_token",
+		CookieDomain:  "localhost",
+	}
+
+	return app
+}
+
+// request sends a request through the routes of app. header holds pairs of a name and a
+// value.
+func request(t *testing.T, app *application, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Add(header[i], header[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	app.routes().ServeHTTP(w, r)
+
+	return w
+}
+
+// adminAuth is the Authorization header of the admin of the fixtures. The token is made
+// directly, logging in would hash the password with the cost of the fixtures.
+func adminAuth(t *testing.T, app *application) string {
+	t.Helper()
+
+	tokens, err := app.auth.GenerateTokenPair(&jwtUser{ID: 1, FirstName: "Admin", LastName: "User"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return "Bearer " + tokens.Token
+}
+
+// decode reads the JSON body of a response into v
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+
+	err := json.Unmarshal(w.Body.Bytes(), v)
+	if err != nil {
+		t.Fatalf("decoding %q: %v", w.Body.String(), err)
+	}
+}
+
+// expectStatus fails the test when the response doesn't have the status want
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, want int) {
+	t.Helper()
+
+	if w.Code != want {
+		t.Fatalf("status %d, want %d: %s", w.Code, want, w.Body.String())
+	}
+}
@@ -1,6 +1,20 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// contextKey is the type of the values this package puts in a request context
+type contextKey string
+
+const (
+	claimsKey contextKey = "claims" // the claims of the token that authRequired accepted
+	userKey   contextKey = "user"   // the *models.User of those claims
+)
 
 func (app *application) enableCORS(h http.Handler) http.Handler {
 	// Here, we are simply just modifying the request as it comes in.
@@ -15,9 +29,11 @@ func (app *application) enableCORS(h http.Handler) http.Handler {
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
 			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, X-CSRF-Token, Authorization")
+			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, X-CSRF-Token, Authorization, If-Match")
 			return
 		} else {
+			// the edit form needs to read the ETag of the movie it sends back with If-Match
+			w.Header().Set("Access-Control-Expose-Headers", "ETag")
 			h.ServeHTTP(w, r)
 		}
 	})
@@ -27,13 +43,51 @@ func (app *application) authRequired(next http.Handler) http.Handler {
 	// Since we need to access both the responsewriter and request so we
 	// would do the same things that we diid in enableCORS() function/method
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// we don't care about the token and claims that's why we are throwing them out and only storing the error
-		_, _, err := app.auth.GetTokenFromHeaderAndVerify(w, r)
+		// we don't care about the token itself, the claims are kept so that handlers can
+		// tell who is making the request
+		_, claims, err := app.auth.GetTokenFromHeaderAndVerify(w, r)
 		if err != nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		// the subject of our tokens is the user id, see GenerateTokenPair
+		id, err := strconv.Atoi(claims.Subject)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// a token outlives a password reset until it expires, unless the user is checked
+		user, err := app.DB.GetUserByID(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		if tokenRevoked(user, claims) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), claimsKey, claims)
+		ctx = context.WithValue(ctx, userKey, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// userID returns the id of the user whose token authRequired accepted, zero on routes
+// that don't require one
+func userID(r *http.Request) int {
+	claims, ok := r.Context().Value(claimsKey).(*Claims)
+	if !ok {
+		return 0
+	}
+
+	// the subject of our tokens is the user id, see GenerateTokenPair
+	id, _ := strconv.Atoi(claims.Subject)
+	return id
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"backend/internal/migrations"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+const migrateUsage = "usage: migrate up|down|status|to N"
+
+// newMigrator returns the migrator for the database the DSN points at
+func (app *application) newMigrator(conn *sql.DB) (*migrations.Migrator, error) {
+	dialect := migrations.Postgres
+	if driver, _ := driverFor(app.DSN); driver == "sqlite" {
+		dialect = migrations.SQLite
+	}
+
+	return migrations.New(conn, dialect)
+}
+
+// migrate runs the "migrate" command, e.g. "api -dsn=... migrate up"
+func (app *application) migrate(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	conn, err := app.connectToDB()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	m, err := app.newMigrator(conn)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		err = m.Up()
+	case args[0] == "down" && len(args) == 1:
+		err = m.Down()
+	case args[0] == "to" && len(args) == 2:
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		err = m.To(version)
+	case args[0] == "status" && len(args) == 1:
+		// status only prints, it is handled below
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return printMigrationStatus(m)
+}
+
+func printMigrationStatus(m *migrations.Migrator) error {
+	statuses, err := m.Status()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+
+	for _, s := range statuses {
+		applied := "pending"
+		if s.Applied {
+			applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+
+	return w.Flush()
+}
+
+// checkSchema refuses to go on when the database is behind the code, serving requests
+// against missing tables or columns would only fail in confusing ways.
+func (app *application) checkSchema(conn *sql.DB) error {
+	m, err := app.newMigrator(conn)
+	if err != nil {
+		return err
+	}
+
+	current, err := m.Current()
+	if err != nil {
+		return err
+	}
+
+	if current < m.Latest() {
+		return fmt.Errorf("database schema is at version %d but this build needs version %d, run \"migrate up\" first", current, m.Latest())
+	}
+
+	return nil
+}
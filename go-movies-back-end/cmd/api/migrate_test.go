@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckSchema(t *testing.T) {
+	app := &application{DSN: "sqlite://" + filepath.Join(t.TempDir(), "movies.db")}
+
+	conn, err := app.connectToDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	m, err := app.newMigrator(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		version int
+		ok      bool
+	}{
+		{"never migrated", 0, false},
+		{"behind", m.Latest() - 1, false},
+		{"up to date", m.Latest(), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.version > 0 {
+				err := m.To(tt.version)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := app.checkSchema(conn)
+			if (err == nil) != tt.ok {
+				t.Fatalf("checkSchema() = %v, want ok %v", err, tt.ok)
+			}
+			if err != nil && !strings.Contains(err.Error(), "migrate up") {
+				t.Errorf("error %q doesn't say how to fix it", err)
+			}
+		})
+	}
+
+	// the check only reads, it doesn't start the migrations of an empty database
+	other := &application{DSN: "sqlite://" + filepath.Join(t.TempDir(), "empty.db")}
+	empty, err := other.connectToDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer empty.Close()
+
+	if other.checkSchema(empty) == nil {
+		t.Fatal("no error for an empty database")
+	}
+
+	var tables int
+	err = empty.QueryRow(`select count(*) from sqlite_master where type = 'table'`).Scan(&tables)
+	if err != nil || tables != 0 {
+		t.Errorf("%d tables after the check, %v", tables, err)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"backend/internal/mailer"
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// mailTimeout is how long sending a mail may take, it happens after the response is sent
+const mailTimeout = time.Second * 30
+
+// forgotPassword mails a link to reset the password to the user with the email given. The
+// answer is the same whether there is such a user or not, so that it can't be used to find
+// out who has an account.
+func (app *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	email, err := normalizeEmail(requestPayload.Email)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// the user is looked up after answering, with the rest: the time the answer takes
+	// doesn't tell whether there is one
+	go app.sendPasswordReset(email)
+
+	app.writeJSON(w, http.StatusAccepted, JSONResponse{
+		Error:   false,
+		Message: "if the email belongs to an account, a link to reset its password is on its way",
+	})
+}
+
+// sendPasswordReset mails a link to reset the password to the user with email, if there is
+// one. It runs in the background of forgotPassword, failures can only be logged.
+func (app *application) sendPasswordReset(email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+	defer cancel()
+
+	user, err := app.DB.GetUserByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("looking up %s for a password reset: %v", email, err)
+		}
+		return
+	}
+
+	token, tokenHash, err := newResetToken()
+	if err != nil {
+		log.Printf("password reset of user %d: %v", user.ID, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	_, err = app.DB.InsertPasswordReset(ctx, models.PasswordReset{
+		UserID:    user.ID,
+		TokenHash: tokenHash,
+		ExpiresAt: now.Add(app.ResetExpiry),
+		CreatedAt: now,
+	})
+	if err != nil {
+		log.Printf("password reset of user %d: %v", user.ID, err)
+		return
+	}
+
+	link := app.FrontendURL + "/reset-password?" + url.Values{"token": {token}}.Encode()
+
+	app.sendMail(&mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"Someone, hopefully you, asked to reset the password of your account. To choose a new\n"+
+			"password, open this link within %s:\n\n%s\n\n"+
+			"The link works once. If you didn't ask for it, there is nothing to do, your password\n"+
+			"stays the same.\n",
+			user.FirstName, app.ResetExpiry, link),
+	})
+}
+
+// resetPassword sets a new password with a token mailed by forgotPassword. The token can be
+// used once, and every refresh token of the user stops working: whoever knew the old
+// password is logged out.
+func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if requestPayload.Token == "" {
+		app.errorJSON(w, errors.New("token is required"))
+		return
+	}
+
+	// the policy is checked again with the email of the user below, this spares hashing
+	// passwords that can't be right anyway
+	err = checkPassword(requestPayload.Password, "")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(requestPayload.Password), passwordCost)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256([]byte(requestPayload.Token))
+	tokenHash := hex.EncodeToString(sum[:])
+
+	// a password the policy refuses rolls the transaction back, the token can then be used
+	// again with a better one
+	var policyErr error
+	now := time.Now().UTC()
+
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		reset, err := repo.UsePasswordReset(r.Context(), tokenHash, now)
+		if err != nil {
+			return err
+		}
+
+		user, err := repo.GetUserByID(r.Context(), reset.UserID)
+		if err != nil {
+			return err
+		}
+
+		policyErr = checkPassword(requestPayload.Password, user.Email)
+		if policyErr != nil {
+			return policyErr
+		}
+
+		err = repo.UpdateUserPassword(r.Context(), user.ID, string(hash), now)
+		if err != nil {
+			return err
+		}
+
+		return repo.RevokeUserTokens(r.Context(), user.ID, now)
+	})
+	if err != nil {
+		if policyErr != nil {
+			app.errorJSON(w, policyErr)
+			return
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("the reset link is invalid, has expired or was already used"))
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
+
+	app.writeJSON(w, http.StatusAccepted, JSONResponse{
+		Error:   false,
+		Message: "password changed, log in with the new password",
+	})
+}
+
+// newResetToken returns a random token for the mail and the hash of it for the database
+func newResetToken() (token, tokenHash string, err error) {
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(b)
+	sum := sha256.Sum256([]byte(token))
+
+	return token, hex.EncodeToString(sum[:]), nil
+}
+
+// sendMail sends msg in the background of a request, failures can only be logged
+func (app *application) sendMail(msg *mailer.Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+	defer cancel()
+
+	err := app.Mailer.Send(ctx, msg)
+	if err != nil {
+		log.Printf("sending %q to %s: %v", msg.Subject, msg.To, err)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// outbox waits for n mails in the outbox of app, they are sent in the background, and
+// returns them
+func outbox(t *testing.T, app *application, n int) []*mail.Message {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		files, err := filepath.Glob(filepath.Join(app.OutboxDir, "*.eml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(files) >= n {
+			var msgs []*mail.Message
+			for _, file := range files {
+				data, err := os.ReadFile(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				msg, err := mail.ReadMessage(bytes.NewReader(data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				msgs = append(msgs, msg)
+			}
+			return msgs
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("%d mails in the outbox, want %d", len(files), n)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+// recipient is the address a mail is to
+func recipient(t *testing.T, msg *mail.Message) string {
+	t.Helper()
+
+	to, err := mail.ParseAddress(msg.Header.Get("To"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return to.Address
+}
+
+// linkToken is the token of the link to page, e.g. "/reset-password", in a mail
+func linkToken(t *testing.T, msg *mail.Message, page string) string {
+	t.Helper()
+
+	body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range strings.Split(string(body), "\n") {
+		if i := strings.Index(line, page+"?"); i >= 0 {
+			q, err := url.ParseQuery(strings.TrimSpace(line[i+len(page)+1:]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return q.Get("token")
+		}
+	}
+
+	t.Fatalf("no link to %s in %q", page, body)
+	return ""
+}
+
+func TestPasswordReset(t *testing.T) {
+	app := newTestApp(t)
+
+	// logged in before the reset
+	tokens := login(t, app, "")
+
+	w := request(t, app, "POST", "/password/forgot", `{"email":" Admin@Example.com "}`)
+	expectStatus(t, w, http.StatusAccepted)
+
+	msg := outbox(t, app, 1)[0]
+	if to := recipient(t, msg); to != "admin@example.com" {
+		t.Errorf("mail to %q", to)
+	}
+	token := linkToken(t, msg, "/reset-password")
+
+	w = request(t, app, "POST", "/password/reset", `{"token":"`+token+`","password":"short"}`)
+	expectStatus(t, w, http.StatusBadRequest)
+
+	w = request(t, app, "POST", "/password/reset", `{"token":"`+token+`","password":"correct horse battery"}`)
+	expectStatus(t, w, http.StatusAccepted)
+
+	// the link works once
+	w = request(t, app, "POST", "/password/reset", `{"token":"`+token+`","password":"another horse battery"}`)
+	expectStatus(t, w, http.StatusBadRequest)
+	if !strings.Contains(w.Body.String(), "already used") {
+		t.Errorf("reusing the link: %s", w.Body.String())
+	}
+
+	// whoever knew the old password is logged out
+	w = request(t, app, "GET", "/refresh", "", "Cookie", refreshCookie(app, tokens.RefreshToken))
+	expectStatus(t, w, http.StatusUnauthorized)
+
+	w = request(t, app, "POST", "/authenticate", `{"email":"admin@example.com","password":"secret"}`)
+	expectStatus(t, w, http.StatusBadRequest)
+
+	w = request(t, app, "POST", "/authenticate", `{"email":"admin@example.com","password":"correct horse battery"}`)
+	expectStatus(t, w, http.StatusAccepted)
+}
+
+func TestPasswordResetExpired(t *testing.T) {
+	app := newTestApp(t)
+	app.ResetExpiry = -time.Minute
+
+	w := request(t, app, "POST", "/password/forgot", `{"email":"admin@example.com"}`)
+	expectStatus(t, w, http.StatusAccepted)
+
+	token := linkToken(t, outbox(t, app, 1)[0], "/reset-password")
+
+	w = request(t, app, "POST", "/password/reset", `{"token":"`+token+`","password":"correct horse battery"}`)
+	expectStatus(t, w, http.StatusBadRequest)
+
+	w = request(t, app, "POST", "/password/reset", `{"token":"nonsense","password":"correct horse battery"}`)
+	expectStatus(t, w, http.StatusBadRequest)
+}
+
+func TestForgotPasswordUnknownEmail(t *testing.T) {
+	app := newTestApp(t)
+
+	// the answer is the same as for a user
+	w := request(t, app, "POST", "/password/forgot", `{"email":"nobody@example.com"}`)
+	expectStatus(t, w, http.StatusAccepted)
+
+	w = request(t, app, "POST", "/password/forgot", `{"email":"admin@example.com"}`)
+	expectStatus(t, w, http.StatusAccepted)
+
+	// the mail to the admin is sent, give the other one the time to show up if it would
+	outbox(t, app, 1)
+	time.Sleep(time.Millisecond * 100)
+
+	msgs := outbox(t, app, 1)
+	if len(msgs) != 1 || recipient(t, msgs[0]) != "admin@example.com" {
+		t.Errorf("%d mails, want one to the admin", len(msgs))
+	}
+
+	w = request(t, app, "POST", "/password/forgot", `{"email":"nobody"}`)
+	expectStatus(t, w, http.StatusBadRequest)
+}
+
+// the access tokens of before a reset stop working too, not only the refresh tokens
+func TestResetRevokesAccessTokens(t *testing.T) {
+	app := newTestApp(t)
+	auth := adminAuth(t, app)
+
+	w := request(t, app, "GET", "/admin/movies", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusOK)
+
+	// the reset is a minute after the token was issued
+	err := app.DB.RevokeUserTokens(context.Background(), 1, time.Now().UTC().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w = request(t, app, "GET", "/admin/movies", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusUnauthorized)
+}
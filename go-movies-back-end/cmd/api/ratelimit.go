@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter allows every key, e.g. a user id, a number of events in a sliding window.
+// It lives in memory, each instance of the API counts on its own.
+type rateLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	events    map[string][]time.Time // key -> times of its events in the window, oldest first
+	lastSweep time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		window: window,
+		events: make(map[string][]time.Time),
+	}
+}
+
+// allow records an event for key and returns zero, or returns how long to wait before the
+// key gets under its limit again. Refused events aren't recorded.
+func (l *rateLimiter) allow(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// keys that are left alone would stay forever, forget them once in a window
+	if now.Sub(l.lastSweep) > l.window {
+		for k, events := range l.events {
+			if len(l.recent(events, now)) == 0 {
+				delete(l.events, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	events := l.recent(l.events[key], now)
+	if len(events) >= l.limit {
+		return events[0].Add(l.window).Sub(now)
+	}
+
+	l.events[key] = append(events, now)
+	return 0
+}
+
+// recent drops the events that are out of the window
+func (l *rateLimiter) recent(events []time.Time, now time.Time) []time.Time {
+	for len(events) > 0 && now.Sub(events[0]) >= l.window {
+		events = events[1:]
+	}
+	return events
+}
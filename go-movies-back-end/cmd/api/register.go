@@ -0,0 +1,180 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordCost is the bcrypt cost of the passwords of new users: slow enough for guessing,
+// fast enough for logging in. PasswordMatches reads the cost from the hash, so it can be
+// raised without touching existing users.
+const passwordCost = 12
+
+const (
+	minPasswordLength = 10
+	maxPasswordBytes  = 72 // bcrypt ignores anything longer
+	maxNameLength     = 255
+)
+
+// commonPasswords are long enough for the policy and still the first ones to be guessed
+var commonPasswords = map[string]bool{
+	"1234567890":   true,
+	"0123456789":   true,
+	"12345678910":  true,
+	"password123":  true,
+	"password1234": true,
+	"passw0rd123":  true,
+	"qwertyuiop":   true,
+	"1q2w3e4r5t":   true,
+	"iloveyou123":  true,
+	"letmein1234":  true,
+}
+
+// register creates a user from an email, a name and a password, mails them the link that
+// verifies the email, and logs them in like authenticate does. The user isn't an admin.
+func (app *application) register(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email     string `json:"email"`
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Password  string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	email, err := normalizeEmail(requestPayload.Email)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	firstName := strings.TrimSpace(requestPayload.FirstName)
+	lastName := strings.TrimSpace(requestPayload.LastName)
+	if firstName == "" || lastName == "" {
+		app.errorJSON(w, errors.New("first name and last name are required"))
+		return
+	}
+	if utf8.RuneCountInString(firstName) > maxNameLength || utf8.RuneCountInString(lastName) > maxNameLength {
+		app.errorJSON(w, fmt.Errorf("names can be at most %d characters long", maxNameLength))
+		return
+	}
+
+	err = checkPassword(requestPayload.Password, email)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(requestPayload.Password), passwordCost)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now().UTC()
+	user := models.User{
+		Email:     email,
+		FirstName: firstName,
+		LastName:  lastName,
+		Password:  string(hash),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	user.ID, err = app.DB.CreateUser(r.Context(), user)
+	if err != nil {
+		if errors.Is(err, repository.ErrDuplicateEmail) {
+			app.errorJSON(w, err, http.StatusConflict)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the account works without it, only the admin routes wait for the email to be
+	// verified. The user can ask for another mail.
+	err = app.sendVerificationMail(&user)
+	if err != nil {
+		log.Printf("verification mail of user %d: %v", user.ID, err)
+	}
+
+	tokens, err := app.issueTokens(r.Context(), app.DB, &user, "")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	http.SetCookie(w, app.auth.GetRefreshCookie(tokens.RefreshToken))
+
+	app.writeJSON(w, http.StatusCreated, tokens)
+}
+
+// normalizeEmail checks that email is a bare address, "jane@example.com" and not
+// "Jane <jane@example.com>", and returns it in lower case
+func normalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return "", errors.New("email is required")
+	}
+
+	invalid := fmt.Errorf("%q is not a valid email address", email)
+
+	if len(email) > maxNameLength {
+		return "", invalid
+	}
+
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		return "", invalid
+	}
+
+	// a domain without a dot is only ever a local one
+	at := strings.LastIndexByte(email, '@')
+	if !strings.Contains(email[at+1:], ".") {
+		return "", invalid
+	}
+
+	return strings.ToLower(email), nil
+}
+
+// checkPassword is the password policy: long enough, short enough for bcrypt, and not one
+// of the first passwords anyone would try for that email
+func checkPassword(password, email string) error {
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return fmt.Errorf("the password must be at least %d characters long", minPasswordLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("the password can be at most %d bytes long", maxPasswordBytes)
+	}
+
+	lower := strings.ToLower(password)
+
+	if commonPasswords[lower] {
+		return errors.New("the password is too common")
+	}
+
+	if strings.Count(lower, lower[:1]) == len(lower) {
+		return errors.New("the password can't be one character repeated")
+	}
+
+	// the email is empty when it isn't known yet
+	if at := strings.LastIndexByte(email, '@'); at >= 3 && strings.Contains(lower, email[:at]) {
+		return errors.New("the password can't contain the email")
+	}
+
+	return nil
+}
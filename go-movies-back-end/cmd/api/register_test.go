@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestRegisterRejects(t *testing.T) {
+	app := newTestApp(t)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"no email", `{"first_name":"Jane","last_name":"Doe","password":"correct horse battery"}`, http.StatusBadRequest},
+		{"display name", `{"email":"Jane <jane@example.com>","first_name":"Jane","last_name":"Doe","password":"correct horse battery"}`, http.StatusBadRequest},
+		{"local domain", `{"email":"jane@localhost","first_name":"Jane","last_name":"Doe","password":"correct horse battery"}`, http.StatusBadRequest},
+		{"no name", `{"email":"jane@example.com","first_name":" ","last_name":"Doe","password":"correct horse battery"}`, http.StatusBadRequest},
+		{"short password", `{"email":"jane@example.com","first_name":"Jane","last_name":"Doe","password":"secret"}`, http.StatusBadRequest},
+		{"common password", `{"email":"jane@example.com","first_name":"Jane","last_name":"Doe","password":"letmein1234"}`, http.StatusBadRequest},
+		{"password with the email", `{"email":"jane@example.com","first_name":"Jane","last_name":"Doe","password":"jane is the best"}`, http.StatusBadRequest},
+		{"unknown field", `{"email":"jane@example.com","first_name":"Jane","last_name":"Doe","password":"correct horse battery","is_admin":true}`, http.StatusBadRequest},
+		// the fixtures have admin@example.com
+		{"taken email", `{"email":"Admin@Example.com","first_name":"Jane","last_name":"Doe","password":"correct horse battery"}`, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(t, app, "POST", "/register", tt.body)
+			expectStatus(t, w, tt.status)
+		})
+	}
+}
+
+func TestRegisterIsNotAdmin(t *testing.T) {
+	app := newTestApp(t)
+
+	w := request(t, app, "POST", "/register", `{"email":"Jane@Example.com","first_name":"Jane","last_name":"Doe","password":"correct horse battery"}`)
+	expectStatus(t, w, http.StatusCreated)
+
+	var tokens TokenPairs
+	decode(t, w, &tokens)
+	if tokens.Token == "" || len(w.Result().Cookies()) == 0 {
+		t.Fatalf("registering didn't log in: %s", w.Body.String())
+	}
+
+	auth := "Bearer " + tokens.Token
+
+	user, err := app.DB.GetUserByEmail(context.Background(), "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.IsAdmin || user.EmailVerified {
+		t.Fatalf("registered user = %+v", user)
+	}
+
+	// a verified email isn't enough either
+	token := linkToken(t, outbox(t, app, 1)[0], "/verify-email")
+	w = request(t, app, "POST", "/email/verify", `{"token":"`+token+`"}`)
+	expectStatus(t, w, http.StatusAccepted)
+
+	w = request(t, app, "GET", "/admin/movies", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusForbidden)
+
+	w = request(t, app, "GET", "/admin/movies", "", "Authorization", adminAuth(t, app))
+	expectStatus(t, w, http.StatusOK)
+
+	// the email is kept in lower case, logging in with another case works
+	w = request(t, app, "POST", "/authenticate", `{"email":"JANE@example.com","password":"correct horse battery"}`)
+	expectStatus(t, w, http.StatusAccepted)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/revisions"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// MovieRevisions lists the revisions of a movie, the latest first
+func (app *application) MovieRevisions(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid movie id"))
+		return
+	}
+
+	history, err := app.DB.MovieRevisions(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, history)
+}
+
+// MovieRevision shows one revision, e.g. "/admin/movies/1/revisions/3"
+func (app *application) MovieRevision(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid movie id"))
+		return
+	}
+
+	revision, err := strconv.Atoi(chi.URLParam(r, "revision"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid revision"))
+		return
+	}
+
+	rev, err := app.DB.MovieRevision(r.Context(), id, revision)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("revision not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, rev)
+}
+
+// revisionDiff is the answer of DiffMovieRevisions
+type revisionDiff struct {
+	From    int                     `json:"from"`
+	To      int                     `json:"to"`
+	Changes []revisions.FieldChange `json:"changes"`
+}
+
+// DiffMovieRevisions compares two revisions field by field, e.g.
+// "/admin/movies/1/revisions/diff?from=2&to=5". To defaults to the latest revision and
+// from to the one before to; from=0 compares with a movie that doesn't exist yet.
+func (app *application) DiffMovieRevisions(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid movie id"))
+		return
+	}
+
+	history, err := app.DB.MovieRevisions(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if len(history) == 0 {
+		app.errorJSON(w, errors.New("the movie has no revisions"), http.StatusNotFound)
+		return
+	}
+
+	diff := revisionDiff{To: history[0].Revision}
+
+	if value := r.URL.Query().Get("to"); value != "" {
+		diff.To, err = strconv.Atoi(value)
+		if err != nil {
+			app.errorJSON(w, errors.New("to must be a revision number"))
+			return
+		}
+	}
+
+	diff.From = diff.To - 1
+	if value := r.URL.Query().Get("from"); value != "" {
+		diff.From, err = strconv.Atoi(value)
+		if err != nil {
+			app.errorJSON(w, errors.New("from must be a revision number"))
+			return
+		}
+	}
+
+	// history is sorted latest first and numbered from 1 without gaps
+	snapshot := func(revision int) (*models.Movie, error) {
+		if revision == 0 {
+			return nil, nil
+		}
+		if revision < 0 || revision > len(history) {
+			return nil, fmt.Errorf("revision %d not found", revision)
+		}
+		return &history[len(history)-revision].Snapshot, nil
+	}
+
+	from, err := snapshot(diff.From)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusNotFound)
+		return
+	}
+
+	to, err := snapshot(diff.To)
+	if err != nil || to == nil {
+		app.errorJSON(w, fmt.Errorf("revision %d not found", diff.To), http.StatusNotFound)
+		return
+	}
+
+	diff.Changes = revisions.Diff(from, to)
+
+	_ = app.writeJSON(w, http.StatusOK, diff)
+}
+
+// RollbackMovie puts a movie back the way it was at an earlier revision, e.g.
+// "POST /admin/movies/1/revisions/3/rollback". The rollback is a new revision itself, so
+// it can be undone the same way.
+func (app *application) RollbackMovie(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid movie id"))
+		return
+	}
+
+	revision, err := strconv.Atoi(chi.URLParam(r, "revision"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid revision"))
+		return
+	}
+
+	// a rollback overwrites the movie like an update does, over the version the client saw
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		app.errorJSON(w, err, preconditionStatus(err))
+		return
+	}
+
+	var rev *models.MovieRevision
+
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		rev, err = revisions.Rollback(r.Context(), repo, id, revision, userID(r), version)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("revision not found, or the movie is in the trash"), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, repository.ErrVersionMismatch) {
+			app.errorJSON(w, err, http.StatusPreconditionFailed)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: fmt.Sprintf("movie rolled back to revision %d", revision),
+		Data:    rev,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
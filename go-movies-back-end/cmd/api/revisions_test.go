@@ -0,0 +1,106 @@
+package main
+
+import (
+	"backend/internal/revisions"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestDiffMovieRevisions(t *testing.T) {
+	app := newTestApp(t)
+	auth := adminAuth(t, app)
+
+	w := request(t, app, "GET", "/admin/movies/1/revisions/diff", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusNotFound)
+
+	// two edits make the revisions 1 and 2
+	for _, runtime := range []int{120, 125} {
+		w = request(t, app, "GET", "/movies/1", "")
+		expectStatus(t, w, http.StatusOK)
+
+		body := fmt.Sprintf(`{"title":"Highlander","release_date":"1986-03-07T00:00:00Z","runtime":%d,"mpaa_rating":"R"}`, runtime)
+		w = request(t, app, "PATCH", "/admin/movies/1", body, "Authorization", auth, "If-Match", w.Header().Get("ETag"))
+		expectStatus(t, w, http.StatusAccepted)
+	}
+
+	var diff revisionDiff
+
+	w = request(t, app, "GET", "/admin/movies/1/revisions/diff", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusOK)
+	decode(t, w, &diff)
+
+	if diff.From != 1 || diff.To != 2 || len(diff.Changes) != 1 || !changed(diff.Changes[0], "runtime", 120, 125) {
+		t.Errorf("diff = %+v, want the runtime from 120 to 125", diff)
+	}
+
+	// from a movie that didn't exist, every field is a change
+	w = request(t, app, "GET", "/admin/movies/1/revisions/diff?from=0&to=1", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusOK)
+	decode(t, w, &diff)
+
+	if len(diff.Changes) < 4 {
+		t.Errorf("diff from nothing = %+v", diff)
+	}
+
+	for _, query := range []string{"?to=3", "?from=-1", "?from=1&to=0"} {
+		w = request(t, app, "GET", "/admin/movies/1/revisions/diff"+query, "", "Authorization", auth)
+		expectStatus(t, w, http.StatusNotFound)
+	}
+
+	w = request(t, app, "GET", "/admin/movies/1/revisions/diff?to=last", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusBadRequest)
+}
+
+func TestRollbackMovie(t *testing.T) {
+	app := newTestApp(t)
+	auth := adminAuth(t, app)
+
+	w := request(t, app, "GET", "/movies/1", "")
+	expectStatus(t, w, http.StatusOK)
+	first := w.Header().Get("ETag")
+
+	// two edits make the revisions 1 and 2
+	etag := first
+	for _, runtime := range []int{120, 125} {
+		w = request(t, app, "PATCH", "/admin/movies/1", fmt.Sprintf(`{"runtime":%d}`, runtime), "Authorization", auth, "If-Match", etag)
+		expectStatus(t, w, http.StatusAccepted)
+		etag = w.Header().Get("ETag")
+	}
+
+	target := "/admin/movies/1/revisions/1/rollback"
+
+	w = request(t, app, "POST", target, "", "Authorization", auth)
+	expectStatus(t, w, http.StatusPreconditionRequired)
+
+	// someone else changed the movie since first
+	w = request(t, app, "POST", target, "", "Authorization", auth, "If-Match", first)
+	expectStatus(t, w, http.StatusPreconditionFailed)
+
+	w = request(t, app, "POST", target, "", "Authorization", auth, "If-Match", etag)
+	expectStatus(t, w, http.StatusAccepted)
+
+	// the rollback is a change of its own
+	w = request(t, app, "POST", target, "", "Authorization", auth, "If-Match", etag)
+	expectStatus(t, w, http.StatusPreconditionFailed)
+
+	w = request(t, app, "GET", "/movies/1", "")
+	expectStatus(t, w, http.StatusOK)
+
+	var movie struct {
+		RunTime int `json:"runtime"`
+	}
+	decode(t, w, &movie)
+	if movie.RunTime != 120 {
+		t.Errorf("runtime = %d after the rollback, want 120", movie.RunTime)
+	}
+
+	w = request(t, app, "POST", "/admin/movies/1/revisions/9/rollback", "", "Authorization", auth, "If-Match", w.Header().Get("ETag"))
+	expectStatus(t, w, http.StatusNotFound)
+}
+
+// changed tells whether change is of field, from one value to another. The values went
+// through JSON, numbers are float64.
+func changed(change revisions.FieldChange, field string, from, to float64) bool {
+	return change.Field == field && change.From == from && change.To == to
+}
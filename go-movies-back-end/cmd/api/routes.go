@@ -23,16 +23,51 @@ func (app *application) routes() http.Handler {
 
 	mux.Get("/", app.Home)
 
+	mux.Post("/register", app.register)
 	mux.Post("/authenticate", app.authenticate)
 	mux.Get("/refresh", app.refreshToken)
 	mux.Get("/logout", app.logout)
+	mux.Post("/password/forgot", app.forgotPassword)
+	mux.Post("/password/reset", app.resetPassword)
+	mux.Post("/email/verify", app.verifyEmail)
+	mux.With(app.authRequired).Post("/email/verify/resend", app.resendVerification)
 
 	mux.Get("/movies", app.AllMovies)
+	mux.Get("/movies/search", app.SearchMovies)
+	mux.Get("/movies/suggest", app.SuggestTitles)
+	mux.Get("/movies/{id}", app.GetMovie)
+	mux.Get("/movies/genres/{id}", app.AllMoviesByGenre)
+
+	mux.Get("/genres", app.AllGenres)
+
+	mux.Get("/images/*", app.ServeImage)
+
+	mux.Post("/graph", app.moviesGraphQL)
 
 	mux.Route("/admin", func(mux chi.Router) {
 		mux.Use(app.authRequired) // authRequired middleware only applies to the following routes in this block
+		mux.Use(app.verifiedRequired)
+		mux.Use(app.adminRequired)
+
+		mux.Get("/movies", app.MovieCatalog)  // actual route is "/admin/movies"
+		mux.Put("/movies/0", app.InsertMovie) // id 0 means "a movie that doesn't exist yet"
+		mux.Patch("/movies/{id}", app.UpdateMovie)
+		mux.Delete("/movies/{id}", app.DeleteMovie)
+		mux.Post("/movies/{id}/image", app.UploadMovieImage)
+		mux.Post("/movies/{id}/enrich", app.EnrichMovie)
+		mux.Post("/movies/import", app.ImportMovies)
+		mux.Get("/movies/export", app.ExportMovies)
+
+		// deleted movies stay in the trash until they are restored or purged
+		mux.Get("/movies/trash", app.TrashedMovies)
+		mux.Post("/movies/trash/{id}/restore", app.RestoreMovie)
+		mux.Delete("/movies/trash/{id}", app.PurgeMovie)
 
-		mux.Get("/movies", app.MovieCatalog) // actual route is "/admin/movies"
+		// every change to a movie is kept as a revision
+		mux.Get("/movies/{id}/revisions", app.MovieRevisions)
+		mux.Get("/movies/{id}/revisions/diff", app.DiffMovieRevisions)
+		mux.Get("/movies/{id}/revisions/{revision}", app.MovieRevision)
+		mux.Post("/movies/{id}/revisions/{revision}/rollback", app.RollbackMovie)
 	})
 
 	return mux
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// refreshReuseGrace is how long a refresh token still refreshes after it was used. Clients
+// refresh twice at once, e.g. React StrictMode runs effects twice or two tabs load together,
+// and that isn't a stolen token.
+const refreshReuseGrace = time.Second * 5
+
+// errRefreshReused tells that a refresh token was used again after refreshReuseGrace
+var errRefreshReused = errors.New("refresh token reused")
+
+// issueTokens generates a token pair for user and keeps its refresh token as the next one
+// of family, or of a new family when family is empty, through repo
+func (app *application) issueTokens(ctx context.Context, repo repository.DatabaseRepo, user *models.User, family string) (TokenPairs, error) {
+	tokens, err := app.auth.GenerateTokenPair(&jwtUser{
+		ID:        user.ID,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+	})
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	if family == "" {
+		family, err = randomID()
+		if err != nil {
+			return TokenPairs{}, err
+		}
+	}
+
+	err = repo.InsertRefreshToken(ctx, models.RefreshToken{
+		ID:        tokens.RefreshTokenID,
+		FamilyID:  family,
+		UserID:    user.ID,
+		ExpiresAt: tokens.RefreshExpires,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	return tokens, nil
+}
+
+// tokenRevoked tells whether the token of claims was issued before all the tokens of user
+// were revoked, e.g. by a password reset. iat counts whole seconds, a token of the second
+// of the revocation is still good: the user may log in again right after the reset.
+func tokenRevoked(user *models.User, claims *Claims) bool {
+	if user.TokensRevokedAt == nil {
+		return false
+	}
+	if claims.IssuedAt == nil {
+		return true
+	}
+	return claims.IssuedAt.Unix() < user.TokensRevokedAt.Unix()
+}
+
+// parseRefreshToken checks the signature and expiry of a refresh token and returns its
+// claims. Whether the server still accepts it is up to the stored token.
+func (app *application) parseRefreshToken(token string) (*Claims, error) {
+	claims := &Claims{}
+
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return []byte(app.JWTSecret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the tokens from before the server kept them have no id
+	if claims.ID == "" {
+		return nil, errors.New("refresh token without an id")
+	}
+
+	return claims, nil
+}
+
+// reusable tells whether token can refresh again at now: it was used by a refresh less than
+// refreshReuseGrace ago and it wasn't revoked since
+func reusable(token *models.RefreshToken, now time.Time) bool {
+	return token.RevokedAt == nil && token.UsedAt != nil && now.Sub(*token.UsedAt) <= refreshReuseGrace
+}
+
+// revokeReusedFamily revokes the family of a refresh token that was presented after it
+// had been exchanged already. One of the two holders of the token stole it and there is
+// no telling which, so both have to log in again.
+func (app *application) revokeReusedFamily(r *http.Request, token *models.RefreshToken) {
+	log.Printf("refresh token %s of user %d was used again, revoking its family %s", token.ID, token.UserID, token.FamilyID)
+
+	err := app.DB.RevokeRefreshFamily(r.Context(), token.FamilyID, time.Now().UTC())
+	if err != nil {
+		log.Printf("revoking refresh token family %s: %v", token.FamilyID, err)
+	}
+}
+
+// purgeRefreshTokens deletes the expired refresh tokens every interval, for as long as the
+// server runs
+func (app *application) purgeRefreshTokens(interval time.Duration) {
+	for range time.Tick(interval) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		purged, err := app.DB.PurgeRefreshTokens(ctx, time.Now().UTC())
+		cancel()
+
+		if err != nil {
+			log.Println("purging expired refresh tokens:", err)
+			continue
+		}
+		if purged > 0 {
+			log.Printf("purged %d expired refresh tokens", purged)
+		}
+	}
+}
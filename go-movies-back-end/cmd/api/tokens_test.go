@@ -0,0 +1,196 @@
+package main
+
+import (
+	"backend/internal/models"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// login gives the admin of the fixtures a token pair of family, as authenticate does for a
+// new family
+func login(t *testing.T, app *application, family string) TokenPairs {
+	t.Helper()
+
+	user, err := app.DB.GetUserByID(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := app.issueTokens(context.Background(), app.DB, user, family)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tokens
+}
+
+// refreshCookie is the Cookie header of a refresh token
+func refreshCookie(app *application, token string) string {
+	return (&http.Cookie{Name: app.auth.CookieName, Value: token}).String()
+}
+
+// refreshed returns the Cookie header of the refresh token a response set
+func refreshed(t *testing.T, app *application, w *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == app.auth.CookieName {
+			return refreshCookie(app, cookie.Value)
+		}
+	}
+
+	t.Fatal("no refresh token cookie")
+	return ""
+}
+
+func TestRefreshRotates(t *testing.T) {
+	app := newTestApp(t)
+
+	first := refreshCookie(app, login(t, app, "").RefreshToken)
+
+	w := request(t, app, "GET", "/refresh", "", "Cookie", first)
+	expectStatus(t, w, http.StatusOK)
+
+	var tokens TokenPairs
+	decode(t, w, &tokens)
+	if tokens.Token == "" {
+		t.Error("no access token")
+	}
+
+	second := refreshed(t, app, w)
+	if second == first {
+		t.Fatal("the refresh token didn't change")
+	}
+
+	w = request(t, app, "GET", "/refresh", "", "Cookie", second)
+	expectStatus(t, w, http.StatusOK)
+
+	w = request(t, app, "GET", "/refresh", "", "Cookie", refreshCookie(app, "nonsense"))
+	expectStatus(t, w, http.StatusUnauthorized)
+}
+
+// two refreshes at once, e.g. from two tabs, both get a token pair
+func TestRefreshReuseInGrace(t *testing.T) {
+	app := newTestApp(t)
+
+	cookie := refreshCookie(app, login(t, app, "").RefreshToken)
+
+	w := request(t, app, "GET", "/refresh", "", "Cookie", cookie)
+	expectStatus(t, w, http.StatusOK)
+	next := refreshed(t, app, w)
+
+	w = request(t, app, "GET", "/refresh", "", "Cookie", cookie)
+	expectStatus(t, w, http.StatusOK)
+
+	w = request(t, app, "GET", "/refresh", "", "Cookie", next)
+	expectStatus(t, w, http.StatusOK)
+}
+
+// a refresh token used again after the grace was copied: its whole family stops working
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	app := newTestApp(t)
+	ctx := context.Background()
+
+	// the first token was exchanged for the second a minute ago
+	first := login(t, app, "")
+	stored, err := app.DB.GetRefreshToken(ctx, first.RefreshTokenID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.DB.UseRefreshToken(ctx, stored.ID, time.Now().UTC().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := login(t, app, stored.FamilyID)
+
+	// another family of the same user, e.g. on another device
+	other := login(t, app, "")
+
+	w := request(t, app, "GET", "/refresh", "", "Cookie", refreshCookie(app, first.RefreshToken))
+	expectStatus(t, w, http.StatusUnauthorized)
+
+	w = request(t, app, "GET", "/refresh", "", "Cookie", refreshCookie(app, second.RefreshToken))
+	expectStatus(t, w, http.StatusUnauthorized)
+
+	w = request(t, app, "GET", "/refresh", "", "Cookie", refreshCookie(app, other.RefreshToken))
+	expectStatus(t, w, http.StatusOK)
+}
+
+func TestLogoutRevokesFamily(t *testing.T) {
+	app := newTestApp(t)
+
+	cookie := refreshCookie(app, login(t, app, "").RefreshToken)
+
+	w := request(t, app, "GET", "/refresh", "", "Cookie", cookie)
+	expectStatus(t, w, http.StatusOK)
+	next := refreshed(t, app, w)
+
+	w = request(t, app, "GET", "/logout", "", "Cookie", next)
+	expectStatus(t, w, http.StatusAccepted)
+
+	// the token in the grace doesn't come back either
+	w = request(t, app, "GET", "/refresh", "", "Cookie", cookie)
+	expectStatus(t, w, http.StatusUnauthorized)
+	w = request(t, app, "GET", "/refresh", "", "Cookie", next)
+	expectStatus(t, w, http.StatusUnauthorized)
+}
+
+func TestReusable(t *testing.T) {
+	now := time.Now().UTC()
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		name     string
+		token    models.RefreshToken
+		reusable bool
+	}{
+		{"unused", models.RefreshToken{}, false},
+		{"just used", models.RefreshToken{UsedAt: at(-time.Second)}, true},
+		{"used at the end of the grace", models.RefreshToken{UsedAt: at(-refreshReuseGrace)}, true},
+		{"used before", models.RefreshToken{UsedAt: at(-refreshReuseGrace - time.Second)}, false},
+		{"revoked", models.RefreshToken{UsedAt: at(-time.Second), RevokedAt: at(0)}, false},
+	}
+
+	for _, tt := range tests {
+		if got := reusable(&tt.token, now); got != tt.reusable {
+			t.Errorf("%s: reusable = %v, want %v", tt.name, got, tt.reusable)
+		}
+	}
+}
+
+func TestTokenRevoked(t *testing.T) {
+	reset := time.Date(2024, 5, 1, 12, 0, 0, 500_000_000, time.UTC)
+
+	tests := []struct {
+		name    string
+		revoked *time.Time
+		issued  *jwt.NumericDate
+		want    bool
+	}{
+		{"never revoked", nil, jwt.NewNumericDate(reset), false},
+		{"issued before", &reset, jwt.NewNumericDate(reset.Add(-time.Minute)), true},
+		{"issued the second before", &reset, jwt.NewNumericDate(reset.Add(-time.Second)), true},
+		{"issued the same second", &reset, jwt.NewNumericDate(reset), false},
+		{"issued after", &reset, jwt.NewNumericDate(reset.Add(time.Minute)), false},
+		{"no iat", &reset, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &models.User{TokensRevokedAt: tt.revoked}
+			claims := &Claims{RegisteredClaims: jwt.RegisteredClaims{IssuedAt: tt.issued}}
+
+			if got := tokenRevoked(user, claims); got != tt.want {
+				t.Errorf("tokenRevoked = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
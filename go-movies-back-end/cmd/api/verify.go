@@ -0,0 +1,166 @@
+package main
+
+import (
+	"backend/internal/mailer"
+	"backend/internal/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how many verification mails a user can ask for, on top of the one sent when they
+// registered
+const (
+	verifyResendLimit  = 3
+	verifyResendWindow = time.Hour
+)
+
+// sendVerificationMail mails user the link that verifies their email. The mail is sent
+// in the background, like the ones of forgotPassword.
+func (app *application) sendVerificationMail(user *models.User) error {
+	token, err := app.auth.GenerateVerificationToken(user.ID, user.Email)
+	if err != nil {
+		return err
+	}
+
+	link := app.FrontendURL + "/verify-email?" + url.Values{"token": {token}}.Encode()
+
+	go app.sendMail(&mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"Welcome to Go Movies! To confirm that this is your email address, open this link\n"+
+			"within %s:\n\n%s\n\n"+
+			"If you didn't make an account, someone typed your address by mistake and there is\n"+
+			"nothing to do.\n",
+			user.FirstName, app.auth.VerifyExpiry, link),
+	})
+
+	return nil
+}
+
+// verifyEmail confirms the email of a user with the token of the link mailed to them. It
+// doesn't need a login, the link may be opened on another device.
+func (app *application) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	invalid := errors.New("the verification link is invalid or has expired")
+
+	id, email, err := app.auth.ParseVerificationToken(requestPayload.Token)
+	if err != nil {
+		app.errorJSON(w, invalid)
+		return
+	}
+
+	user, err := app.DB.GetUserByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, invalid)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if !strings.EqualFold(user.Email, email) {
+		app.errorJSON(w, invalid)
+		return
+	}
+
+	// opening the link twice is fine
+	if !user.EmailVerified {
+		err = app.DB.VerifyUserEmail(r.Context(), user.ID, time.Now().UTC())
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	app.writeJSON(w, http.StatusAccepted, JSONResponse{
+		Error:   false,
+		Message: "email verified",
+	})
+}
+
+// resendVerification mails the verification link again to the user who is logged in. A
+// user gets verifyResendLimit of them per verifyResendWindow.
+func (app *application) resendVerification(w http.ResponseWriter, r *http.Request) {
+	user, err := app.DB.GetUserByID(r.Context(), userID(r))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if user.EmailVerified {
+		app.errorJSON(w, errors.New("the email is already verified"), http.StatusConflict)
+		return
+	}
+
+	wait := app.resendLimiter.allow(strconv.Itoa(user.ID), time.Now())
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		app.errorJSON(w, errors.New("too many verification mails, try again later"), http.StatusTooManyRequests)
+		return
+	}
+
+	err = app.sendVerificationMail(user)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusAccepted, JSONResponse{
+		Error:   false,
+		Message: "a new verification link is on its way to " + user.Email,
+	})
+}
+
+// verifiedRequired lets through the users whose email is verified. It goes after
+// authRequired, which loads the user.
+func (app *application) verifiedRequired(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value(userKey).(*models.User)
+		if !ok {
+			app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
+			return
+		}
+
+		if !user.EmailVerified {
+			app.errorJSON(w, errors.New("confirm your email address first, see the link mailed to you"), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminRequired lets through the admins. It goes after authRequired, which loads the user.
+func (app *application) adminRequired(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value(userKey).(*models.User)
+		if !ok || !user.IsAdmin {
+			app.errorJSON(w, errors.New("only admins can do this"), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// register signs Jane up and returns her Authorization header and the token of the
+// verification link mailed to her
+func register(t *testing.T, app *application) (string, string) {
+	t.Helper()
+
+	w := request(t, app, "POST", "/register", `{"email":"jane@example.com","first_name":"Jane","last_name":"Doe","password":"correct horse battery"}`)
+	expectStatus(t, w, http.StatusCreated)
+
+	var tokens TokenPairs
+	decode(t, w, &tokens)
+
+	msg := outbox(t, app, 1)[0]
+	if to := recipient(t, msg); to != "jane@example.com" {
+		t.Fatalf("mail to %q", to)
+	}
+
+	return "Bearer " + tokens.Token, linkToken(t, msg, "/verify-email")
+}
+
+func TestVerifyEmail(t *testing.T) {
+	app := newTestApp(t)
+	auth, token := register(t, app)
+
+	// an unverified user can't use the admin routes, whatever else they are
+	w := request(t, app, "GET", "/admin/movies", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusForbidden)
+
+	user, err := app.DB.GetUserByEmail(context.Background(), "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherEmail, err := app.auth.GenerateVerificationToken(user.ID, "john@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknownUser, err := app.auth.GenerateVerificationToken(42, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := app.auth
+	expired.VerifyExpiry = -time.Minute
+	expiredToken, err := expired.GenerateVerificationToken(user.ID, user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an access token is signed with another key
+	accessToken := auth[len("Bearer "):]
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"no token", `{}`, http.StatusBadRequest},
+		{"garbage", `{"token":"not.a.token"}`, http.StatusBadRequest},
+		{"access token", `{"token":"` + accessToken + `"}`, http.StatusBadRequest},
+		{"another email", `{"token":"` + otherEmail + `"}`, http.StatusBadRequest},
+		{"unknown user", `{"token":"` + unknownUser + `"}`, http.StatusBadRequest},
+		{"expired", `{"token":"` + expiredToken + `"}`, http.StatusBadRequest},
+		{"the mailed link", `{"token":"` + token + `"}`, http.StatusAccepted},
+		{"the link again", `{"token":"` + token + `"}`, http.StatusAccepted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(t, app, "POST", "/email/verify", tt.body)
+			expectStatus(t, w, tt.status)
+		})
+	}
+
+	user, err = app.DB.GetUserByEmail(context.Background(), "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.EmailVerified {
+		t.Error("the email isn't verified")
+	}
+
+	w = request(t, app, "POST", "/email/verify/resend", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusConflict)
+}
+
+func TestResendVerification(t *testing.T) {
+	app := newTestApp(t)
+	auth, _ := register(t, app)
+
+	w := request(t, app, "POST", "/email/verify/resend", "")
+	expectStatus(t, w, http.StatusUnauthorized)
+
+	for i := 0; i < verifyResendLimit; i++ {
+		w := request(t, app, "POST", "/email/verify/resend", "", "Authorization", auth)
+		expectStatus(t, w, http.StatusAccepted)
+	}
+
+	w = request(t, app, "POST", "/email/verify/resend", "", "Authorization", auth)
+	expectStatus(t, w, http.StatusTooManyRequests)
+
+	retry, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retry <= 0 || retry > int(verifyResendWindow.Seconds()) {
+		t.Errorf("Retry-After = %q", w.Header().Get("Retry-After"))
+	}
+
+	// the one of the registration and those that were allowed
+	msgs := outbox(t, app, 1+verifyResendLimit)
+	if len(msgs) != 1+verifyResendLimit {
+		t.Errorf("%d mails, want %d", len(msgs), 1+verifyResendLimit)
+	}
+
+	// every link works
+	for _, msg := range msgs {
+		w := request(t, app, "POST", "/email/verify", `{"token":"`+linkToken(t, msg, "/verify-email")+`"}`)
+		expectStatus(t, w, http.StatusAccepted)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, time.Hour)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		key  string
+		at   time.Duration // after start
+		wait time.Duration
+	}{
+		{"1", 0, 0},
+		{"1", time.Minute, 0},
+		{"1", time.Minute * 2, time.Minute * 58},
+		// a refused event isn't counted
+		{"1", time.Minute * 3, time.Minute * 57},
+		{"2", time.Minute * 3, 0},
+		// the first event is out of the window
+		{"1", time.Hour, 0},
+		{"1", time.Hour + time.Second, time.Minute - time.Second},
+	}
+
+	for _, tt := range tests {
+		wait := l.allow(tt.key, start.Add(tt.at))
+		if wait != tt.wait {
+			t.Errorf("allow(%s) after %v = %v, want %v", tt.key, tt.at, wait, tt.wait)
+		}
+	}
+
+	// keys left alone are forgotten
+	l.allow("3", start.Add(time.Hour*3))
+	if len(l.events) != 1 {
+		t.Errorf("%d keys kept, want 1", len(l.events))
+	}
+}
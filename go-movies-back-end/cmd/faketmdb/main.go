@@ -0,0 +1,23 @@
+// Command faketmdb runs a fake of the API of The Movie Database for local development,
+// e.g. "go run ./cmd/faketmdb -addr :8099" and then the API with
+// "-tmdb-url http://localhost:8099/3".
+package main
+
+import (
+	"backend/internal/metadata/faketmdb"
+	"flag"
+	"log"
+	"net/http"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8099", "address to listen on")
+	flag.Parse()
+
+	log.Println("fake movie database on", *addr)
+
+	err := http.ListenAndServe(*addr, faketmdb.Handler(faketmdb.DefaultMovies))
+	if err != nil {
+		log.Fatal(err)
+	}
+}
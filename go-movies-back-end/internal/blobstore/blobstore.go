@@ -0,0 +1,79 @@
+// Package blobstore keeps the files the API stores for movies, like uploaded posters.
+// Handlers only use the BlobStore interface, so the files can live on the local disk or,
+// later, in an object store.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned for a key that has no blob
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore keeps blobs under keys like "posters/1-5f2c0e9a.jpg": slash separated names
+// of lower case letters, digits, dots, dashes and underscores. The content type of a
+// blob is told by the extension of its key.
+type BlobStore interface {
+	// Put stores the blob read from r under key, replacing the one that was there
+	Put(ctx context.Context, key string, r io.Reader) error
+
+	// Get opens the blob stored under key. The caller must close it.
+	Get(ctx context.Context, key string) (*Blob, error)
+
+	// Delete removes the blob stored under key. Deleting a missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// Blob is an open blob. Body is also an io.Seeker when the store can seek, which lets
+// the files be served in ranges.
+type Blob struct {
+	Body        io.ReadCloser
+	ContentType string
+	Size        int64
+	ModTime     time.Time
+}
+
+// Close closes the body of the blob
+func (b *Blob) Close() error {
+	return b.Body.Close()
+}
+
+// CheckKey tells whether key can name a blob. Keys come from URLs, so a key that could
+// climb out of the store, like "../x", is refused.
+func CheckKey(key string) error {
+	if key == "" || len(key) > 255 || path.Clean(key) != key || strings.HasPrefix(key, "/") {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+
+	for _, part := range strings.Split(key, "/") {
+		if part == "." || part == ".." || strings.HasPrefix(part, ".") {
+			return fmt.Errorf("invalid blob key %q", key)
+		}
+	}
+
+	for _, c := range key {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '/', c == '.', c == '-', c == '_':
+		default:
+			return fmt.Errorf("invalid blob key %q", key)
+		}
+	}
+
+	return nil
+}
+
+// ContentType is the content type of the blob stored under key
+func ContentType(key string) string {
+	if t := mime.TypeByExtension(path.Ext(key)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
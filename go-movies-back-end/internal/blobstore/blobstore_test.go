@@ -0,0 +1,118 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckKey(t *testing.T) {
+	tests := []struct {
+		key string
+		ok  bool
+	}{
+		{"posters/1-5f2c0e9a.jpg", true},
+		{"variants/ab/abcdef.webp", true},
+		{"a_b-c.d", true},
+		{"", false},
+		{"../secret", false},
+		{"posters/../../secret", false},
+		{"posters/../1.jpg", false},
+		{"/etc/passwd", false},
+		{"posters//1.jpg", false},
+		{"posters/./1.jpg", false},
+		{"posters/", false},
+		{".hidden", false},
+		{"posters/.upload-123", false},
+		{`posters\..\secret`, false},
+		{"Posters/1.jpg", false},
+		{"posters/1 2.jpg", false},
+		{"posters/1.jpg\x00.png", false},
+		{"posters/é.jpg", false},
+		{strings.Repeat("a", 256), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			err := CheckKey(tt.key)
+			if (err == nil) != tt.ok {
+				t.Errorf("CheckKey(%q) = %v, want ok %v", tt.key, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestLocal(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store, err := NewLocal(filepath.Join(dir, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Put(ctx, "posters/1-abc.png", strings.NewReader("picture"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob, err := store.Get(ctx, "posters/1-abc.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(blob.Body)
+	blob.Close()
+	if err != nil || string(data) != "picture" || blob.Size != 7 || blob.ContentType != "image/png" {
+		t.Errorf("blob = %+v with %q, %v", blob, data, err)
+	}
+
+	// no temporary file is left next to the blob
+	entries, err := os.ReadDir(filepath.Join(dir, "blobs", "posters"))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("%d files in the directory, %v", len(entries), err)
+	}
+
+	// a key that could climb out of the store is refused before the disk is touched
+	err = os.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Get(ctx, "../secret")
+	if err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(../secret) = %v", err)
+	}
+	err = store.Put(ctx, "../secret", strings.NewReader("overwritten"))
+	if err == nil {
+		t.Error("Put(../secret) worked")
+	}
+	err = store.Delete(ctx, "../secret")
+	if err == nil {
+		t.Error("Delete(../secret) worked")
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "secret")); string(data) != "secret" {
+		t.Errorf("the file out of the store is now %q", data)
+	}
+
+	// a directory isn't a blob
+	_, err = store.Get(ctx, "posters")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(posters) = %v, want ErrNotFound", err)
+	}
+
+	err = store.Delete(ctx, "posters/1-abc.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Get(ctx, "posters/1-abc.png")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after Delete() = %v", err)
+	}
+	err = store.Delete(ctx, "posters/1-abc.png")
+	if err != nil {
+		t.Errorf("deleting a missing blob = %v", err)
+	}
+}
@@ -0,0 +1,343 @@
+package graph
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// Graph holds the GraphQL schema for movies and genres. Every resolver goes
+// through the DatabaseRepo, so the graph works with whatever repository the
+// application was started with.
+type Graph struct {
+	DB            repository.DatabaseRepo
+	QueryString   string
+	Variables     map[string]interface{}
+	OperationName string
+	fields        graphql.Fields
+	movieType     *graphql.Object
+	pageType      *graphql.Object
+	genreType     *graphql.Object
+}
+
+// New builds the schema on top of the given repository. Set QueryString (and
+// optionally Variables and OperationName) before calling Query.
+func New(db repository.DatabaseRepo) *Graph {
+	g := &Graph{DB: db}
+
+	g.genreType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "Genre",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"genre": &graphql.Field{
+					Type: graphql.String,
+				},
+			},
+		},
+	)
+
+	g.movieType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "Movie",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"title": &graphql.Field{
+					Type: graphql.String,
+				},
+				"description": &graphql.Field{
+					Type: graphql.String,
+				},
+				"release_date": &graphql.Field{
+					Type: graphql.DateTime,
+				},
+				"runtime": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"mpaa_rating": &graphql.Field{
+					Type: graphql.String,
+				},
+				"image": &graphql.Field{
+					Type: graphql.String,
+				},
+				"genres": &graphql.Field{
+					Type:    graphql.NewList(g.genreType),
+					Resolve: g.resolveMovieGenres,
+				},
+			},
+		},
+	)
+
+	// a page of a listing, like the ones of GET /movies
+	g.pageType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "MoviePage",
+			Fields: graphql.Fields{
+				"movies": &graphql.Field{
+					Type: graphql.NewList(g.movieType),
+				},
+				"next_cursor": &graphql.Field{
+					Type:        graphql.String,
+					Description: "The after of the next page, null on the last one",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						page, ok := p.Source.(*repository.MoviePage)
+						if !ok || page.NextCursor == "" {
+							return nil, nil
+						}
+						return page.NextCursor, nil
+					},
+				},
+			},
+		},
+	)
+
+	g.fields = graphql.Fields{
+		"list": &graphql.Field{
+			Type:        g.pageType,
+			Description: "Get all movies, one page at a time",
+			Args: graphql.FieldConfigArgument{
+				"limit": &graphql.ArgumentConfig{
+					Type: graphql.Int,
+				},
+				"after": &graphql.ArgumentConfig{
+					Type: graphql.String,
+				},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				after, _ := p.Args["after"].(string)
+
+				page, err := g.DB.AllMovies(p.Context, repository.MovieListOptions{Limit: pageSize(p), After: after})
+				if err != nil {
+					return nil, err
+				}
+
+				page.Movies, err = g.withGenres(p, page.Movies, "movies", "genres")
+				if err != nil {
+					return nil, err
+				}
+
+				return page, nil
+			},
+		},
+
+		"search": &graphql.Field{
+			Type:        graphql.NewList(g.movieType),
+			Description: "Search movies by title",
+			Args: graphql.FieldConfigArgument{
+				"titleContains": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"limit": &graphql.ArgumentConfig{
+					Type: graphql.Int,
+				},
+			},
+			Resolve: g.resolveSearch,
+		},
+
+		"get": &graphql.Field{
+			Type:        g.movieType,
+			Description: "Get movie by id",
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				id, _ := p.Args["id"].(int)
+
+				movie, err := g.DB.OneMovie(p.Context, id)
+				if errors.Is(err, sql.ErrNoRows) {
+					// an unknown id is not an error in GraphQL, the field is just null
+					return nil, nil
+				}
+
+				return movie, err
+			},
+		},
+
+		"genres": &graphql.Field{
+			Type:        graphql.NewList(g.genreType),
+			Description: "Get all genres",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return g.DB.AllGenres(p.Context)
+			},
+		},
+
+		"genre": &graphql.Field{
+			Type:        graphql.NewList(g.movieType),
+			Description: "Get all movies of a genre",
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				id, _ := p.Args["id"].(int)
+
+				movies, err := g.DB.AllMoviesByGenre(p.Context, id)
+				if err != nil {
+					return nil, err
+				}
+
+				return g.withGenres(p, movies, "genres")
+			},
+		},
+	}
+
+	return g
+}
+
+// Query runs QueryString against the schema. The context is handed to every resolver, so
+// the database queries stop when it is cancelled.
+func (g *Graph) Query(ctx context.Context) (*graphql.Result, error) {
+	rootQuery := graphql.ObjectConfig{Name: "RootQuery", Fields: g.fields}
+	schemaConfig := graphql.SchemaConfig{Query: graphql.NewObject(rootQuery)}
+
+	schema, err := graphql.NewSchema(schemaConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	params := graphql.Params{
+		Schema:         schema,
+		RequestString:  g.QueryString,
+		VariableValues: g.Variables,
+		OperationName:  g.OperationName,
+		Context:        ctx,
+	}
+
+	resp := graphql.Do(params)
+	if len(resp.Errors) > 0 {
+		return nil, errors.New(resp.Errors[0].Message)
+	}
+
+	return resp, nil
+}
+
+func (g *Graph) resolveSearch(p graphql.ResolveParams) (interface{}, error) {
+	search, _ := p.Args["titleContains"].(string)
+
+	page, err := g.DB.AllMovies(p.Context, repository.MovieListOptions{TitleContains: search, Limit: pageSize(p)})
+	if err != nil {
+		return nil, err
+	}
+
+	return g.withGenres(p, page.Movies, "genres")
+}
+
+// pageSize is the limit argument of a listing, repository.DefaultPageSize when it is left
+// out or isn't positive and at most repository.MaxPageSize
+func pageSize(p graphql.ResolveParams) int {
+	limit, _ := p.Args["limit"].(int)
+	if limit < 1 {
+		return repository.DefaultPageSize
+	}
+	if limit > repository.MaxPageSize {
+		return repository.MaxPageSize
+	}
+	return limit
+}
+
+// withGenres loads the genres of a list of movies with one query, when the client asks
+// for them at path, so that resolveMovieGenres doesn't have to go to the database per movie
+func (g *Graph) withGenres(p graphql.ResolveParams, movies []*models.Movie, path ...string) ([]*models.Movie, error) {
+	if len(movies) == 0 || !selects(p, path...) {
+		return movies, nil
+	}
+
+	ids := make([]int, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	genres, err := g.DB.MovieGenres(p.Context, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, movie := range movies {
+		movie.Genres = genres[movie.ID]
+	}
+
+	return movies, nil
+}
+
+// selects tells whether the query asks for the field at path below the objects a resolver
+// returns, e.g. "movies", "genres" for the genres of the movies of a page, directly or
+// through fragments
+func selects(p graphql.ResolveParams, path ...string) bool {
+	var sets []*ast.SelectionSet
+	for _, field := range p.Info.FieldASTs {
+		sets = append(sets, field.SelectionSet)
+	}
+
+	for _, name := range path {
+		var next []*ast.SelectionSet
+		for _, set := range sets {
+			next = append(next, fieldSelections(p, set, name)...)
+		}
+		if len(next) == 0 {
+			return false
+		}
+		sets = next
+	}
+
+	return true
+}
+
+// fieldSelections returns the selection sets of the fields called name in set. A field
+// that has none, like a scalar, gives a nil one.
+func fieldSelections(p graphql.ResolveParams, set *ast.SelectionSet, name string) []*ast.SelectionSet {
+	if set == nil {
+		return nil
+	}
+
+	var sets []*ast.SelectionSet
+	for _, selection := range set.Selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if selection.Name != nil && selection.Name.Value == name {
+				sets = append(sets, selection.SelectionSet)
+			}
+		case *ast.InlineFragment:
+			sets = append(sets, fieldSelections(p, selection.SelectionSet, name)...)
+		case *ast.FragmentSpread:
+			fragment, ok := p.Info.Fragments[selection.Name.Value].(*ast.FragmentDefinition)
+			if ok {
+				sets = append(sets, fieldSelections(p, fragment.SelectionSet, name)...)
+			}
+		}
+	}
+
+	return sets
+}
+
+// resolveMovieGenres returns the genres of a movie. Movies loaded through OneMovie already
+// carry their genres, and the lists load them all at once with withGenres; a movie that
+// has none yet gets them here.
+func (g *Graph) resolveMovieGenres(p graphql.ResolveParams) (interface{}, error) {
+	movie, ok := p.Source.(*models.Movie)
+	if !ok {
+		return nil, nil
+	}
+
+	if movie.Genres != nil {
+		return movie.Genres, nil
+	}
+
+	full, err := g.DB.OneMovie(p.Context, movie.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return full.Genres, nil
+}
@@ -0,0 +1,180 @@
+package graph
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/repository/memrepo"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// countingRepo counts the movies read one at a time
+type countingRepo struct {
+	repository.DatabaseRepo
+	oneMovie int
+}
+
+func (c *countingRepo) OneMovie(ctx context.Context, id int) (*models.Movie, error) {
+	c.oneMovie++
+	return c.DatabaseRepo.OneMovie(ctx, id)
+}
+
+// newRepo returns a memory repository with the default fixtures and extra more movies
+func newRepo(t *testing.T, extra int) *countingRepo {
+	t.Helper()
+
+	fixtures := memrepo.DefaultFixtures()
+	for i := 0; i < extra; i++ {
+		fixtures.Movies = append(fixtures.Movies, &models.Movie{
+			Title:       fmt.Sprintf("Zzz %03d", i),
+			ReleaseDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			RunTime:     90,
+			MPAARating:  "PG",
+			CreatedAt:   time.Now(),
+			UpdatedAt:   time.Now(),
+		})
+	}
+
+	repo := memrepo.New()
+	err := repo.Seed(fixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &countingRepo{DatabaseRepo: repo}
+}
+
+// query runs q on repo and decodes its data into v
+func query(t *testing.T, repo repository.DatabaseRepo, q string, v interface{}) {
+	t.Helper()
+
+	g := New(repo)
+	g.QueryString = q
+
+	resp, err := g.Query(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(resp.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type page struct {
+	List struct {
+		Movies []struct {
+			Title  string `json:"title"`
+			Genres []struct {
+				Genre string `json:"genre"`
+			} `json:"genres"`
+		} `json:"movies"`
+		NextCursor *string `json:"next_cursor"`
+	} `json:"list"`
+}
+
+func TestListLimit(t *testing.T) {
+	repo := newRepo(t, 150)
+
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{"no limit", `{ list { movies { title } next_cursor } }`, repository.DefaultPageSize},
+		{"zero", `{ list(limit: 0) { movies { title } next_cursor } }`, repository.DefaultPageSize},
+		{"negative", `{ list(limit: -5) { movies { title } next_cursor } }`, repository.DefaultPageSize},
+		{"some", `{ list(limit: 7) { movies { title } next_cursor } }`, 7},
+		{"too many", `{ list(limit: 1000) { movies { title } next_cursor } }`, repository.MaxPageSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp page
+			query(t, repo, tt.query, &resp)
+
+			if len(resp.List.Movies) != tt.want {
+				t.Errorf("%d movies, want %d", len(resp.List.Movies), tt.want)
+			}
+			if resp.List.NextCursor == nil {
+				t.Error("no next_cursor on a page that isn't the last")
+			}
+		})
+	}
+}
+
+func TestListPages(t *testing.T) {
+	repo := newRepo(t, 0)
+
+	var titles []string
+	q := `{ list(limit: 2) { movies { title genres { genre } } next_cursor } }`
+
+	for pages := 0; ; pages++ {
+		if pages == 3 {
+			t.Fatal("more pages than movies")
+		}
+
+		var resp page
+		query(t, repo, q, &resp)
+
+		for _, movie := range resp.List.Movies {
+			titles = append(titles, movie.Title)
+			if len(movie.Genres) == 0 {
+				t.Errorf("%s has no genres", movie.Title)
+			}
+		}
+
+		if resp.List.NextCursor == nil {
+			break
+		}
+		q = fmt.Sprintf(`{ list(limit: 2, after: %q) { movies { title genres { genre } } next_cursor } }`, *resp.List.NextCursor)
+	}
+
+	if fmt.Sprint(titles) != "[Highlander Raiders of the Lost Ark The Godfather]" {
+		t.Errorf("titles = %v", titles)
+	}
+
+	// the genres of a page come with one query, not one per movie
+	if repo.oneMovie != 0 {
+		t.Errorf("%d movies read one at a time", repo.oneMovie)
+	}
+}
+
+func TestSearchLimit(t *testing.T) {
+	repo := newRepo(t, 150)
+
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{"no limit", `{ search(titleContains: "zzz") { title } }`, repository.DefaultPageSize},
+		{"some", `{ search(titleContains: "zzz", limit: 3) { title } }`, 3},
+		{"too many", `{ search(titleContains: "zzz", limit: 1000) { title } }`, repository.MaxPageSize},
+		{"fewer matches", `{ search(titleContains: "godfather", limit: 50) { title } }`, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp struct {
+				Search []struct {
+					Title string `json:"title"`
+				} `json:"search"`
+			}
+			query(t, repo, tt.query, &resp)
+
+			if len(resp.Search) != tt.want {
+				t.Errorf("%d movies, want %d", len(resp.Search), tt.want)
+			}
+		})
+	}
+}
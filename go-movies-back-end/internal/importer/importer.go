@@ -0,0 +1,369 @@
+// Package importer loads movies in bulk from the CSV, NDJSON or JSON files distributors
+// send us. Every row is checked and matched against the catalogue first, which gives a
+// report of what would be created, updated or rejected; only then, unless it is a dry
+// run, are the valid rows saved.
+package importer
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/revisions"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The formats an import file can have
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+	FormatJSON   = "json"
+)
+
+// What happens to a row
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionReject = "reject"
+)
+
+// MPAARatings are the ratings a movie can have
+var MPAARatings = []string{"G", "PG", "PG-13", "R", "NC-17", "18A"}
+
+// Row tells what happens, or would happen on a dry run, to one row of the file. Row is the
+// line number for CSV and NDJSON files (the CSV header is line 1), and the position in
+// the array for JSON files.
+//
+// Movie is the movie as the row leaves it: for an update, the movie we have with the
+// columns of the row written over it. Its genres are only set when the row replaces them,
+// an update that leaves the genres out keeps those the movie has.
+type Row struct {
+	Row    int           `json:"row"`
+	Action string        `json:"action"`
+	ID     int           `json:"id,omitempty"` // the movie that was or will be updated, or the new one
+	Title  string        `json:"title"`
+	Movie  *models.Movie `json:"movie,omitempty"`
+	Errors []string      `json:"errors,omitempty"`
+}
+
+// Report is the outcome of an import
+type Report struct {
+	DryRun   bool  `json:"dry_run"`
+	Created  int   `json:"created"`
+	Updated  int   `json:"updated"`
+	Rejected int   `json:"rejected"`
+	Rows     []Row `json:"rows"`
+}
+
+// FormatFromName guesses the format of a file from its extension
+func FormatFromName(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return FormatCSV
+	case ".ndjson", ".jsonl":
+		return FormatNDJSON
+	case ".json":
+		return FormatJSON
+	}
+	return ""
+}
+
+// Options controls an import
+type Options struct {
+	Format string // one of the Format constants
+	DryRun bool   // only report what would happen
+	UserID int    // the user the revisions of the imported movies are recorded for, zero for none
+}
+
+// planned is a row that passed the checks, with the movie it turns into. For an update
+// the movie only has the columns in provided, the others are read when it is saved.
+type planned struct {
+	row      *Row
+	movie    models.Movie
+	provided map[string]bool
+	genreIDs []int
+}
+
+// Import reads movies from r and saves them. A row with an id updates that movie, so
+// does a row with the title and release date of a movie we already have; any other row
+// creates a movie. Genres are given by name. An update only changes the columns the file
+// has: a row with an empty genres cell, or an empty list, clears the genres, a file
+// without a genres column or a record without the key leaves them as they are.
+//
+// Rejected rows are reported and skipped, the others are saved in one transaction, each
+// with a revision. On a dry run nothing is saved.
+func Import(ctx context.Context, db repository.DatabaseRepo, r io.Reader, opts Options) (*Report, error) {
+	entries, err := parse(r, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	genres, err := db.AllGenres(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	genreIDs := make(map[string]int)
+	genresByID := make(map[int]*models.Genre)
+	for _, g := range genres {
+		genreIDs[strings.ToLower(g.Genre)] = g.ID
+		genresByID[g.ID] = g
+	}
+
+	page, err := db.AllMovies(ctx, repository.MovieListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int]*models.Movie)
+	byKey := make(map[string]*models.Movie)
+	for _, movie := range page.Movies {
+		byID[movie.ID] = movie
+		byKey[movieKey(movie.Title, movie.ReleaseDate)] = movie
+	}
+
+	report := &Report{DryRun: opts.DryRun, Rows: make([]Row, len(entries))}
+	seen := make(map[string]int) // movie key -> row it was first seen on
+
+	var plan []planned
+
+	for i, e := range entries {
+		row := &report.Rows[i]
+		row.Row = e.row
+		row.Title = e.record.Title
+
+		var movie models.Movie
+		var ids []int
+
+		if e.err != nil {
+			row.Errors = []string{e.err.Error()}
+		} else {
+			movie, ids, row.Errors = check(e.record, genreIDs)
+		}
+
+		if len(row.Errors) == 0 {
+			key := movieKey(movie.Title, movie.ReleaseDate)
+
+			switch {
+			case movie.ID > 0:
+				if _, ok := byID[movie.ID]; !ok {
+					row.Errors = append(row.Errors, fmt.Sprintf("there is no movie with id %d", movie.ID))
+				}
+			case byKey[key] != nil:
+				movie.ID = byKey[key].ID
+			}
+
+			// the same movie twice in one file is most likely a mistake in the spreadsheet
+			if first, ok := seen[key]; ok {
+				row.Errors = append(row.Errors, fmt.Sprintf("same title and release date as row %d", first))
+			}
+			seen[key] = e.row
+		}
+
+		switch {
+		case len(row.Errors) > 0:
+			row.Action = ActionReject
+			report.Rejected++
+			continue
+		case movie.ID > 0:
+			row.Action = ActionUpdate
+			row.ID = movie.ID
+			report.Updated++
+		default:
+			row.Action = ActionCreate
+			report.Created++
+		}
+
+		result := movie
+		if row.Action == ActionUpdate {
+			result = merge(*byID[movie.ID], movie, e.record.provided)
+		}
+		for _, id := range ids {
+			result.Genres = append(result.Genres, genresByID[id])
+		}
+		row.Movie = &result
+
+		plan = append(plan, planned{row: row, movie: movie, provided: e.record.provided, genreIDs: ids})
+	}
+
+	if opts.DryRun || len(plan) == 0 {
+		return report, nil
+	}
+
+	err = db.WithTx(ctx, func(repo repository.DatabaseRepo) error {
+		return save(ctx, repo, plan, opts.UserID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+func save(ctx context.Context, repo repository.DatabaseRepo, plan []planned, userID int) error {
+	now := time.Now().UTC()
+
+	for _, p := range plan {
+		movie := p.movie
+		movie.UpdatedAt = now
+
+		if p.row.Action == ActionCreate {
+			movie.CreatedAt = now
+
+			id, err := repo.InsertMovie(ctx, movie)
+			if err != nil {
+				return fmt.Errorf("row %d: %w", p.row.Row, err)
+			}
+			movie.ID = id
+			p.row.ID = id
+			p.row.Movie.ID = id
+		} else {
+			// the movie is read again in the transaction, it may have changed since the
+			// report was made
+			current, err := repo.OneMovie(ctx, movie.ID)
+			if err != nil {
+				return fmt.Errorf("row %d: %w", p.row.Row, err)
+			}
+			movie = merge(*current, movie, p.provided)
+			movie.UpdatedAt = now
+
+			// the file wins over whatever the movie is now, there's no version to check
+			err = repo.UpdateMovie(ctx, movie, time.Time{})
+			if err != nil {
+				return fmt.Errorf("row %d: %w", p.row.Row, err)
+			}
+		}
+
+		if p.row.Action == ActionCreate || p.provided["genres"] {
+			err := repo.UpdateMovieGenres(ctx, movie.ID, p.genreIDs)
+			if err != nil {
+				return fmt.Errorf("row %d: %w", p.row.Row, err)
+			}
+		}
+
+		_, err := revisions.Record(ctx, repo, movie.ID, userID, revisions.ActionImport)
+		if err != nil {
+			return fmt.Errorf("row %d: %w", p.row.Row, err)
+		}
+	}
+
+	return nil
+}
+
+// merge writes the columns of file that a row provides over movie. The title and the
+// release date are always there.
+func merge(movie, file models.Movie, provided map[string]bool) models.Movie {
+	movie.Title = file.Title
+	movie.ReleaseDate = file.ReleaseDate
+	movie.Genres = nil
+
+	if provided["runtime"] {
+		movie.RunTime = file.RunTime
+	}
+	if provided["mpaa_rating"] {
+		movie.MPAARating = file.MPAARating
+	}
+	if provided["description"] {
+		movie.Description = file.Description
+	}
+	if provided["image"] {
+		movie.Image = file.Image
+	}
+
+	return movie
+}
+
+// check turns a record into a movie, listing everything that is wrong with it
+func check(rec record, genreIDs map[string]int) (models.Movie, []int, []string) {
+	var problems []string
+
+	movie := models.Movie{
+		ID:          rec.ID,
+		Title:       strings.TrimSpace(rec.Title),
+		RunTime:     rec.RunTime,
+		MPAARating:  strings.TrimSpace(rec.MPAARating),
+		Description: strings.TrimSpace(rec.Description),
+		Image:       strings.TrimSpace(rec.Image),
+	}
+
+	if movie.Title == "" {
+		problems = append(problems, "title is required")
+	}
+
+	if rec.ID < 0 {
+		problems = append(problems, "id must be positive")
+	}
+
+	releaseDate, err := parseDate(rec.ReleaseDate)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	movie.ReleaseDate = releaseDate
+
+	if movie.RunTime < 0 {
+		problems = append(problems, "runtime can't be negative")
+	}
+
+	if movie.MPAARating != "" && !validRating(movie.MPAARating) {
+		problems = append(problems, fmt.Sprintf("unknown mpaa_rating %q, use one of %s", movie.MPAARating, strings.Join(MPAARatings, ", ")))
+	}
+
+	var ids []int
+	added := make(map[int]bool)
+
+	for _, name := range rec.Genres {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		id, ok := genreIDs[strings.ToLower(name)]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unknown genre %q", name))
+			continue
+		}
+
+		if !added[id] {
+			added[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	return movie, ids, problems
+}
+
+// parseDate reads a release date written as 2006-01-02, or as the full timestamp the API
+// returns
+func parseDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, fmt.Errorf("release_date is required")
+	}
+
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC().Truncate(24 * time.Hour), nil
+	}
+
+	return time.Time{}, fmt.Errorf("release_date %q is not a date like 2006-01-02", s)
+}
+
+func validRating(rating string) bool {
+	for _, r := range MPAARatings {
+		if r == rating {
+			return true
+		}
+	}
+	return false
+}
+
+// movieKey identifies a movie by what a spreadsheet would have, its title and release date
+func movieKey(title string, releaseDate time.Time) string {
+	return strings.ToLower(strings.TrimSpace(title)) + "|" + releaseDate.Format("2006-01-02")
+}
@@ -0,0 +1,232 @@
+package importer
+
+import (
+	"backend/internal/repository/memrepo"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		file   string
+	}{
+		{FormatCSV, "title,release_date,runtime,genres\nAlien,1979-05-25,117,Horror|Sci-Fi\n"},
+		{FormatNDJSON, `{"title":"Alien","release_date":"1979-05-25","runtime":117,"genres":["Horror","Sci-Fi"]}` + "\n\n"},
+		// what the API exports can be imported again
+		{FormatJSON, `[{"title":"Alien","release_date":"1979-05-25T00:00:00Z","runtime":117,"genres":[{"id":3,"genre":"Horror"},{"id":2,"genre":"Sci-Fi"}]}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			entries, err := parse(strings.NewReader(tt.file), tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 || entries[0].err != nil {
+				t.Fatalf("entries = %+v, want one good record", entries)
+			}
+
+			rec := entries[0].record
+			if rec.Title != "Alien" || rec.RunTime != 117 || fmt.Sprint(rec.Genres) != "[Horror Sci-Fi]" {
+				t.Errorf("record = %+v", rec)
+			}
+			if !rec.provided["runtime"] || rec.provided["image"] {
+				t.Errorf("provided = %v, want runtime and not image", rec.provided)
+			}
+		})
+	}
+}
+
+func TestParseBrokenRows(t *testing.T) {
+	file := "title,release_date,runtime\n" +
+		"Alien,1979-05-25,long\n" +
+		"Aliens,1986-07-18\n" +
+		"Alien 3,1992-05-22,114\n"
+
+	entries, err := parse(strings.NewReader(file), FormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("%d entries, want 3", len(entries))
+	}
+
+	// broken rows are kept with their line, to be reported
+	if entries[0].row != 2 || entries[0].err == nil {
+		t.Errorf("row 2 = %+v, want a runtime error", entries[0])
+	}
+	if entries[1].row != 3 || entries[1].err == nil {
+		t.Errorf("row 3 = %+v, want a field count error", entries[1])
+	}
+	if entries[2].row != 4 || entries[2].err != nil {
+		t.Errorf("row 4 = %+v, want a good record", entries[2])
+	}
+}
+
+func TestParseBrokenFiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		file   string
+	}{
+		{"empty", FormatCSV, ""},
+		{"unknown column", FormatCSV, "title,release_date,director\n"},
+		{"missing column", FormatCSV, "title,runtime\n"},
+		{"twice", FormatCSV, "title,release_date,title\n"},
+		{"not an array", FormatJSON, `{"title":"Alien"}`},
+		{"unknown format", "xml", "<movies/>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parse(strings.NewReader(tt.file), tt.format)
+			if err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+
+	repo := memrepo.New()
+	err := repo.Seed(memrepo.DefaultFixtures())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := "id,title,release_date,runtime,mpaa_rating,genres\n" +
+		// matched by id, the description and image the file doesn't have stay, the empty
+		// genres cell clears the genres
+		"1,Highlander,1986-03-07,120,R,\n" +
+		// matched by title and release date, the genres are replaced
+		",the godfather,1972-03-24,175,R,Drama\n" +
+		",Alien,1979-05-25,117,R,Horror|Sci-Fi\n" +
+		",Nobody,1990-01-01,90,X,\n" +
+		"42,Nowhere,1990-01-01,90,R,\n" +
+		",Alien,1979-05-25,117,R,Unknown\n"
+
+	report, err := Import(ctx, repo, strings.NewReader(file), Options{Format: FormatCSV, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actions []string
+	for _, row := range report.Rows {
+		actions = append(actions, row.Action)
+	}
+	if got := fmt.Sprint(actions); got != "[update update create reject reject reject]" {
+		t.Errorf("actions = %s", got)
+	}
+	if report.Created != 1 || report.Updated != 2 || report.Rejected != 3 {
+		t.Errorf("report = %d created, %d updated, %d rejected", report.Created, report.Updated, report.Rejected)
+	}
+
+	// the dry run shows the movie as the update would leave it
+	highlander := report.Rows[0].Movie
+	if highlander.RunTime != 120 || !strings.HasPrefix(highlander.Description, "He fought") || highlander.Image == "" {
+		t.Errorf("merged movie = %+v", highlander)
+	}
+
+	movie, err := repo.OneMovie(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if movie.RunTime != 116 {
+		t.Error("the dry run saved")
+	}
+
+	report, err = Import(ctx, repo, strings.NewReader(file), Options{Format: FormatCSV, UserID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	movie, err = repo.OneMovie(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if movie.RunTime != 120 || !strings.HasPrefix(movie.Description, "He fought") || movie.Image == "" {
+		t.Errorf("Highlander after the import = %+v", movie)
+	}
+	if len(movie.Genres) != 0 {
+		t.Errorf("Highlander has %d genres, the row's empty cell should clear them", len(movie.Genres))
+	}
+
+	movie, err = repo.OneMovie(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movie.Genres) != 1 || movie.Genres[0].Genre != "Drama" {
+		t.Errorf("The Godfather has the genres %v, want Drama", movie.Genres)
+	}
+
+	alien, err := repo.OneMovie(ctx, report.Rows[2].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alien.Title != "Alien" || len(alien.Genres) != 2 {
+		t.Errorf("Alien = %+v", alien)
+	}
+
+	history, err := repo.MovieRevisions(ctx, alien.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Action != "import" {
+		t.Errorf("Alien has the revisions %+v, want one import", history)
+	}
+}
+
+func TestImportGenres(t *testing.T) {
+	ctx := context.Background()
+
+	// Highlander has the genres Action and Fantasy
+	tests := []struct {
+		name   string
+		format string
+		file   string
+		want   string
+	}{
+		{"no genres column", FormatCSV, "id,title,release_date\n1,Highlander,1986-03-07\n", "[Action Fantasy]"},
+		{"empty cell", FormatCSV, "id,title,release_date,genres\n1,Highlander,1986-03-07,\n", "[]"},
+		{"some genres", FormatCSV, "id,title,release_date,genres\n1,Highlander,1986-03-07,Drama\n", "[Drama]"},
+		{"no genres key", FormatNDJSON, `{"id":1,"title":"Highlander","release_date":"1986-03-07"}`, "[Action Fantasy]"},
+		{"empty list", FormatNDJSON, `{"id":1,"title":"Highlander","release_date":"1986-03-07","genres":[]}`, "[]"},
+		{"null", FormatNDJSON, `{"id":1,"title":"Highlander","release_date":"1986-03-07","genres":null}`, "[]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := memrepo.New()
+			err := repo.Seed(memrepo.DefaultFixtures())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			report, err := Import(ctx, repo, strings.NewReader(tt.file), Options{Format: tt.format})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Updated != 1 {
+				t.Fatalf("report = %+v, want an update", report)
+			}
+
+			movie, err := repo.OneMovie(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var genres []string
+			for _, g := range movie.Genres {
+				genres = append(genres, g.Genre)
+			}
+			if fmt.Sprint(genres) != tt.want {
+				t.Errorf("genres = %v, want %s", genres, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// record is one movie as read from an import file, before it has been checked
+type record struct {
+	ID          int        `json:"id"`
+	Title       string     `json:"title"`
+	ReleaseDate string     `json:"release_date"`
+	RunTime     int        `json:"runtime"`
+	MPAARating  string     `json:"mpaa_rating"`
+	Description string     `json:"description"`
+	Image       string     `json:"image"`
+	Genres      genreNames `json:"genres"`
+
+	// provided holds the columns the file gives for this record, or the keys of its JSON
+	// object, in lower case. An update leaves the other columns of the movie as they are.
+	provided map[string]bool
+}
+
+// entry is a record together with where it was found, or why it couldn't be read
+type entry struct {
+	row    int
+	record record
+	err    error
+}
+
+// genreNames accepts the genres of a movie either as a list of names, or as the list of
+// genre objects the API hands out, so that an export can be imported again.
+type genreNames []string
+
+func (g *genreNames) UnmarshalJSON(b []byte) error {
+	var names []string
+	if err := json.Unmarshal(b, &names); err == nil {
+		*g = names
+		return nil
+	}
+
+	var genres []struct {
+		Genre string `json:"genre"`
+	}
+	if err := json.Unmarshal(b, &genres); err != nil {
+		return errors.New("genres must be a list of genre names")
+	}
+
+	for _, genre := range genres {
+		*g = append(*g, genre.Genre)
+	}
+	return nil
+}
+
+// csvColumns are the columns a CSV file may have. The first line names them, in any
+// order; only title and release_date are required.
+var csvColumns = map[string]bool{
+	"id":           true,
+	"title":        true,
+	"release_date": true,
+	"runtime":      true,
+	"mpaa_rating":  true,
+	"description":  true,
+	"image":        true,
+	"genres":       true,
+}
+
+// parse reads every record of a file. An error is only returned when the file as a whole
+// can't be read; a row that is broken is returned with its error so it can be reported.
+func parse(r io.Reader, format string) ([]entry, error) {
+	switch format {
+	case FormatCSV:
+		return parseCSV(r)
+	case FormatNDJSON:
+		return parseNDJSON(r)
+	case FormatJSON:
+		return parseJSON(r)
+	}
+	return nil, fmt.Errorf("unknown format %q, use csv, ndjson or json", format)
+}
+
+func parseCSV(r io.Reader) ([]entry, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !csvColumns[name] {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("column %q appears twice", name)
+		}
+		columns[name] = i
+	}
+
+	for _, required := range []string{"title", "release_date"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("the %s column is missing", required)
+		}
+	}
+
+	var entries []entry
+
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			// a row with too many or too few fields is still read, it is just wrong
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) && errors.Is(err, csv.ErrFieldCount) {
+				entries = append(entries, entry{row: parseErr.StartLine, err: fmt.Errorf("expected %d fields, got %d", len(header), len(fields))})
+				continue
+			}
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		e := entry{row: line}
+		e.record, e.err = csvRecord(fields, columns)
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+func csvRecord(fields []string, columns map[string]int) (record, error) {
+	get := func(name string) string {
+		i, ok := columns[name]
+		if !ok {
+			return ""
+		}
+		return strings.TrimSpace(fields[i])
+	}
+
+	rec := record{
+		Title:       get("title"),
+		ReleaseDate: get("release_date"),
+		MPAARating:  get("mpaa_rating"),
+		Description: get("description"),
+		Image:       get("image"),
+		provided:    make(map[string]bool),
+	}
+
+	// an empty cell of a column the file has clears the value, like "" in JSON
+	for name := range columns {
+		rec.provided[name] = true
+	}
+
+	var err error
+
+	if id := get("id"); id != "" {
+		rec.ID, err = strconv.Atoi(id)
+		if err != nil {
+			return rec, fmt.Errorf("id %q is not a number", id)
+		}
+	}
+
+	if runtime := get("runtime"); runtime != "" {
+		rec.RunTime, err = strconv.Atoi(runtime)
+		if err != nil {
+			return rec, fmt.Errorf("runtime %q is not a number", runtime)
+		}
+	}
+
+	// spreadsheets list several genres in one cell, e.g. "Drama|Crime" or "Drama, Crime"
+	rec.Genres = strings.FieldsFunc(get("genres"), func(r rune) bool {
+		return r == '|' || r == ',' || r == ';'
+	})
+
+	return rec, nil
+}
+
+// parseNDJSON reads one JSON object per line, blank lines are skipped
+func parseNDJSON(r io.Reader) ([]entry, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var entries []entry
+
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		e := entry{row: line}
+		e.err = decodeRecord(text, &e.record)
+		entries = append(entries, e)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// parseJSON reads a JSON array of objects, the rows are numbered from 1
+func parseJSON(r io.Reader) ([]entry, error) {
+	var items []json.RawMessage
+
+	err := json.NewDecoder(r).Decode(&items)
+	if err != nil {
+		return nil, fmt.Errorf("the file must be a JSON array of movies: %w", err)
+	}
+
+	entries := make([]entry, len(items))
+	for i, item := range items {
+		entries[i].row = i + 1
+		entries[i].err = decodeRecord(item, &entries[i].record)
+	}
+
+	return entries, nil
+}
+
+func decodeRecord(b []byte, rec *record) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(rec)
+	if err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	// the keys match the fields whatever their case, like in Decode
+	var keys map[string]json.RawMessage
+	err = json.Unmarshal(b, &keys)
+	if err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	rec.provided = make(map[string]bool)
+	for key := range keys {
+		rec.provided[strings.ToLower(key)] = true
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+// Package mailer sends the mails of the application, like password resets. Mails go
+// through an SMTP server in production and into a directory on disk, the outbox, in
+// development and tests.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"time"
+)
+
+// Mailer delivers mails
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// Message is a plain text mail. From is filled in by the Mailer when it is empty.
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+// bytes writes the message in the format of RFC 5322, ready to be sent or saved as an
+// .eml file
+func (msg *Message) bytes(date time.Time) ([]byte, error) {
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return nil, fmt.Errorf("sender %q: %w", msg.From, err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return nil, fmt.Errorf("recipient %q: %w", msg.To, err)
+	}
+
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+	buf.WriteString("\r\n")
+
+	body := quotedprintable.NewWriter(&buf)
+	_, err = body.Write([]byte(msg.Body))
+	if err != nil {
+		return nil, err
+	}
+	err = body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,228 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// body is the decoded body of a mail, with the line ends of Go
+func body(t *testing.T, msg *mail.Message) string {
+	t.Helper()
+
+	data, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.ReplaceAll(string(data), "\r\n", "\n")
+}
+
+func TestMessageBytes(t *testing.T) {
+	msg := &Message{
+		From:    "Go Movies <no-reply@example.com>",
+		To:      "jane@example.com",
+		Subject: "Réinitialiser\r\nBcc: mallory@example.com",
+		Body:    "Hello Jane,\n\nopen https://example.com/reset-password?token=" + strings.Repeat("a", 100) + "\n",
+	}
+
+	data, err := msg.bytes(time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the line break in the subject doesn't make a header of its own
+	if parsed.Header.Get("Bcc") != "" {
+		t.Error("the subject added a Bcc header")
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != msg.Subject {
+		t.Errorf("subject = %q, %v", subject, err)
+	}
+
+	if got := body(t, parsed); got != msg.Body {
+		t.Errorf("body = %q", got)
+	}
+
+	// quoted-printable keeps the long link on lines SMTP accepts
+	for _, line := range strings.Split(string(data), "\r\n") {
+		if len(line) > 78 {
+			t.Errorf("line of %d characters: %q", len(line), line)
+		}
+	}
+
+	tests := []struct {
+		name string
+		msg  Message
+	}{
+		{"no sender", Message{To: "jane@example.com"}},
+		{"bad recipient", Message{From: "no-reply@example.com", To: "jane"}},
+		{"two recipients", Message{From: "no-reply@example.com", To: "jane@example.com, john@example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.msg.bytes(time.Now())
+			if err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+
+	outbox, err := NewOutbox(dir, "Go Movies <no-reply@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = outbox.Send(context.Background(), &Message{To: "Jane <Jane@Example.com>", Subject: "Hello", Body: "Hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = outbox.Send(context.Background(), &Message{To: "../john", Subject: "Hello", Body: "Hi"})
+	if err == nil {
+		t.Error("no error for an invalid address")
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || !strings.HasSuffix(files[0].Name(), "-jane@example.com.eml") {
+		t.Fatalf("files = %v", files)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from := msg.Header.Get("From"); !strings.Contains(from, "no-reply@example.com") {
+		t.Errorf("From = %q, want the sender of the outbox", from)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = outbox.Send(ctx, &Message{To: "jane@example.com"})
+	if err == nil {
+		t.Error("no error with a canceled context")
+	}
+}
+
+// fakeSMTP is an SMTP server on localhost that keeps what it is sent. It offers neither
+// STARTTLS nor AUTH.
+type fakeSMTP struct {
+	addr     string
+	commands chan string
+	data     chan string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	s := &fakeSMTP{addr: l.Addr().String(), commands: make(chan string, 100), data: make(chan string, 1)}
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			s.commands <- line
+
+			switch verb := strings.ToUpper(strings.Fields(line + " x")[0]); verb {
+			case "EHLO":
+				reply("250-localhost")
+				reply("250 8BITMIME")
+			case "DATA":
+				reply("354 go on")
+				var data strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				s.data <- data.String()
+				reply("250 queued")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+
+	return s
+}
+
+func TestSMTP(t *testing.T) {
+	server := newFakeSMTP(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	mailer := NewSMTP(server.addr, "", "", "Go Movies <no-reply@example.com>")
+	err := mailer.Send(ctx, &Message{To: "Jane <jane@example.com>", Subject: "Hello", Body: "Hi Jane"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	close(server.commands)
+	var commands []string
+	for command := range server.commands {
+		commands = append(commands, command)
+	}
+	want := []string{"EHLO localhost", "MAIL FROM:<no-reply@example.com> BODY=8BITMIME", "RCPT TO:<jane@example.com>", "DATA", "QUIT"}
+	if strings.Join(commands, "|") != strings.Join(want, "|") {
+		t.Errorf("commands = %q, want %q", commands, want)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(<-server.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(body(t, msg)); got != "Hi Jane" {
+		t.Errorf("body = %q", got)
+	}
+}
@@ -0,0 +1,72 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Outbox "sends" mails by saving each of them as an .eml file in a directory, where they
+// can be opened with a mail client or read by a test. Nothing leaves the machine.
+type Outbox struct {
+	Dir  string
+	From string // the sender of messages that don't have one
+}
+
+// NewOutbox returns an Outbox saving into dir, which is created if needed
+func NewOutbox(dir, from string) (*Outbox, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Outbox{Dir: dir, From: from}, nil
+}
+
+// Send saves msg as <time>-<recipient>.eml, the names sort in the order mails were sent
+func (o *Outbox) Send(ctx context.Context, msg *Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if msg.From == "" {
+		m := *msg
+		m.From = o.From
+		msg = &m
+	}
+
+	now := time.Now()
+
+	data, err := msg.bytes(now)
+	if err != nil {
+		return err
+	}
+
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return err
+	}
+
+	// the address only goes into the name to find mails by eye, anything odd in it goes
+	recipient := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '@' || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, strings.ToLower(to.Address))
+
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000"), recipient)
+
+	// written aside and renamed, so that a reader never sees half a mail
+	tmp := filepath.Join(o.Dir, "."+name)
+	err = os.WriteFile(tmp, data, 0o600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(o.Dir, name))
+}
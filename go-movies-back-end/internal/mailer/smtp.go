@@ -0,0 +1,110 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+)
+
+// SMTP sends mails through an SMTP server. The connection is upgraded with STARTTLS when
+// the server offers it, and credentials are only ever sent over TLS.
+type SMTP struct {
+	Addr     string // host:port of the server
+	Username string // no authentication when empty
+	Password string
+	From     string // the sender of messages that don't have one
+}
+
+// NewSMTP returns a Mailer that sends through the server at addr
+func NewSMTP(addr, username, password, from string) *SMTP {
+	return &SMTP{Addr: addr, Username: username, Password: password, From: from}
+}
+
+func (s *SMTP) Send(ctx context.Context, msg *Message) error {
+	if msg.From == "" {
+		m := *msg
+		m.From = s.From
+		msg = &m
+	}
+
+	data, err := msg.bytes(time.Now())
+	if err != nil {
+		return err
+	}
+
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// net/smtp knows nothing of contexts, the deadline of ctx becomes the one of the
+	// connection
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.Username != "" {
+		// PlainAuth refuses to send the password over a connection without TLS, unless the
+		// server is on localhost
+		err = client.Auth(smtp.PlainAuth("", s.Username, s.Password, host))
+		if err != nil {
+			return err
+		}
+	}
+
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return err
+	}
+
+	err = client.Mail(from.Address)
+	if err != nil {
+		return err
+	}
+	err = client.Rcpt(to.Address)
+	if err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
@@ -0,0 +1,140 @@
+// Package faketmdb is a stand-in for the API of The Movie Database. It answers the two
+// requests metadata.TMDB makes from a fixed list of movies, so that enrichment can be
+// tried and tested without an account or a network.
+package faketmdb
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Movie is a movie the fake knows
+type Movie struct {
+	ID            int
+	Title         string
+	Overview      string
+	Runtime       int
+	ReleaseDate   string // 2006-01-02
+	PosterPath    string
+	Certification string // of the US release
+}
+
+// DefaultMovies are the movies the API is seeded with, and one more
+var DefaultMovies = []Movie{
+	{
+		ID:            8009,
+		Title:         "Highlander",
+		Overview:      "He fought his first battle on the Scottish Highlands in 1536. He will fight his greatest battle on the streets of New York City in 1986. His name is Connor MacLeod. He is immortal.",
+		Runtime:       116,
+		ReleaseDate:   "1986-03-07",
+		PosterPath:    "/8Z8dptJEypuLoOQro1WugD855YE.jpg",
+		Certification: "R",
+	},
+	{
+		ID:            85,
+		Title:         "Raiders of the Lost Ark",
+		Overview:      "When Dr. Indiana Jones – the tweed-suited professor who just happens to be a celebrated archaeologist – is hired by the government to locate the legendary Ark of the Covenant, he finds himself up against the entire Nazi regime.",
+		Runtime:       115,
+		ReleaseDate:   "1981-06-12",
+		PosterPath:    "/ceG9VzoRAVGwivFU403Wc3AHRys.jpg",
+		Certification: "PG",
+	},
+	{
+		ID:            238,
+		Title:         "The Godfather",
+		Overview:      "Spanning the years 1945 to 1955, a chronicle of the fictional Italian-American Corleone crime family.",
+		Runtime:       175,
+		ReleaseDate:   "1972-03-14",
+		PosterPath:    "/3bhkrj58Vtu7enYsRolD1fZdja1.jpg",
+		Certification: "R",
+	},
+	{
+		ID:            240,
+		Title:         "The Godfather Part II",
+		Overview:      "In the continuing saga of the Corleone crime family, a young Vito Corleone grows up in Sicily and in 1910s New York.",
+		Runtime:       202,
+		ReleaseDate:   "1974-12-20",
+		PosterPath:    "/hek3koDUyRQk7FIhPXsa6mT2Zc3.jpg",
+		Certification: "R",
+	},
+}
+
+// Handler serves "/3/search/movie" and "/3/movie/{id}" from movies. Searches match any
+// title that contains the query, case insensitively, and sort exact titles first.
+func Handler(movies []Movie) http.Handler {
+	mux := chi.NewRouter()
+
+	mux.Get("/3/search/movie", func(w http.ResponseWriter, r *http.Request) {
+		query := strings.ToLower(r.URL.Query().Get("query"))
+		year := r.URL.Query().Get("primary_release_year")
+
+		results := []map[string]interface{}{}
+		for _, m := range movies {
+			if query == "" || !strings.Contains(strings.ToLower(m.Title), query) {
+				continue
+			}
+			if year != "" && !strings.HasPrefix(m.ReleaseDate, year+"-") {
+				continue
+			}
+
+			result := map[string]interface{}{"id": m.ID, "title": m.Title, "release_date": m.ReleaseDate}
+			if strings.ToLower(m.Title) == query {
+				results = append([]map[string]interface{}{result}, results...)
+			} else {
+				results = append(results, result)
+			}
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{"page": 1, "results": results, "total_results": len(results)})
+	})
+
+	mux.Get("/3/movie/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+		for _, m := range movies {
+			if m.ID != id {
+				continue
+			}
+
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"id":           m.ID,
+				"title":        m.Title,
+				"overview":     m.Overview,
+				"runtime":      m.Runtime,
+				"release_date": m.ReleaseDate,
+				"poster_path":  m.PosterPath,
+				"release_dates": map[string]interface{}{
+					"results": []map[string]interface{}{{
+						"iso_3166_1":    "US",
+						"release_dates": []map[string]interface{}{{"certification": m.Certification}},
+					}},
+				},
+			})
+			return
+		}
+
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{
+			"status_code":    34,
+			"status_message": "The resource you requested could not be found.",
+		})
+	})
+
+	return mux
+}
+
+// NewServer starts a fake on a local port. The base URL of its API, for metadata.NewTMDB,
+// is the URL of the server followed by "/3". Close it when done.
+func NewServer(movies []Movie) *httptest.Server {
+	return httptest.NewServer(Handler(movies))
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,71 @@
+// Package metadata looks movies up in an external movie database, to fill in what would
+// otherwise be typed by hand: the synopsis, the runtime, the release date, the rating and
+// the poster.
+package metadata
+
+import (
+	"backend/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotFound is returned when the database has no movie that matches
+var ErrNotFound = errors.New("no matching movie in the movie database")
+
+// MetadataProvider finds movies in an external movie database
+type MetadataProvider interface {
+	// FindMovie returns the movie with the given title that came out in year, zero for
+	// any year. When there are several the closest match is returned.
+	FindMovie(ctx context.Context, title string, year int) (*Movie, error)
+}
+
+// Movie is what a provider knows about a movie. Fields it has no value for are left
+// empty.
+type Movie struct {
+	ProviderID  string    `json:"provider_id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	RunTime     int       `json:"runtime"`
+	ReleaseDate time.Time `json:"release_date"`
+	MPAARating  string    `json:"mpaa_rating"`
+	Image       string    `json:"image"` // the poster, as a path of the provider like the ones we store
+}
+
+// Fields are the fields of a movie a provider can fill in, named like in the JSON of a
+// movie
+var Fields = []string{"description", "runtime", "release_date", "mpaa_rating", "image"}
+
+// Apply copies the given fields of found onto movie. A field found has no value for is
+// left as it is.
+func Apply(movie *models.Movie, found *Movie, fields []string) error {
+	for _, field := range fields {
+		switch field {
+		case "description":
+			if found.Description != "" {
+				movie.Description = found.Description
+			}
+		case "runtime":
+			if found.RunTime > 0 {
+				movie.RunTime = found.RunTime
+			}
+		case "release_date":
+			if !found.ReleaseDate.IsZero() {
+				movie.ReleaseDate = found.ReleaseDate
+			}
+		case "mpaa_rating":
+			if found.MPAARating != "" {
+				movie.MPAARating = found.MPAARating
+			}
+		case "image":
+			if found.Image != "" {
+				movie.Image = found.Image
+			}
+		default:
+			return fmt.Errorf("%q can't be filled in, use one of %v", field, Fields)
+		}
+	}
+
+	return nil
+}
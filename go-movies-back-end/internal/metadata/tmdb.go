@@ -0,0 +1,179 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTMDBURL is the address of the API of The Movie Database
+const DefaultTMDBURL = "https://api.themoviedb.org/3"
+
+// TMDB is the MetadataProvider of The Movie Database (themoviedb.org), or of any server
+// that answers the same way, like the fake of the faketmdb package.
+type TMDB struct {
+	BaseURL string   // e.g. DefaultTMDBURL
+	Token   string   // the API read access token, sent as a bearer token
+	Country string   // the country whose certification becomes the rating
+	Ratings []string // the ratings a certification can become, others are ignored
+	Client  *http.Client
+}
+
+// NewTMDB returns a client of the API at baseURL. Only the ratings listed are taken from
+// the certifications of the US releases.
+func NewTMDB(baseURL, token string, ratings []string) *TMDB {
+	return &TMDB{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Token:   token,
+		Country: "US",
+		Ratings: ratings,
+		Client:  &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+type tmdbSearch struct {
+	Results []struct {
+		ID          int    `json:"id"`
+		Title       string `json:"title"`
+		ReleaseDate string `json:"release_date"`
+	} `json:"results"`
+}
+
+type tmdbMovie struct {
+	ID           int    `json:"id"`
+	Title        string `json:"title"`
+	Overview     string `json:"overview"`
+	Runtime      int    `json:"runtime"`
+	ReleaseDate  string `json:"release_date"`
+	PosterPath   string `json:"poster_path"`
+	ReleaseDates struct {
+		Results []struct {
+			Country      string `json:"iso_3166_1"`
+			ReleaseDates []struct {
+				Certification string `json:"certification"`
+			} `json:"release_dates"`
+		} `json:"results"`
+	} `json:"release_dates"`
+}
+
+// FindMovie searches the movie by title, and year when there is one, then reads the
+// details of the best result. A search by year that finds nothing is tried again without
+// the year, the dates of the database and ours can be a year apart.
+func (t *TMDB) FindMovie(ctx context.Context, title string, year int) (*Movie, error) {
+	var search tmdbSearch
+
+	q := url.Values{"query": {title}}
+	if year > 0 {
+		q.Set("primary_release_year", strconv.Itoa(year))
+	}
+
+	err := t.get(ctx, "/search/movie", q, &search)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(search.Results) == 0 && year > 0 {
+		q.Del("primary_release_year")
+		err = t.get(ctx, "/search/movie", q, &search)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(search.Results) == 0 {
+		return nil, ErrNotFound
+	}
+
+	// the results come by popularity, an exact title wins over that
+	id := search.Results[0].ID
+	for _, result := range search.Results {
+		if strings.EqualFold(result.Title, title) {
+			id = result.ID
+			break
+		}
+	}
+
+	var details tmdbMovie
+	err = t.get(ctx, "/movie/"+strconv.Itoa(id), url.Values{"append_to_response": {"release_dates"}}, &details)
+	if err != nil {
+		return nil, err
+	}
+
+	movie := &Movie{
+		ProviderID:  "tmdb:" + strconv.Itoa(details.ID),
+		Title:       details.Title,
+		Description: strings.TrimSpace(details.Overview),
+		RunTime:     details.Runtime,
+		Image:       details.PosterPath,
+		MPAARating:  t.rating(&details),
+	}
+
+	if released, err := time.Parse("2006-01-02", details.ReleaseDate); err == nil {
+		movie.ReleaseDate = released
+	}
+
+	return movie, nil
+}
+
+// rating is the first certification of the releases in the country that is one of ours
+func (t *TMDB) rating(details *tmdbMovie) string {
+	for _, result := range details.ReleaseDates.Results {
+		if result.Country != t.Country {
+			continue
+		}
+
+		for _, release := range result.ReleaseDates {
+			for _, rating := range t.Ratings {
+				if release.Certification == rating {
+					return rating
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+func (t *TMDB) get(ctx context.Context, path string, q url.Values, into interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.BaseURL+path+"?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if t.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.Token)
+	}
+
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var problem struct {
+			Message string `json:"status_message"`
+		}
+		_ = json.Unmarshal(body, &problem)
+
+		return fmt.Errorf("movie database: %s %s", resp.Status, problem.Message)
+	}
+
+	return json.Unmarshal(body, into)
+}
@@ -0,0 +1,216 @@
+package metadata_test
+
+import (
+	"backend/internal/metadata"
+	"backend/internal/metadata/faketmdb"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var ratings = []string{"G", "PG", "PG-13", "R", "NC-17"}
+
+func TestFindMovie(t *testing.T) {
+	server := faketmdb.NewServer(faketmdb.DefaultMovies)
+	defer server.Close()
+
+	tmdb := metadata.NewTMDB(server.URL+"/3", "", ratings)
+
+	movie, err := tmdb.FindMovie(context.Background(), "highlander", 1986)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := metadata.Movie{
+		ProviderID:  "tmdb:8009",
+		Title:       "Highlander",
+		Description: faketmdb.DefaultMovies[0].Overview,
+		RunTime:     116,
+		ReleaseDate: time.Date(1986, time.March, 7, 0, 0, 0, 0, time.UTC),
+		MPAARating:  "R",
+		Image:       "/8Z8dptJEypuLoOQro1WugD855YE.jpg",
+	}
+	if *movie != want {
+		t.Errorf("got %+v, want %+v", *movie, want)
+	}
+}
+
+// popularFirst answers searches with the results of the fake in reverse, the way TMDB
+// would put a more popular movie before the one with the exact title
+func popularFirst(movies []faketmdb.Movie) http.Handler {
+	fake := faketmdb.Handler(movies)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/3/search/movie" {
+			fake.ServeHTTP(w, r)
+			return
+		}
+
+		rec := httptest.NewRecorder()
+		fake.ServeHTTP(rec, r)
+
+		var search map[string][]interface{}
+		_ = json.Unmarshal(rec.Body.Bytes(), &search)
+
+		results := search["results"]
+		for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+			results[i], results[j] = results[j], results[i]
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+	})
+}
+
+func TestFindMovieExactTitle(t *testing.T) {
+	server := httptest.NewServer(popularFirst(faketmdb.DefaultMovies))
+	defer server.Close()
+
+	tmdb := metadata.NewTMDB(server.URL+"/3", "", ratings)
+
+	// "The Godfather Part II" comes first, the exact title wins
+	movie, err := tmdb.FindMovie(context.Background(), "The Godfather", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if movie.ProviderID != "tmdb:238" {
+		t.Errorf("found %s %q, want tmdb:238", movie.ProviderID, movie.Title)
+	}
+
+	// without an exact title the first result is taken
+	movie, err = tmdb.FindMovie(context.Background(), "Godfather", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if movie.ProviderID != "tmdb:240" {
+		t.Errorf("found %s %q, want tmdb:240", movie.ProviderID, movie.Title)
+	}
+}
+
+func TestFindMovieWithoutYear(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		searches []string
+	)
+
+	fake := faketmdb.Handler(faketmdb.DefaultMovies)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/3/search/movie" {
+			mu.Lock()
+			searches = append(searches, r.URL.Query().Get("primary_release_year"))
+			mu.Unlock()
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	tmdb := metadata.NewTMDB(server.URL+"/3", "", ratings)
+
+	// our release date is a year off
+	movie, err := tmdb.FindMovie(context.Background(), "Raiders of the Lost Ark", 1982)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if movie.ProviderID != "tmdb:85" {
+		t.Errorf("found %s %q, want tmdb:85", movie.ProviderID, movie.Title)
+	}
+
+	if strings.Join(searches, ",") != "1982," {
+		t.Errorf("searched with the years %q, want 1982 then none", searches)
+	}
+}
+
+func TestFindMovieNotFound(t *testing.T) {
+	server := faketmdb.NewServer(faketmdb.DefaultMovies)
+	defer server.Close()
+
+	tmdb := metadata.NewTMDB(server.URL+"/3", "", ratings)
+
+	_, err := tmdb.FindMovie(context.Background(), "Alien", 1979)
+	if !errors.Is(err, metadata.ErrNotFound) {
+		t.Errorf("err = %v, want ErrNotFound", err)
+	}
+
+	// a search result whose details are gone is a 404 too
+	gone := faketmdb.Handler(nil)
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/3/search/movie" {
+			_, _ = w.Write([]byte(`{"results":[{"id":1,"title":"Alien"}]}`))
+			return
+		}
+		gone.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	tmdb = metadata.NewTMDB(server.URL+"/3", "", ratings)
+
+	_, err = tmdb.FindMovie(context.Background(), "Alien", 0)
+	if !errors.Is(err, metadata.ErrNotFound) {
+		t.Errorf("err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestFindMovieError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"status_code":7,"status_message":"Invalid API key: You must be granted a valid key."}`))
+	}))
+	defer server.Close()
+
+	tmdb := metadata.NewTMDB(server.URL+"/3/", "token", ratings)
+
+	_, err := tmdb.FindMovie(context.Background(), "Highlander", 1986)
+	if err == nil || errors.Is(err, metadata.ErrNotFound) || !strings.Contains(err.Error(), "Invalid API key") {
+		t.Errorf("err = %v, want the message of the server", err)
+	}
+}
+
+func TestFindMovieRating(t *testing.T) {
+	movies := []faketmdb.Movie{
+		{ID: 1, Title: "Rated", ReleaseDate: "2000-01-01", Certification: "PG-13"},
+		{ID: 2, Title: "Television", ReleaseDate: "2000-01-01", Certification: "TV-MA"},
+		{ID: 3, Title: "Unrated", ReleaseDate: "2000-01-01"},
+	}
+
+	server := faketmdb.NewServer(movies)
+	defer server.Close()
+
+	tmdb := metadata.NewTMDB(server.URL+"/3", "", ratings)
+
+	tests := []struct {
+		title  string
+		rating string
+	}{
+		{"Rated", "PG-13"},
+		// a certification that isn't one of ours is left out
+		{"Television", ""},
+		{"Unrated", ""},
+	}
+
+	for _, tt := range tests {
+		movie, err := tmdb.FindMovie(context.Background(), tt.title, 2000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if movie.MPAARating != tt.rating {
+			t.Errorf("%s: rating %q, want %q", tt.title, movie.MPAARating, tt.rating)
+		}
+	}
+
+	// only the certification of the US counts
+	tmdb.Country = "FR"
+	movie, err := tmdb.FindMovie(context.Background(), "Rated", 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if movie.MPAARating != "" {
+		t.Errorf("rating %q in France, want none", movie.MPAARating)
+	}
+}
@@ -0,0 +1,332 @@
+// Package migrations keeps the database schema in step with the code. The migrations are
+// numbered SQL files embedded in the binary, one set per database, and the versions that
+// have been applied are recorded in the schema_migrations table.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The databases we have migrations for
+const (
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+)
+
+//go:embed postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// a migration file is named like "0002_add_deleted_at.up.sql"
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// migrations are allowed more time than a single query, an index on a big table takes a while
+const migrateTimeout = time.Minute * 10
+
+// Migration is one step of the schema, with the SQL to apply it and to undo it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status tells whether a migration has been applied, and when.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies the migrations of one database.
+type Migrator struct {
+	DB         *sql.DB
+	dialect    string
+	migrations []Migration
+}
+
+// New loads the migrations for the given dialect (Postgres or SQLite).
+func New(db *sql.DB, dialect string) (*Migrator, error) {
+	if dialect != Postgres && dialect != SQLite {
+		return nil, fmt.Errorf("no migrations for database %q", dialect)
+	}
+
+	migrations, err := load(dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{DB: db, dialect: dialect, migrations: migrations}, nil
+}
+
+func load(dialect string) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+
+	for _, entry := range entries {
+		parts := fileName.FindStringSubmatch(entry.Name())
+		if parts == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(parts[1])
+
+		content, err := fs.ReadFile(files, path.Join(dialect, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = m
+		}
+
+		if m.Name != parts[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, m.Name, parts[2])
+		}
+
+		if parts[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	var migrations []Migration
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d needs both an up and a down file", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	// versions must be 1, 2, 3... so that "to N" always means the same schema
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+	}
+
+	return migrations, nil
+}
+
+// Latest is the version of the schema the code expects.
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Current is the version the database is at, zero for a database that has never been
+// migrated. It only reads, the database is left as it is.
+func (m *Migrator) Current() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	exists, err := m.tableExists(ctx)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var version int
+	err = m.DB.QueryRowContext(ctx, `select coalesce(max(version), 0) from schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// Status lists every migration and whether it has been applied. Like Current, it only
+// reads.
+func (m *Migrator) Status() ([]Status, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	exists, err := m.tableExists(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]time.Time)
+
+	if exists {
+		rows, err := m.DB.QueryContext(ctx, `select version, applied_at from schema_migrations`)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var version int
+			var at time.Time
+			err := rows.Scan(&version, &at)
+			if err != nil {
+				return nil, err
+			}
+			applied[version] = at
+		}
+
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var statuses []Status
+	for _, migration := range m.migrations {
+		at, ok := applied[migration.Version]
+		statuses = append(statuses, Status{Migration: migration, Applied: ok, AppliedAt: at})
+	}
+
+	return statuses, nil
+}
+
+// Up applies every migration that hasn't been applied yet.
+func (m *Migrator) Up() error {
+	return m.To(m.Latest())
+}
+
+// Down undoes the last applied migration.
+func (m *Migrator) Down() error {
+	current, err := m.Current()
+	if err != nil {
+		return err
+	}
+
+	if current == 0 {
+		return errors.New("there is no migration to undo")
+	}
+
+	return m.To(current - 1)
+}
+
+// To migrates up or down until the database is at the given version.
+func (m *Migrator) To(version int) error {
+	if version < 0 || version > m.Latest() {
+		return fmt.Errorf("version must be between 0 and %d", m.Latest())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	err := m.ensureTable(ctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	current, err := m.Current()
+	if err != nil {
+		return err
+	}
+
+	for current < version {
+		err := m.apply(m.migrations[current], true)
+		if err != nil {
+			return err
+		}
+		current++
+	}
+
+	for current > version {
+		err := m.apply(m.migrations[current-1], false)
+		if err != nil {
+			return err
+		}
+		current--
+	}
+
+	return nil
+}
+
+// apply runs one migration and records it in the same transaction, so a migration that
+// fails half way leaves neither a half changed schema nor a wrong version behind.
+func (m *Migrator) apply(migration Migration, up bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	script := migration.Down
+	if up {
+		script = migration.Up
+	}
+
+	_, err = tx.ExecContext(ctx, script)
+	if err != nil {
+		return fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Name, err)
+	}
+
+	if up {
+		stmt := `insert into schema_migrations (version, name, applied_at) values (?, ?, ?)`
+		_, err = tx.ExecContext(ctx, m.rebind(stmt), migration.Version, migration.Name, time.Now().UTC())
+	} else {
+		stmt := `delete from schema_migrations where version = ?`
+		_, err = tx.ExecContext(ctx, m.rebind(stmt), migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// tableExists tells whether schema_migrations is there, only To creates it
+func (m *Migrator) tableExists(ctx context.Context) (bool, error) {
+	query := `select count(*) from sqlite_master where type = 'table' and name = 'schema_migrations'`
+	if m.dialect == Postgres {
+		query = `select count(*) from pg_tables where schemaname = current_schema() and tablename = 'schema_migrations'`
+	}
+
+	var n int
+	err := m.DB.QueryRowContext(ctx, query).Scan(&n)
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	_, err := m.DB.ExecContext(ctx, `
+		create table if not exists schema_migrations (
+			version integer primary key,
+			name varchar(255) not null,
+			applied_at timestamp not null
+		)
+	`)
+	return err
+}
+
+// rebind turns the ? placeholders of a statement into $1, $2... for postgres
+func (m *Migrator) rebind(stmt string) string {
+	if m.dialect != Postgres {
+		return stmt
+	}
+
+	n := 0
+	return placeholder.ReplaceAllStringFunc(stmt, func(string) string {
+		n++
+		return fmt.Sprintf("$%d", n)
+	})
+}
+
+var placeholder = regexp.MustCompile(`\?`)
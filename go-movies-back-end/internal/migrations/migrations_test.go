@@ -0,0 +1,174 @@
+package migrations
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+// newMigrator returns a migrator on an empty SQLite database
+func newMigrator(t *testing.T) *Migrator {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "movies.db")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	m, err := New(db, SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func hasTable(t *testing.T, m *Migrator, name string) bool {
+	t.Helper()
+
+	var n int
+	err := m.DB.QueryRow(`select count(*) from sqlite_master where type = 'table' and name = ?`, name).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return n > 0
+}
+
+func TestLoad(t *testing.T) {
+	for _, dialect := range []string{Postgres, SQLite} {
+		t.Run(dialect, func(t *testing.T) {
+			migrations, err := load(dialect)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, migration := range migrations {
+				if migration.Version != i+1 || migration.Up == "" || migration.Down == "" {
+					t.Errorf("migration %d = %+v", i+1, migration)
+				}
+			}
+		})
+	}
+
+	// both databases have to be at the same version
+	pg, _ := load(Postgres)
+	lite, _ := load(SQLite)
+	if len(pg) != len(lite) {
+		t.Errorf("%d postgres migrations, %d sqlite ones", len(pg), len(lite))
+	}
+
+	_, err := New(nil, "mysql")
+	if err == nil {
+		t.Error("no error for a database without migrations")
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	m := newMigrator(t)
+
+	current, err := m.Current()
+	if err != nil || current != 0 {
+		t.Fatalf("Current() = %d, %v, want 0", current, err)
+	}
+
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != m.Latest() {
+		t.Fatalf("%d statuses, want %d", len(statuses), m.Latest())
+	}
+	for _, s := range statuses {
+		if s.Applied {
+			t.Errorf("migration %d applied on an empty database", s.Version)
+		}
+	}
+
+	if hasTable(t, m, "schema_migrations") {
+		t.Error("reading the version created schema_migrations")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	m := newMigrator(t)
+	latest := m.Latest()
+
+	tests := []struct {
+		name    string
+		migrate func() error
+		want    int
+		fails   bool
+	}{
+		{"up", m.Up, latest, false},
+		{"up again", m.Up, latest, false},
+		{"down", m.Down, latest - 1, false},
+		{"to 1", func() error { return m.To(1) }, 1, false},
+		{"to 2", func() error { return m.To(2) }, 2, false},
+		{"to 0", func() error { return m.To(0) }, 0, false},
+		{"down from 0", m.Down, 0, true},
+		{"to a version that doesn't exist", func() error { return m.To(latest + 1) }, 0, true},
+		{"to a negative version", func() error { return m.To(-1) }, 0, true},
+		{"up from 0", m.Up, latest, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.migrate()
+			if (err != nil) != tt.fails {
+				t.Fatalf("err = %v, want failure %v", err, tt.fails)
+			}
+
+			current, err := m.Current()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if current != tt.want {
+				t.Errorf("at version %d, want %d", current, tt.want)
+			}
+
+			statuses, err := m.Status()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range statuses {
+				if s.Applied != (s.Version <= tt.want) {
+					t.Errorf("migration %d applied = %v at version %d", s.Version, s.Applied, tt.want)
+				}
+			}
+
+			if hasTable(t, m, "movies") != (tt.want > 0) {
+				t.Errorf("movies table doesn't match version %d", tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyRollsBack(t *testing.T) {
+	m := newMigrator(t)
+
+	err := m.To(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a migration that fails half way leaves neither its tables nor its version behind
+	m.migrations[1].Up = `create table half_done (id integer); select * from no_such_table;`
+
+	err = m.To(2)
+	if err == nil {
+		t.Fatal("no error from a broken migration")
+	}
+
+	current, err := m.Current()
+	if err != nil || current != 1 {
+		t.Errorf("Current() = %d, %v, want 1", current, err)
+	}
+
+	if hasTable(t, m, "half_done") {
+		t.Error("the broken migration left its table behind")
+	}
+}
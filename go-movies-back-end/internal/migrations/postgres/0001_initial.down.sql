@@ -0,0 +1,4 @@
+drop table if exists movies_genres;
+drop table if exists movies;
+drop table if exists genres;
+drop table if exists users;
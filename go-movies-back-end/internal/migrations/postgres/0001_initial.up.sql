@@ -0,0 +1,62 @@
+-- Initial schema, the same tables and indexes sql/create_tables.sql creates. Everything
+-- is "if not exists", so that a database that was set up by create_tables.sql can be
+-- brought under migrations by simply running "migrate up" once.
+--
+-- Only the genres are seeded here, they are reference data the front end relies on. The
+-- sample movies and the admin user stay in create_tables.sql for local development.
+
+create extension if not exists pg_trgm;
+
+create table if not exists genres (
+    id integer generated always as identity primary key,
+    genre character varying(255),
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone
+);
+
+create table if not exists movies (
+    id integer generated always as identity primary key,
+    title character varying(512),
+    release_date date,
+    runtime integer,
+    mpaa_rating character varying(10),
+    description text,
+    image character varying(255),
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone
+);
+
+create table if not exists movies_genres (
+    id integer generated always as identity primary key,
+    movie_id integer references movies (id) on update cascade on delete cascade,
+    genre_id integer references genres (id) on update cascade on delete cascade
+);
+
+create table if not exists users (
+    id integer generated always as identity primary key,
+    first_name character varying(255),
+    last_name character varying(255),
+    email character varying(255),
+    password character varying(255),
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone
+);
+
+create index if not exists movies_title_id_idx on movies using btree (title, id);
+create index if not exists movies_release_date_id_idx on movies using btree (release_date, id);
+create index if not exists movies_runtime_id_idx on movies using btree (runtime, id);
+create index if not exists movies_search_english_idx on movies using gin ((setweight(to_tsvector('english', coalesce(title, '')), 'A') || setweight(to_tsvector('english', coalesce(description, '')), 'B')));
+create index if not exists movies_title_trgm_idx on movies using gin (title gin_trgm_ops);
+
+insert into genres (genre, created_at, updated_at)
+select
+    genre, '2022-09-23', '2022-09-23'
+from
+    unnest(array[
+        'Comedy', 'Sci-Fi', 'Horror', 'Romance', 'Action', 'Thriller', 'Drama',
+        'Mystery', 'Crime', 'Animation', 'Adventure', 'Fantasy', 'Superhero'
+    ]) with ordinality as g (genre, n)
+where
+    not exists (select 1 from genres)
+order by
+    n;
@@ -0,0 +1,8 @@
+-- Deleting a movie moves it to the trash: the row stays and deleted_at tells when it was
+-- deleted. Every read path filters on "deleted_at is null".
+
+alter table movies add column deleted_at timestamp without time zone;
+
+-- the trash is listed newest first and is tiny next to the catalogue, so the index only
+-- covers deleted movies
+create index movies_deleted_at_idx on movies using btree (deleted_at desc) where deleted_at is not null;
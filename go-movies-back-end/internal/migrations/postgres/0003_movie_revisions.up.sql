@@ -0,0 +1,14 @@
+-- Every admin change to a movie stores a snapshot of the movie, with its genres, as the
+-- next revision of that movie. Revisions are numbered from 1 for each movie.
+
+create table movie_revisions (
+    id integer generated always as identity primary key,
+    movie_id integer not null references movies (id) on update cascade on delete cascade,
+    revision integer not null,
+    action character varying(20) not null,
+    user_id integer references users (id) on delete set null,
+    changed_fields jsonb not null,
+    snapshot jsonb not null,
+    created_at timestamp without time zone not null,
+    unique (movie_id, revision)
+);
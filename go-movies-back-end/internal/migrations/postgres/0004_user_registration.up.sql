@@ -0,0 +1,11 @@
+-- Anyone can register now, so an email can only belong to one user. Emails are compared
+-- ignoring case, for the index and for logging in.
+
+create unique index users_email_idx on users using btree (lower(email));
+
+-- Being a user no longer means being an admin, only admins can use the admin routes. The
+-- admin of the seed stays one, an operator makes other users admins.
+
+alter table users add column is_admin boolean not null default false;
+
+update users set is_admin = true where id = 1 and lower(email) = 'admin@example.com';
@@ -0,0 +1,2 @@
+drop table if exists password_resets;
+alter table users drop column tokens_revoked_at;
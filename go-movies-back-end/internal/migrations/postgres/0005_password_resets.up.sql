@@ -0,0 +1,16 @@
+-- Users who forgot their password get a reset token by mail. Only a sha256 of the token
+-- is stored, a token can be used once and expires. A reset sets tokens_revoked_at, access
+-- and refresh tokens issued before it are refused.
+
+alter table users add column tokens_revoked_at timestamp without time zone;
+
+create table password_resets (
+    id integer generated always as identity primary key,
+    user_id integer not null references users (id) on delete cascade,
+    token_hash character(64) not null unique,
+    expires_at timestamp without time zone not null,
+    used_at timestamp without time zone,
+    created_at timestamp without time zone not null
+);
+
+create index password_resets_user_id_idx on password_resets using btree (user_id);
@@ -0,0 +1,17 @@
+-- Refresh tokens are kept on the server, so that they can be revoked. Each refresh uses
+-- up the token and hands out the next one of its family; a used token that comes back
+-- means it was stolen, and the whole family is revoked.
+
+create table refresh_tokens (
+    id character varying(64) primary key,
+    family_id character varying(64) not null,
+    user_id integer not null references users (id) on delete cascade,
+    expires_at timestamp without time zone not null,
+    used_at timestamp without time zone,
+    revoked_at timestamp without time zone,
+    created_at timestamp without time zone not null
+);
+
+create index refresh_tokens_family_id_idx on refresh_tokens using btree (family_id);
+create index refresh_tokens_user_id_idx on refresh_tokens using btree (user_id);
+create index refresh_tokens_expires_at_idx on refresh_tokens using btree (expires_at);
@@ -0,0 +1,72 @@
+-- Initial schema of the SQLite database. It mirrors sql/create_tables.sql, including
+-- the sample data, because a SQLite install has no other way to get its first admin user.
+
+create table if not exists genres (
+    id integer primary key autoincrement,
+    genre varchar(255),
+    created_at timestamp,
+    updated_at timestamp
+);
+
+create table if not exists movies (
+    id integer primary key autoincrement,
+    title varchar(512),
+    release_date date,
+    runtime integer,
+    mpaa_rating varchar(10),
+    description text,
+    image varchar(255),
+    created_at timestamp,
+    updated_at timestamp
+);
+
+create table if not exists movies_genres (
+    id integer primary key autoincrement,
+    movie_id integer references movies (id) on update cascade on delete cascade,
+    genre_id integer references genres (id) on update cascade on delete cascade
+);
+
+create table if not exists users (
+    id integer primary key autoincrement,
+    first_name varchar(255),
+    last_name varchar(255),
+    email varchar(255),
+    password varchar(255),
+    created_at timestamp,
+    updated_at timestamp
+);
+
+create index if not exists movies_title_id_idx on movies (title, id);
+create index if not exists movies_release_date_id_idx on movies (release_date, id);
+create index if not exists movies_runtime_id_idx on movies (runtime, id);
+
+insert or ignore into genres (id, genre, created_at, updated_at) values
+    (1, 'Comedy', '2022-09-23 00:00:00', '2022-09-23 00:00:00'),
+    (2, 'Sci-Fi', '2022-09-23 00:00:00', '2022-09-23 00:00:00'),
+    (3, 'Horror', '2022-09-23 00:00:00', '2022-09-23 00:00:00'),
+    (4, 'Romance', '2022-09-23 00:00:00', '2022-09-23 00:00:00'),
+    (5, 'Action', '2022-09-23 00:00:00', '2022-09-23 00:00:00'),
+    (6, 'Thriller', '2022-09-23 00:00:00', '2022-09-23 00:00:00'),
+    (7, 'Drama', '2022-09-23 00:00:00', '2022-09-23 00:00:00'),
+    (8, 'Mystery', '2022-09-23 00:00:00', '2022-09-23 00:00:00'),
+    (9, 'Crime', '2022-09-23 00:00:00', '2022-09-23 00:00:00'),
+    (10, 'Animation', '2022-09-23 00:00:00', '2022-09-23 00:00:00'),
+    (11, 'Adventure', '2022-09-23 00:00:00', '2022-09-23 00:00:00'),
+    (12, 'Fantasy', '2022-09-23 00:00:00', '2022-09-23 00:00:00'),
+    (13, 'Superhero', '2022-09-23 00:00:00', '2022-09-23 00:00:00');
+
+insert or ignore into movies (id, title, release_date, runtime, mpaa_rating, description, image, created_at, updated_at) values
+    (1, 'Highlander', '1986-03-07', 116, 'R', 'He fought his first battle on the Scottish Highlands in 1536. He will fight his greatest battle on the streets of New York City in 1986. His name is Connor MacLeod. He is immortal.', '/8Z8dptJEypuLoOQro1WugD855YE.jpg', '2022-09-23 00:00:00', '2022-09-23 00:00:00'),
+    (2, 'Raiders of the Lost Ark', '1981-06-12', 115, 'PG-13', 'Archaeology professor Indiana Jones ventures to seize a biblical artefact known as the Ark of the Covenant. While doing so, he puts up a fight against Renee and a troop of Nazis.', '/ceG9VzoRAVGwivFU403Wc3AHRys.jpg', '2022-09-23 00:00:00', '2022-09-23 00:00:00'),
+    (3, 'The Godfather', '1972-03-24', 175, '18A', 'The aging patriarch of an organized crime dynasty in postwar New York City transfers control of his clandestine empire to his reluctant youngest son.', '/3bhkrj58Vtu7enYsRolD1fZdja1.jpg', '2022-09-23 00:00:00', '2022-09-23 00:00:00');
+
+insert or ignore into movies_genres (id, movie_id, genre_id) values
+    (1, 1, 5),
+    (2, 1, 12),
+    (3, 2, 5),
+    (4, 2, 11),
+    (5, 3, 9),
+    (6, 3, 7);
+
+insert or ignore into users (id, first_name, last_name, email, password, created_at, updated_at) values
+    (1, 'Admin', 'User', 'admin@example.com', '$2a$14$wVsaPvJnJJsomWArouWCtusem6S/.Gauq/GjOIEHpyh2DAMmso1wy', '2022-09-23 00:00:00', '2022-09-23 00:00:00');
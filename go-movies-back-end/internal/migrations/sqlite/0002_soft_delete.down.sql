@@ -0,0 +1,6 @@
+-- Going back to hard deletes: whatever is in the trash is removed for good.
+
+delete from movies where deleted_at is not null;
+
+drop index if exists movies_deleted_at_idx;
+alter table movies drop column deleted_at;
@@ -0,0 +1,5 @@
+-- Deleting a movie moves it to the trash, see the postgres migration.
+
+alter table movies add column deleted_at timestamp;
+
+create index movies_deleted_at_idx on movies (deleted_at desc) where deleted_at is not null;
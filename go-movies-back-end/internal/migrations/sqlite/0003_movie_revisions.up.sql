@@ -0,0 +1,14 @@
+-- Snapshots of every admin change to a movie, see the postgres migration. The json
+-- columns are plain text here.
+
+create table movie_revisions (
+    id integer primary key autoincrement,
+    movie_id integer not null references movies (id) on update cascade on delete cascade,
+    revision integer not null,
+    action varchar(20) not null,
+    user_id integer references users (id) on delete set null,
+    changed_fields text not null,
+    snapshot text not null,
+    created_at timestamp not null,
+    unique (movie_id, revision)
+);
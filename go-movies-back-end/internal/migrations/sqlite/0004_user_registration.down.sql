@@ -0,0 +1,3 @@
+alter table users drop column is_admin;
+
+drop index if exists users_email_idx;
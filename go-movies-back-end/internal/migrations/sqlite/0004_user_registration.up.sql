@@ -0,0 +1,8 @@
+-- An email can only belong to one user, and only admins can use the admin routes, see the
+-- postgres migration.
+
+create unique index users_email_idx on users (lower(email));
+
+alter table users add column is_admin boolean not null default 0;
+
+update users set is_admin = 1 where id = 1 and lower(email) = 'admin@example.com';
@@ -0,0 +1,14 @@
+-- Password reset tokens and the revocation of tokens, see the postgres migration.
+
+alter table users add column tokens_revoked_at timestamp;
+
+create table password_resets (
+    id integer primary key autoincrement,
+    user_id integer not null references users (id) on delete cascade,
+    token_hash char(64) not null unique,
+    expires_at timestamp not null,
+    used_at timestamp,
+    created_at timestamp not null
+);
+
+create index password_resets_user_id_idx on password_resets (user_id);
@@ -0,0 +1,5 @@
+-- Users have to confirm their email, see the postgres migration.
+
+alter table users add column email_verified boolean not null default 0;
+
+update users set email_verified = 1;
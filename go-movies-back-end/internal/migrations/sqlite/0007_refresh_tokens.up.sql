@@ -0,0 +1,15 @@
+-- Refresh tokens kept on the server, see the postgres migration.
+
+create table refresh_tokens (
+    id varchar(64) primary key,
+    family_id varchar(64) not null,
+    user_id integer not null references users (id) on delete cascade,
+    expires_at timestamp not null,
+    used_at timestamp,
+    revoked_at timestamp,
+    created_at timestamp not null
+);
+
+create index refresh_tokens_family_id_idx on refresh_tokens (family_id);
+create index refresh_tokens_user_id_idx on refresh_tokens (user_id);
+create index refresh_tokens_expires_at_idx on refresh_tokens (expires_at);
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+type Genre struct {
+	ID        int       `json:"id"`
+	Genre     string    `json:"genre"`
+	Checked   bool      `json:"checked"` // used by the front end to tick the genres a movie belongs to
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+}
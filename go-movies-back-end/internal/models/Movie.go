@@ -3,13 +3,16 @@ package models
 import "time"
 
 type Movie struct {
-	ID          int       `json:"id"`
-	Title       string    `json:"title"`
-	ReleaseDate time.Time `json:"release_data"`
-	RunTime     int       `json:"runtime"`
-	MPAARating  string    `json:"mpaa_rating"`
-	Description string    `json:"description"`
-	Image       string    `json:"image"`
-	CreatedAt   time.Time `json:"-"`
-	UpdatedAt   time.Time `json:"-"`
+	ID          int        `json:"id"`
+	Title       string     `json:"title"`
+	ReleaseDate time.Time  `json:"release_date"`
+	RunTime     int        `json:"runtime"`
+	MPAARating  string     `json:"mpaa_rating"`
+	Description string     `json:"description"`
+	Image       string     `json:"image"`
+	Genres      []*Genre   `json:"genres,omitempty"`
+	GenresArray []int      `json:"genres_array,omitempty"` // genre ids sent by the front end when saving a movie
+	CreatedAt   time.Time  `json:"-"`
+	UpdatedAt   time.Time  `json:"-"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"` // set while the movie is in the trash
 }
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// MovieRevision is a snapshot of a movie taken every time an admin changes it
+type MovieRevision struct {
+	ID            int       `json:"id"`
+	MovieID       int       `json:"movie_id"`
+	Revision      int       `json:"revision"`          // 1 for the first revision of a movie, then 2, 3...
+	Action        string    `json:"action"`            // what was done: create, update, delete, restore, rollback or import
+	UserID        int       `json:"user_id,omitempty"` // zero when no logged in user made the change, e.g. the import command
+	UserEmail     string    `json:"user_email,omitempty"`
+	ChangedFields []string  `json:"changed_fields"` // the fields that differ from the previous revision
+	Snapshot      Movie     `json:"snapshot"`
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// PasswordReset is a request to reset the password of a user. Only the hash of the token
+// mailed to the user is kept, the token itself can't be read back from the database.
+type PasswordReset struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	TokenHash string     `json:"-"` // hex sha256 of the token
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"` // set once the token has been used, or made useless by another one
+	CreatedAt time.Time  `json:"created_at"`
+}
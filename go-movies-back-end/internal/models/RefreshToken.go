@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// RefreshToken is a refresh token handed out to a user. Every refresh replaces the token
+// with a new one of the same family, the chain of tokens of one login. Only the id of the
+// token, its jti claim, is kept.
+type RefreshToken struct {
+	ID        string     `json:"id"`
+	FamilyID  string     `json:"family_id"`
+	UserID    int        `json:"user_id"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`    // set once the token has been exchanged for the next one
+	RevokedAt *time.Time `json:"revoked_at,omitempty"` // set on logout, on reuse of a used token of the family or on a password reset
+	CreatedAt time.Time  `json:"created_at"`
+}
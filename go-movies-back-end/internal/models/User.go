@@ -15,6 +15,18 @@ type User struct {
 	Password  string    `json:"password"`
 	CreatedAt time.Time `json:"-"` // time.Time for timestamp fields
 	UpdatedAt time.Time `json:"-"` // time.Time for timestamp fields
+
+	// EmailVerified tells whether the user opened the link mailed to them when they
+	// registered. Only verified users can use the admin routes.
+	EmailVerified bool `json:"email_verified"`
+
+	// IsAdmin tells whether the user can manage the catalogue. Registering makes users who
+	// aren't, an operator makes one an admin in the database.
+	IsAdmin bool `json:"is_admin"`
+
+	// TokensRevokedAt is when all the tokens of the user were made invalid, e.g. by a
+	// password reset. Access and refresh tokens issued before are refused.
+	TokensRevokedAt *time.Time `json:"-"`
 }
 
 // this function takes a plain text password and match it with the hash of a password stored in the database
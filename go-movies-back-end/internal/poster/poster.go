@@ -0,0 +1,199 @@
+// Package poster checks the poster images admins upload and removes what they carry
+// besides the picture: EXIF data with the camera, the place and the date a photo was
+// taken, XMP, comments and the like.
+package poster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/webp"
+)
+
+// MaxPixels is the largest image accepted, checked before the image is decoded so that a
+// small file can't claim a huge picture and take all the memory
+const MaxPixels = 40_000_000
+
+// ErrFormat is returned for a file that is not a JPEG, PNG or WebP image
+var ErrFormat = errors.New("the image must be a JPEG, PNG or WebP file")
+
+// Image is an uploaded image, cleaned
+type Image struct {
+	Data        []byte
+	ContentType string
+	Ext         string // extension of the format, with the dot
+	Width       int
+	Height      int
+}
+
+type format struct {
+	contentType  string
+	ext          string
+	decode       func(r *bytes.Reader) (image.Image, error)
+	decodeConfig func(r *bytes.Reader) (image.Config, error)
+}
+
+var formats = map[string]format{
+	"jpeg": {
+		contentType:  "image/jpeg",
+		ext:          ".jpg",
+		decode:       func(r *bytes.Reader) (image.Image, error) { return jpeg.Decode(r) },
+		decodeConfig: func(r *bytes.Reader) (image.Config, error) { return jpeg.DecodeConfig(r) },
+	},
+	"png": {
+		contentType:  "image/png",
+		ext:          ".png",
+		decode:       func(r *bytes.Reader) (image.Image, error) { return png.Decode(r) },
+		decodeConfig: func(r *bytes.Reader) (image.Config, error) { return png.DecodeConfig(r) },
+	},
+	"webp": {
+		contentType:  "image/webp",
+		ext:          ".webp",
+		decode:       func(r *bytes.Reader) (image.Image, error) { return webp.Decode(r) },
+		decodeConfig: func(r *bytes.Reader) (image.Config, error) { return webp.DecodeConfig(r) },
+	},
+}
+
+// sniff tells the format of an image from its first bytes, whatever its name or the
+// content type it was sent with
+func sniff(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return "jpeg"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return "webp"
+	}
+	return ""
+}
+
+// Decode decodes a JPEG, PNG or WebP image, refusing the ones with more than MaxPixels
+func Decode(data []byte) (image.Image, error) {
+	f, ok := formats[sniff(data)]
+	if !ok {
+		return nil, ErrFormat
+	}
+
+	config, err := f.decodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > MaxPixels {
+		return nil, fmt.Errorf("the image is %dx%d, it may have up to %d pixels", config.Width, config.Height, MaxPixels)
+	}
+
+	img, err := f.decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+
+	return img, nil
+}
+
+// Clean checks that data is a whole JPEG, PNG or WebP image and returns it without its
+// metadata. JPEG and PNG images are decoded and encoded again, which keeps nothing but the
+// pixels; JPEGs are written at quality 90. The orientation of a JPEG is part of its EXIF
+// data and is lost too, posters are expected to be upright. WebP images, which can't be
+// encoded here, are decoded to check them and then copied without their EXIF and XMP
+// chunks.
+func Clean(data []byte) (*Image, error) {
+	name := sniff(data)
+	f, ok := formats[name]
+	if !ok {
+		return nil, ErrFormat
+	}
+
+	img, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+
+	switch name {
+	case "jpeg":
+		var buf bytes.Buffer
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+		out = buf.Bytes()
+	case "png":
+		var buf bytes.Buffer
+		err = png.Encode(&buf, img)
+		out = buf.Bytes()
+	case "webp":
+		out, err = stripWebP(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Image{
+		Data:        out,
+		ContentType: f.contentType,
+		Ext:         f.ext,
+		Width:       img.Bounds().Dx(),
+		Height:      img.Bounds().Dy(),
+	}, nil
+}
+
+// The flags of the VP8X chunk of a WebP file telling that it has metadata chunks
+const (
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+// stripWebP copies a WebP file without its EXIF and XMP chunks. A WebP file is a RIFF
+// container: "RIFF", the size of the rest, "WEBP", then chunks made of a four letter
+// name, the size of the data and the data, padded to an even length.
+func stripWebP(data []byte) ([]byte, error) {
+	// whatever comes after the RIFF container is not part of the image and is dropped
+	end := 8 + int(binary.LittleEndian.Uint32(data[4:8]))
+	if end < 12 || end > len(data) {
+		return nil, fmt.Errorf("%w: truncated WebP file", ErrFormat)
+	}
+
+	out := make([]byte, 12, end)
+	copy(out, data[:12])
+
+	rest := data[12:end]
+	for len(rest) > 0 {
+		if len(rest) < 8 {
+			return nil, fmt.Errorf("%w: truncated WebP chunk", ErrFormat)
+		}
+
+		name := string(rest[:4])
+		size := int(binary.LittleEndian.Uint32(rest[4:8]))
+		padded := size + size&1
+
+		if padded < 0 || 8+padded > len(rest) {
+			return nil, fmt.Errorf("%w: truncated WebP chunk %q", ErrFormat, name)
+		}
+
+		chunk := rest[:8+padded]
+		rest = rest[8+padded:]
+
+		switch name {
+		case "EXIF", "XMP ":
+			continue
+		case "VP8X":
+			if size < 1 {
+				return nil, fmt.Errorf("%w: bad VP8X chunk", ErrFormat)
+			}
+			start := len(out)
+			out = append(out, chunk...)
+			out[start+8] &^= webpFlagEXIF | webpFlagXMP
+		default:
+			out = append(out, chunk...)
+		}
+	}
+
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+
+	return out, nil
+}
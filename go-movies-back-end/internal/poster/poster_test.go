@@ -0,0 +1,199 @@
+package poster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/HugoSmits86/nativewebp"
+)
+
+// secret is the metadata the test images carry, none of it may come out of Clean
+const secret = "GPS 48.8583N 2.2945E"
+
+func testImage(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 8), G: uint8(y * 8), B: 100, A: 255})
+		}
+	}
+	return img
+}
+
+// jpegWithEXIF is a JPEG with an APP1 segment of EXIF data right after its start marker
+func jpegWithEXIF(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, testImage(20, 30), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	payload := append([]byte("Exif\x00\x00"), secret...)
+	segment := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+// pngChunk is a PNG chunk: the length, the type, the data and the CRC of the last two
+func pngChunk(name string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, name...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// pngWith is a PNG of the given size with a text chunk after its header. The size is
+// written in the header only, the pixels are those of a small image.
+func pngWith(t *testing.T, w, h int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, testImage(20, 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// the signature is 8 bytes, then comes IHDR: 4 + 4 + 13 + 4 bytes
+	header := make([]byte, 13)
+	copy(header, data[16:29])
+	binary.BigEndian.PutUint32(header[0:], uint32(w))
+	binary.BigEndian.PutUint32(header[4:], uint32(h))
+
+	out := append([]byte{}, data[:8]...)
+	out = append(out, pngChunk("IHDR", header)...)
+	out = append(out, pngChunk("tEXt", []byte("Comment\x00"+secret))...)
+	return append(out, data[33:]...)
+}
+
+// webpChunk is a RIFF chunk: the name, the size of the data and the data, padded
+func webpChunk(name string, data []byte) []byte {
+	chunk := append([]byte(name), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// webpWithEXIF is an extended WebP file with EXIF and XMP chunks around the picture
+func webpWithEXIF(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	err := nativewebp.Encode(&buf, testImage(20, 30), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	picture := buf.Bytes()[12:]
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = webpFlagEXIF | webpFlagXMP
+	vp8x[4] = 20 - 1
+	vp8x[7] = 30 - 1
+
+	body := []byte("WEBP")
+	body = append(body, webpChunk("VP8X", vp8x)...)
+	body = append(body, picture...)
+	body = append(body, webpChunk("EXIF", []byte("Exif\x00\x00"+secret))...)
+	body = append(body, webpChunk("XMP ", []byte("<x:xmpmeta>"+secret+"</x:xmpmeta>"))...)
+
+	return append(webpChunk("RIFF", body)[:8], body...)
+}
+
+func TestClean(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+	}{
+		{"jpeg", jpegWithEXIF(t), "image/jpeg"},
+		{"png", pngWith(t, 20, 30), "image/png"},
+		{"webp", webpWithEXIF(t), "image/webp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !bytes.Contains(tt.data, []byte(secret)) {
+				t.Fatal("the test image has no metadata")
+			}
+
+			img, err := Clean(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if bytes.Contains(img.Data, []byte(secret)) {
+				t.Error("the metadata is still there")
+			}
+			if img.ContentType != tt.contentType || img.Width != 20 || img.Height != 30 {
+				t.Errorf("image = %s %dx%d", img.ContentType, img.Width, img.Height)
+			}
+
+			// what comes out is an image still
+			_, err = Decode(img.Data)
+			if err != nil {
+				t.Errorf("decoding the cleaned image: %v", err)
+			}
+		})
+	}
+}
+
+func TestCleanWebPFlags(t *testing.T) {
+	img, err := Clean(webpWithEXIF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the chunks after the RIFF header start with VP8X, whose flags are the first byte
+	if string(img.Data[12:16]) != "VP8X" || img.Data[20]&(webpFlagEXIF|webpFlagXMP) != 0 {
+		t.Errorf("the VP8X chunk still announces metadata: % x", img.Data[12:21])
+	}
+	if int(binary.LittleEndian.Uint32(img.Data[4:8])) != len(img.Data)-8 {
+		t.Error("the RIFF size doesn't match the file")
+	}
+}
+
+func TestCleanRejects(t *testing.T) {
+	jpg := jpegWithEXIF(t)
+
+	tests := []struct {
+		name   string
+		data   []byte
+		format bool // whether the error is ErrFormat
+	}{
+		{"empty", nil, true},
+		{"text", []byte("not an image at all"), true},
+		{"gif", []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00"), true},
+		{"truncated jpeg", jpg[:len(jpg)/2], true},
+		{"truncated webp", webpWithEXIF(t)[:30], true},
+		{"too many pixels", pngWith(t, 10000, 5000), false},
+		{"no pixels", pngWith(t, 0, 30), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Clean(tt.data)
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if errors.Is(err, ErrFormat) != tt.format {
+				t.Errorf("err = %v, want ErrFormat %v", err, tt.format)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package poster
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+)
+
+// How a variant fits the size it is asked for
+const (
+	FitCover   = "cover"   // fills the size, cropping what sticks out around the center
+	FitContain = "contain" // fits in the size, keeping the whole picture
+)
+
+// The formats a variant can be encoded in
+const (
+	FormatJPEG = "jpeg"
+	FormatWebP = "webp" // lossless, the only kind there is a pure Go encoder for
+)
+
+// variantQuality is the JPEG quality of the variants, lower than the one of Clean: they
+// are thumbnails, made to be small
+const variantQuality = 82
+
+// Size is a width and a height in pixels
+type Size struct {
+	Width  int
+	Height int
+}
+
+func (s Size) String() string {
+	return fmt.Sprintf("%dx%d", s.Width, s.Height)
+}
+
+// ParseSize reads a size written like 300x450
+func ParseSize(s string) (Size, error) {
+	w, h, ok := strings.Cut(strings.TrimSpace(s), "x")
+	if !ok {
+		return Size{}, fmt.Errorf("%q should look like 300x450", s)
+	}
+
+	width, err1 := strconv.Atoi(w)
+	height, err2 := strconv.Atoi(h)
+	if err1 != nil || err2 != nil || width <= 0 || height <= 0 {
+		return Size{}, fmt.Errorf("%q should look like 300x450", s)
+	}
+
+	return Size{Width: width, Height: height}, nil
+}
+
+// Variant is a resized copy of a poster
+type Variant struct {
+	Size
+	Fit    string // FitCover or FitContain
+	Format string // one of the Format constants
+}
+
+// Check tells what is wrong with a variant, other than its size
+func (v Variant) Check() error {
+	if v.Fit != FitCover && v.Fit != FitContain {
+		return fmt.Errorf("fit must be %s or %s", FitCover, FitContain)
+	}
+
+	if v.Format != FormatJPEG && v.Format != FormatWebP {
+		return fmt.Errorf("format must be %s or %s", FormatJPEG, FormatWebP)
+	}
+
+	return nil
+}
+
+// Key is where the variant of the image stored under source is cached: a hash of the
+// source and the parameters, spread over directories named by its first two digits.
+func (v Variant) Key(source string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s|%s", source, v.Size, v.Fit, v.Format)))
+	hash := fmt.Sprintf("%x", sum[:16])
+
+	return "variants/" + hash[:2] + "/" + hash + v.ext()
+}
+
+// ContentType is the content type of the encoded variant
+func (v Variant) ContentType() string {
+	if v.Format == FormatWebP {
+		return "image/webp"
+	}
+	return "image/jpeg"
+}
+
+// ext is the extension of the variant's key, which tells the blob store its content type
+func (v Variant) ext() string {
+	if v.Format == FormatWebP {
+		return ".webp"
+	}
+	return ".jpg"
+}
+
+// Render resizes src to the variant and encodes it. Transparent parts of the picture stay
+// transparent in WebP and become white in JPEG, which has no transparency.
+func Render(src image.Image, v Variant) ([]byte, error) {
+	if err := v.Check(); err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	if bounds.Empty() {
+		return nil, errors.New("the image is empty")
+	}
+
+	sw, sh := float64(bounds.Dx()), float64(bounds.Dy())
+	from := bounds
+	to := image.Rect(0, 0, v.Width, v.Height)
+
+	switch v.Fit {
+	case FitCover:
+		// scale the picture up or down until it covers the size, then keep the middle of it
+		scale := math.Max(float64(v.Width)/sw, float64(v.Height)/sh)
+		cw := int(math.Round(float64(v.Width) / scale))
+		ch := int(math.Round(float64(v.Height) / scale))
+		x := bounds.Min.X + (bounds.Dx()-cw)/2
+		y := bounds.Min.Y + (bounds.Dy()-ch)/2
+		from = image.Rect(x, y, x+cw, y+ch).Intersect(bounds)
+	case FitContain:
+		scale := math.Min(float64(v.Width)/sw, float64(v.Height)/sh)
+		to = image.Rect(0, 0, maxInt(1, int(math.Round(sw*scale))), maxInt(1, int(math.Round(sh*scale))))
+	}
+
+	dst := image.NewRGBA(to)
+	if v.Format == FormatJPEG {
+		draw.Draw(dst, to, image.NewUniform(color.White), image.Point{}, draw.Src)
+	}
+	draw.CatmullRom.Scale(dst, to, src, from, draw.Over, nil)
+
+	var buf bytes.Buffer
+	var err error
+	if v.Format == FormatWebP {
+		err = nativewebp.Encode(&buf, dst, nil)
+	} else {
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: variantQuality})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,133 @@
+package poster
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Size
+		ok    bool
+	}{
+		{"300x450", Size{300, 450}, true},
+		{" 92x138 ", Size{92, 138}, true},
+		{"300", Size{}, false},
+		{"300X450", Size{}, false},
+		{"0x450", Size{}, false},
+		{"-300x450", Size{}, false},
+		{"300x", Size{}, false},
+		{"axb", Size{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseSize(tt.value)
+			if (err == nil) != tt.ok || got != tt.want {
+				t.Errorf("ParseSize(%q) = %v, %v", tt.value, got, err)
+			}
+		})
+	}
+}
+
+func TestVariantCheck(t *testing.T) {
+	tests := []struct {
+		variant Variant
+		ok      bool
+	}{
+		{Variant{Size{300, 450}, FitCover, FormatJPEG}, true},
+		{Variant{Size{300, 450}, FitContain, FormatWebP}, true},
+		{Variant{Size{300, 450}, "stretch", FormatJPEG}, false},
+		{Variant{Size{300, 450}, FitCover, "png"}, false},
+		{Variant{Size{300, 450}, "", ""}, false},
+	}
+
+	for _, tt := range tests {
+		err := tt.variant.Check()
+		if (err == nil) != tt.ok {
+			t.Errorf("%+v.Check() = %v", tt.variant, err)
+		}
+	}
+}
+
+func TestVariantKey(t *testing.T) {
+	v := Variant{Size{300, 450}, FitCover, FormatJPEG}
+	key := v.Key("posters/1-abc.png")
+
+	if key != v.Key("posters/1-abc.png") {
+		t.Error("the key isn't stable")
+	}
+	if len(key) != len("variants/xx/")+32+len(".jpg") || key[9:11] != key[12:14] {
+		t.Errorf("key = %q", key)
+	}
+
+	// every parameter makes another copy
+	others := []Variant{
+		{Size{300, 451}, FitCover, FormatJPEG},
+		{Size{300, 450}, FitContain, FormatJPEG},
+		{Size{300, 450}, FitCover, FormatWebP},
+	}
+	for _, other := range others {
+		if other.Key("posters/1-abc.png") == key {
+			t.Errorf("%+v has the same key as %+v", other, v)
+		}
+	}
+	if v.Key("posters/2-abc.png") == key {
+		t.Error("two posters have the same key")
+	}
+}
+
+func TestRender(t *testing.T) {
+	// a wide picture, 200x100
+	src := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for x := 0; x < 200; x++ {
+		for y := 0; y < 100; y++ {
+			src.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 50, A: 255})
+		}
+	}
+
+	tests := []struct {
+		name    string
+		variant Variant
+		want    Size
+	}{
+		{"cover", Variant{Size{92, 138}, FitCover, FormatJPEG}, Size{92, 138}},
+		{"contain", Variant{Size{92, 138}, FitContain, FormatJPEG}, Size{92, 46}},
+		{"contain bigger", Variant{Size{600, 900}, FitContain, FormatWebP}, Size{600, 300}},
+		{"webp", Variant{Size{185, 278}, FitCover, FormatWebP}, Size{185, 278}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Render(src, tt.variant)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var config image.Config
+			if tt.variant.Format == FormatWebP {
+				config, err = webp.DecodeConfig(bytes.NewReader(out))
+			} else {
+				config, err = jpeg.DecodeConfig(bytes.NewReader(out))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := (Size{config.Width, config.Height}); got != tt.want {
+				t.Errorf("rendered %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	_, err := Render(src, Variant{Size{92, 138}, FitCover, "gif"})
+	if err == nil {
+		t.Error("no error for an unknown format")
+	}
+}
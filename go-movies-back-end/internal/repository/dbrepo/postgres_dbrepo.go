@@ -2,25 +2,137 @@ package dbrepo
 
 import (
 	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/textsearch"
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/jackc/pgconn"
 )
 
 type PostgresDBRepo struct {
-	DB *sql.DB
-}
+	DB       *sql.DB
+	Timeouts repository.Timeouts
+	Tx       repository.TxOptions
 
-const dbTimout = time.Second * 3
+	tx *sql.Tx // set on the copies handed out by WithTx
+}
 
 func (m *PostgresDBRepo) Connection() *sql.DB {
 	return m.DB
 }
 
-func (m *PostgresDBRepo) AllMovies() ([]*models.Movie, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimout)
+// conn returns the transaction the repo was handed by WithTx, or the connection pool
+func (m *PostgresDBRepo) conn() querier {
+	if m.tx != nil {
+		return m.tx
+	}
+	return m.DB
+}
+
+func (m *PostgresDBRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	return m.transaction(ctx, func(tx *PostgresDBRepo) error {
+		return fn(tx)
+	})
+}
+
+// transaction runs fn with a copy of the repo whose statements all go through one
+// transaction. A repo that already is in a transaction just keeps using it.
+func (m *PostgresDBRepo) transaction(ctx context.Context, fn func(tx *PostgresDBRepo) error) error {
+	if m.tx != nil {
+		return fn(m)
+	}
+
+	return runInTx(ctx, m.DB, m.Tx, isSerializationFailure, func(tx *sql.Tx) error {
+		return fn(&PostgresDBRepo{DB: m.DB, Timeouts: m.Timeouts, Tx: m.Tx, tx: tx})
+	})
+}
+
+// isSerializationFailure tells whether postgres aborted a transaction because of a
+// concurrent one: a serialization failure under repeatable read or serializable, or a
+// deadlock. Running the transaction again is the documented way to deal with both.
+func isSerializationFailure(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == "40001" || pgErr.Code == "40P01"
+}
+
+func (m *PostgresDBRepo) AllMovies(ctx context.Context, opts repository.MovieListOptions) (*repository.MoviePage, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "AllMovies")
 	defer cancel()
 
+	sort := opts.SortColumn()
+	if !repository.ValidSort(sort) {
+		return nil, fmt.Errorf("cannot sort movies by %q", sort)
+	}
+
+	// The filters are only known at runtime, so the where clause is built up here. Values
+	// always go in as $n parameters, the only things pasted into the query are column
+	// names we checked above. Movies in the trash are never listed.
+	where := []string{"deleted_at is null"}
+	var args []interface{}
+
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if len(opts.MPAARatings) > 0 {
+		var in []string
+		for _, rating := range opts.MPAARatings {
+			in = append(in, arg(rating))
+		}
+		where = append(where, fmt.Sprintf("mpaa_rating in (%s)", strings.Join(in, ", ")))
+	}
+
+	// years are compared as dates, so that the index on release_date can still be used
+	if opts.YearFrom > 0 {
+		where = append(where, "release_date >= "+arg(time.Date(opts.YearFrom, 1, 1, 0, 0, 0, 0, time.UTC)))
+	}
+	if opts.YearTo > 0 {
+		where = append(where, "release_date < "+arg(time.Date(opts.YearTo+1, 1, 1, 0, 0, 0, 0, time.UTC)))
+	}
+	if opts.RuntimeMin > 0 {
+		where = append(where, "runtime >= "+arg(opts.RuntimeMin))
+	}
+	if opts.RuntimeMax > 0 {
+		where = append(where, "runtime <= "+arg(opts.RuntimeMax))
+	}
+	// strpos rather than like, a % or _ in the search is nothing special
+	if opts.TitleContains != "" {
+		where = append(where, "strpos(lower(title), lower("+arg(opts.TitleContains)+")) > 0")
+	}
+
+	direction, compare := "asc", ">"
+	if opts.Desc {
+		direction, compare = "desc", "<"
+	}
+
+	// Keyset pagination: instead of an offset (which makes postgres walk over every row
+	// of the previous pages) we continue right after the last movie of the previous page.
+	// The id breaks ties between movies that have the same title, date or runtime.
+	if opts.After != "" {
+		cursor, err := repository.DecodeCursor(opts.After, sort)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := cursorValue(cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		where = append(where, fmt.Sprintf("(%s, id) %s (%s, %s)", sort, compare, arg(value), arg(cursor.ID)))
+	}
+
 	// In Golang, you cannot do anything with the database null value. So,
 	// we use coalesce. coalesce(image, '') => it does => if there is value in image
 	// field it reurns that value if there is no value or null then it return empty
@@ -34,16 +146,80 @@ func (m *PostgresDBRepo) AllMovies() ([]*models.Movie, error) {
 			created_at, updated_at
 		from
 			movies
+		where
+	` + strings.Join(where, " and ")
+
+	query += fmt.Sprintf(" order by %s %s, id %s", sort, direction, direction)
+
+	// ask for one movie more than we need, to find out whether there is a next page
+	if opts.Limit > 0 {
+		query += " limit " + arg(opts.Limit+1)
+	}
+
+	rows, err := m.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies, err := scanMovies(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return repository.NewMoviePage(movies, opts), nil
+}
+
+// cursorValue converts the value stored in a cursor back to the type of its sort column.
+func cursorValue(cursor repository.Cursor) (interface{}, error) {
+	switch cursor.Sort {
+	case repository.SortByReleaseDate:
+		t, err := time.Parse("2006-01-02", cursor.Value)
+		if err != nil {
+			return nil, repository.ErrInvalidCursor
+		}
+		return t, nil
+	case repository.SortByRuntime:
+		n, err := strconv.Atoi(cursor.Value)
+		if err != nil {
+			return nil, repository.ErrInvalidCursor
+		}
+		return n, nil
+	default:
+		return cursor.Value, nil
+	}
+}
+
+func (m *PostgresDBRepo) AllMoviesByGenre(ctx context.Context, genreID int) ([]*models.Movie, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "AllMoviesByGenre")
+	defer cancel()
+
+	query := `
+		select
+			id, title, release_date, runtime,
+			mpaa_rating, description, coalesce(image, ''),
+			created_at, updated_at
+		from
+			movies
+		where
+			deleted_at is null
+			and id in (select movie_id from movies_genres where genre_id = $1)
 		order by
 			title
 	`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.conn().QueryContext(ctx, query, genreID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	return scanMovies(rows)
+}
+
+// scanMovies reads every row of a movies query into a slice. The rows must select the
+// columns in the same order as AllMovies does.
+func scanMovies(rows *sql.Rows) ([]*models.Movie, error) {
 	var movies []*models.Movie
 
 	// Go through each of the rows one at a time
@@ -69,20 +245,288 @@ func (m *PostgresDBRepo) AllMovies() ([]*models.Movie, error) {
 		movies = append(movies, &movie)
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
 	return movies, nil
 }
 
-func (m *PostgresDBRepo) GetUserByEmail(email string) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimout)
+func (m *PostgresDBRepo) OneMovie(ctx context.Context, id int) (*models.Movie, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "OneMovie")
+	defer cancel()
+
+	// We want the movie and all of its genres in one round trip to the database. So we
+	// left join through movies_genres (a movie may have no genres at all) and let postgres
+	// fold the genres into a single json array with json_agg. The filter drops the one
+	// all-null row that the left join produces for a movie without genres, and coalesce
+	// turns "no genres" into an empty array instead of null.
+	query := `
+		select
+			m.id, m.title, m.release_date, m.runtime,
+			m.mpaa_rating, m.description, coalesce(m.image, ''),
+			m.created_at, m.updated_at,
+			coalesce(
+				json_agg(json_build_object('id', g.id, 'genre', g.genre) order by g.genre)
+					filter (where g.id is not null),
+				'[]'
+			)
+		from
+			movies m
+			left join movies_genres mg on (mg.movie_id = m.id)
+			left join genres g on (g.id = mg.genre_id)
+		where
+			m.id = $1 and m.deleted_at is null
+		group by
+			m.id
+	`
+
+	// sql.ErrNoRows is passed back as it is, so the caller can answer with a 404
+	return scanMovieWithGenres(m.conn().QueryRowContext(ctx, query, id))
+}
+
+// scanner is what *sql.Row and *sql.Rows have in common
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanMovieWithGenres reads a movie row whose last column is the json array of its genres
+func scanMovieWithGenres(row scanner) (*models.Movie, error) {
+	var movie models.Movie
+	var genres []byte
+
+	err := row.Scan(
+		&movie.ID,
+		&movie.Title,
+		&movie.ReleaseDate,
+		&movie.RunTime,
+		&movie.MPAARating,
+		&movie.Description,
+		&movie.Image,
+		&movie.CreatedAt,
+		&movie.UpdatedAt,
+		&genres,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(genres, &movie.Genres)
+	if err != nil {
+		return nil, err
+	}
+
+	return &movie, nil
+}
+
+// ExportMovies walks the whole catalogue, genres included, in id order. Every movie is
+// handed to fn as soon as its row arrives, so the catalogue is never held in memory.
+func (m *PostgresDBRepo) ExportMovies(ctx context.Context, fn func(movie *models.Movie) error) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "ExportMovies")
+	defer cancel()
+
+	query := `
+		select
+			m.id, m.title, m.release_date, m.runtime,
+			m.mpaa_rating, m.description, coalesce(m.image, ''),
+			m.created_at, m.updated_at,
+			coalesce(
+				json_agg(json_build_object('id', g.id, 'genre', g.genre) order by g.genre)
+					filter (where g.id is not null),
+				'[]'
+			)
+		from
+			movies m
+			left join movies_genres mg on (mg.movie_id = m.id)
+			left join genres g on (g.id = mg.genre_id)
+		where
+			m.deleted_at is null
+		group by
+			m.id
+		order by
+			m.id
+	`
+
+	rows, err := m.conn().QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		movie, err := scanMovieWithGenres(rows)
+		if err != nil {
+			return err
+		}
+
+		err = fn(movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// searchVector is the document full text search runs against: the title weighs more than
+// the description, so a match in the title ranks higher. It has to be written exactly like
+// the expression of the movies_search_english_idx index for postgres to use that index.
+func searchVector(language string) string {
+	return fmt.Sprintf(
+		"(setweight(to_tsvector('%[1]s', coalesce(title, '')), 'A') || "+
+			"setweight(to_tsvector('%[1]s', coalesce(description, '')), 'B'))",
+		language,
+	)
+}
+
+func (m *PostgresDBRepo) SearchMovies(ctx context.Context, query string, opts repository.SearchOptions) ([]*repository.SearchResult, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "SearchMovies")
+	defer cancel()
+
+	// the language ends up in the query text (postgres needs a constant for the index to
+	// apply), so it must be one of the known ones
+	language := opts.LanguageOrDefault()
+	if !repository.ValidSearchLanguage(language) {
+		return nil, fmt.Errorf("unsupported search language %q", language)
+	}
+
+	vector := searchVector(language)
+
+	// websearch_to_tsquery understands what people type in search boxes: quoted phrases,
+	// "or" and -excluded words, and it never fails on bad syntax. ts_headline marks the
+	// matching words so that the front end can highlight them, with markers that are
+	// turned into <mark></mark> once the text is escaped.
+	stmt := fmt.Sprintf(`
+		select
+			id, title, release_date, runtime,
+			mpaa_rating, description, coalesce(image, ''),
+			created_at, updated_at,
+			ts_rank(%[1]s, q) as rank,
+			ts_headline('%[2]s', coalesce(title, ''), q,
+				'StartSel=%[3]s, StopSel=%[4]s, HighlightAll=true'),
+			ts_headline('%[2]s', coalesce(description, ''), q,
+				'StartSel=%[3]s, StopSel=%[4]s, MaxFragments=2, MaxWords=25, MinWords=10')
+		from
+			movies,
+			websearch_to_tsquery('%[2]s', $1) q
+		where
+			%[1]s @@ q and deleted_at is null
+		order by
+			rank desc, id
+		offset $2
+	`, vector, language, textsearch.StartSel, textsearch.StopSel)
+
+	args := []interface{}{query, opts.Offset}
+
+	if opts.Limit > 0 {
+		stmt += " limit $3"
+		args = append(args, opts.Limit)
+	}
+
+	rows, err := m.conn().QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*repository.SearchResult
+
+	for rows.Next() {
+		var movie models.Movie
+		result := repository.SearchResult{Movie: &movie}
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.Title,
+			&movie.ReleaseDate,
+			&movie.RunTime,
+			&movie.MPAARating,
+			&movie.Description,
+			&movie.Image,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+			&result.Rank,
+			&result.TitleHighlight,
+			&result.Snippet,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		result.TitleHighlight = textsearch.MarkHTML(result.TitleHighlight)
+		result.Snippet = textsearch.MarkHTML(result.Snippet)
+
+		results = append(results, &result)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// SuggestTitles completes a title the user is typing. Titles starting with the prefix come
+// first, then the ones that are merely similar, so that "Highlandr" still finds
+// "Highlander". Both conditions are served by the trigram index on movies.title.
+func (m *PostgresDBRepo) SuggestTitles(ctx context.Context, prefix string, limit int) ([]*repository.Suggestion, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "SuggestTitles")
+	defer cancel()
+
+	pattern := likeEscaper.Replace(prefix) + "%"
+
+	// "<%" is the word similarity operator of pg_trgm: it is true when the prefix is
+	// similar enough to some part of the title
+	query := `
+		select
+			id, title
+		from
+			movies
+		where
+			(title ilike $2 or $1 <% title) and deleted_at is null
+		order by
+			title ilike $2 desc,
+			word_similarity($1, title) desc,
+			title
+		limit $3
+	`
+
+	rows, err := m.conn().QueryContext(ctx, query, prefix, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var suggestions []*repository.Suggestion
+
+	for rows.Next() {
+		var s repository.Suggestion
+		err := rows.Scan(&s.ID, &s.Title)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, &s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
+
+func (m *PostgresDBRepo) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "GetUserByEmail")
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password,
-			created_at, updated_at from users where email = $1`
+	query := `select id, email, first_name, last_name, password, email_verified, is_admin,
+			created_at, updated_at, tokens_revoked_at from users where lower(email) = lower($1)`
 
 	var user models.User
 	// QueryRowContext => we're making a query that returns at most one row. And email is the
 	// substitution of parameter $1 in query.
-	row := m.DB.QueryRowContext(ctx, query, email)
+	row := m.conn().QueryRowContext(ctx, query, email)
 
 	// And now, we have a row
 	err := row.Scan(
@@ -91,8 +535,11 @@ func (m *PostgresDBRepo) GetUserByEmail(email string) (*models.User, error) {
 		&user.FirstName, // we're going to read third return value into user.FirstName
 		&user.LastName,  // we're going to read fourth return value into user.LastName
 		&user.Password,  // we're going to read fifth return value into user.Password
+		&user.EmailVerified,
+		&user.IsAdmin,
 		&user.CreatedAt, // we're going to read sixth return value into user.CreatedAt
 		&user.UpdatedAt, // we're going to read seventh return value into user.UpdatedAt
+		&user.TokensRevokedAt,
 	)
 
 	if err != nil {
@@ -102,17 +549,17 @@ func (m *PostgresDBRepo) GetUserByEmail(email string) (*models.User, error) {
 	return &user, nil
 }
 
-func (m *PostgresDBRepo) GetUserByID(id int) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimout)
+func (m *PostgresDBRepo) GetUserByID(ctx context.Context, id int) (*models.User, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "GetUserByID")
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password,
-			created_at, updated_at from users where id = $1`
+	query := `select id, email, first_name, last_name, password, email_verified, is_admin,
+			created_at, updated_at, tokens_revoked_at from users where id = $1`
 
 	var user models.User
 	// QueryRowContext => we're making a query that returns at most one row. And email is the
 	// substitution of parameter $1 in query.
-	row := m.DB.QueryRowContext(ctx, query, id)
+	row := m.conn().QueryRowContext(ctx, query, id)
 
 	// And now, we have a row
 	err := row.Scan(
@@ -121,8 +568,11 @@ func (m *PostgresDBRepo) GetUserByID(id int) (*models.User, error) {
 		&user.FirstName, // we're going to read third return value into user.FirstName
 		&user.LastName,  // we're going to read fourth return value into user.LastName
 		&user.Password,  // we're going to read fifth return value into user.Password
+		&user.EmailVerified,
+		&user.IsAdmin,
 		&user.CreatedAt, // we're going to read sixth return value into user.CreatedAt
 		&user.UpdatedAt, // we're going to read seventh return value into user.UpdatedAt
+		&user.TokensRevokedAt,
 	)
 
 	if err != nil {
@@ -131,3 +581,737 @@ func (m *PostgresDBRepo) GetUserByID(id int) (*models.User, error) {
 
 	return &user, nil
 }
+
+func (m *PostgresDBRepo) CreateUser(ctx context.Context, user models.User) (int, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "CreateUser")
+	defer cancel()
+
+	stmt := `insert into users (email, first_name, last_name, password, email_verified,
+			is_admin, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	var newID int
+
+	err := m.conn().QueryRowContext(ctx, stmt,
+		user.Email,
+		user.FirstName,
+		user.LastName,
+		user.Password,
+		user.EmailVerified,
+		user.IsAdmin,
+		user.CreatedAt,
+		user.UpdatedAt,
+	).Scan(&newID)
+
+	if err != nil {
+		// 23505 is unique_violation, the only unique index of users is the one on the email
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return 0, repository.ErrDuplicateEmail
+		}
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+func (m *PostgresDBRepo) VerifyUserEmail(ctx context.Context, id int, at time.Time) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "VerifyUserEmail")
+	defer cancel()
+
+	result, err := m.conn().ExecContext(ctx, `update users set email_verified = true, updated_at = $1 where id = $2`, at, id)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+func (m *PostgresDBRepo) UpdateUserPassword(ctx context.Context, id int, password string, at time.Time) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "UpdateUserPassword")
+	defer cancel()
+
+	result, err := m.conn().ExecContext(ctx, `update users set password = $1, updated_at = $2 where id = $3`, password, at, id)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+func (m *PostgresDBRepo) RevokeUserTokens(ctx context.Context, id int, at time.Time) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "RevokeUserTokens")
+	defer cancel()
+
+	return m.transaction(ctx, func(tx *PostgresDBRepo) error {
+		result, err := tx.conn().ExecContext(ctx, `update users set tokens_revoked_at = $1 where id = $2`, at, id)
+		if err != nil {
+			return err
+		}
+
+		err = checkRowsAffected(result)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.conn().ExecContext(ctx,
+			`update refresh_tokens set revoked_at = $1 where user_id = $2 and revoked_at is null`, at, id)
+		return err
+	})
+}
+
+func (m *PostgresDBRepo) InsertRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "InsertRefreshToken")
+	defer cancel()
+
+	stmt := `insert into refresh_tokens (id, family_id, user_id, expires_at, created_at)
+			values ($1, $2, $3, $4, $5)`
+
+	_, err := m.conn().ExecContext(ctx, stmt,
+		token.ID,
+		token.FamilyID,
+		token.UserID,
+		token.ExpiresAt,
+		token.CreatedAt,
+	)
+
+	return err
+}
+
+func (m *PostgresDBRepo) GetRefreshToken(ctx context.Context, id string) (*models.RefreshToken, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "GetRefreshToken")
+	defer cancel()
+
+	query := `select id, family_id, user_id, expires_at, used_at, revoked_at, created_at
+			from refresh_tokens where id = $1`
+
+	var token models.RefreshToken
+
+	err := m.conn().QueryRowContext(ctx, query, id).Scan(
+		&token.ID,
+		&token.FamilyID,
+		&token.UserID,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.RevokedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (m *PostgresDBRepo) UseRefreshToken(ctx context.Context, id string, at time.Time) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "UseRefreshToken")
+	defer cancel()
+
+	result, err := m.conn().ExecContext(ctx,
+		`update refresh_tokens set used_at = $2
+		where id = $1 and used_at is null and revoked_at is null and expires_at > $2`,
+		id, at,
+	)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+func (m *PostgresDBRepo) RevokeRefreshFamily(ctx context.Context, familyID string, at time.Time) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "RevokeRefreshFamily")
+	defer cancel()
+
+	_, err := m.conn().ExecContext(ctx,
+		`update refresh_tokens set revoked_at = $2 where family_id = $1 and revoked_at is null`,
+		familyID, at,
+	)
+
+	return err
+}
+
+func (m *PostgresDBRepo) PurgeRefreshTokens(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "PurgeRefreshTokens")
+	defer cancel()
+
+	result, err := m.conn().ExecContext(ctx, `delete from refresh_tokens where expires_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (m *PostgresDBRepo) InsertPasswordReset(ctx context.Context, reset models.PasswordReset) (int, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "InsertPasswordReset")
+	defer cancel()
+
+	stmt := `insert into password_resets (user_id, token_hash, expires_at, created_at)
+			values ($1, $2, $3, $4) returning id`
+
+	var newID int
+
+	err := m.conn().QueryRowContext(ctx, stmt,
+		reset.UserID,
+		reset.TokenHash,
+		reset.ExpiresAt,
+		reset.CreatedAt,
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+func (m *PostgresDBRepo) UsePasswordReset(ctx context.Context, tokenHash string, at time.Time) (*models.PasswordReset, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "UsePasswordReset")
+	defer cancel()
+
+	var reset models.PasswordReset
+
+	err := m.transaction(ctx, func(tx *PostgresDBRepo) error {
+		// the update only matches a pending token, two requests with the same token can't
+		// both get it back
+		err := tx.conn().QueryRowContext(ctx,
+			`update password_resets set used_at = $2
+			where token_hash = $1 and used_at is null and expires_at > $2
+			returning id, user_id, token_hash, expires_at, used_at, created_at`,
+			tokenHash, at,
+		).Scan(
+			&reset.ID,
+			&reset.UserID,
+			&reset.TokenHash,
+			&reset.ExpiresAt,
+			&reset.UsedAt,
+			&reset.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.conn().ExecContext(ctx,
+			`update password_resets set used_at = $2 where user_id = $1 and used_at is null`,
+			reset.UserID, at,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &reset, nil
+}
+
+func (m *PostgresDBRepo) InsertMovie(ctx context.Context, movie models.Movie) (int, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "InsertMovie")
+	defer cancel()
+
+	// "returning id" hands us back the id that postgres generated for the new row,
+	// so we don't need a second query to find out which movie we just created.
+	stmt := `insert into movies (title, description, release_date, runtime,
+			mpaa_rating, image, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	var newID int
+
+	err := m.conn().QueryRowContext(ctx, stmt,
+		movie.Title,
+		movie.Description,
+		movie.ReleaseDate,
+		movie.RunTime,
+		movie.MPAARating,
+		movie.Image,
+		movie.CreatedAt,
+		movie.UpdatedAt,
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+func (m *PostgresDBRepo) UpdateMovie(ctx context.Context, movie models.Movie, version time.Time) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "UpdateMovie")
+	defer cancel()
+
+	stmt := `update movies set title = $1, description = $2, release_date = $3,
+			runtime = $4, mpaa_rating = $5, image = $6, updated_at = $7
+			where id = $8 and deleted_at is null
+			and ($9::timestamp is null or updated_at = $9)`
+
+	result, err := m.conn().ExecContext(ctx, stmt,
+		movie.Title,
+		movie.Description,
+		movie.ReleaseDate,
+		movie.RunTime,
+		movie.MPAARating,
+		movie.Image,
+		movie.UpdatedAt,
+		movie.ID,
+		versionArg(version),
+	)
+	if err != nil {
+		return err
+	}
+
+	// if nothing was updated then there is no movie with this id, or it has changed
+	return m.checkVersionedChange(ctx, result, movie.ID)
+}
+
+// DeleteMovie moves a movie to the trash. It can be brought back with RestoreMovie until
+// it is purged.
+func (m *PostgresDBRepo) DeleteMovie(ctx context.Context, id int, version time.Time) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "DeleteMovie")
+	defer cancel()
+
+	stmt := `update movies set deleted_at = $1, updated_at = $1 where id = $2 and deleted_at is null
+			and ($3::timestamp is null or updated_at = $3)`
+
+	result, err := m.conn().ExecContext(ctx, stmt, time.Now().UTC(), id, versionArg(version))
+	if err != nil {
+		return err
+	}
+
+	return m.checkVersionedChange(ctx, result, id)
+}
+
+// checkVersionedChange tells why a conditional change of a movie did nothing: either
+// there is no such movie or it doesn't have the version the change was made for
+func (m *PostgresDBRepo) checkVersionedChange(ctx context.Context, result sql.Result, id int) error {
+	err := checkRowsAffected(result)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	var exists bool
+	err = m.conn().QueryRowContext(ctx,
+		`select exists(select 1 from movies where id = $1 and deleted_at is null)`, id,
+	).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return repository.ErrVersionMismatch
+	}
+	return sql.ErrNoRows
+}
+
+// LastMovieChange returns when a movie was last created, changed, deleted or restored,
+// the zero time when there are no movies
+func (m *PostgresDBRepo) LastMovieChange(ctx context.Context) (time.Time, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "LastMovieChange")
+	defer cancel()
+
+	var last sql.NullTime
+
+	err := m.conn().QueryRowContext(ctx, `select max(updated_at) from movies`).Scan(&last)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return last.Time, nil
+}
+
+// TrashedMovies lists the movies in the trash, the most recently deleted first
+func (m *PostgresDBRepo) TrashedMovies(ctx context.Context) ([]*models.Movie, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "TrashedMovies")
+	defer cancel()
+
+	query := `
+		select
+			id, title, release_date, runtime,
+			mpaa_rating, description, coalesce(image, ''),
+			created_at, updated_at, deleted_at
+		from
+			movies
+		where
+			deleted_at is not null
+		order by
+			deleted_at desc, id
+	`
+
+	rows, err := m.conn().QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTrashedMovies(rows)
+}
+
+// scanTrashedMovies reads the rows of a trash listing, which have deleted_at as last column
+func scanTrashedMovies(rows *sql.Rows) ([]*models.Movie, error) {
+	movies := []*models.Movie{}
+
+	for rows.Next() {
+		var movie models.Movie
+		var deletedAt time.Time
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.Title,
+			&movie.ReleaseDate,
+			&movie.RunTime,
+			&movie.MPAARating,
+			&movie.Description,
+			&movie.Image,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+			&deletedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movie.DeletedAt = &deletedAt
+		movies = append(movies, &movie)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// RestoreMovie takes a movie out of the trash
+func (m *PostgresDBRepo) RestoreMovie(ctx context.Context, id int) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "RestoreMovie")
+	defer cancel()
+
+	stmt := `update movies set deleted_at = null, updated_at = $1 where id = $2 and deleted_at is not null`
+
+	result, err := m.conn().ExecContext(ctx, stmt, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+// PurgeMovie deletes a movie for good. Only movies in the trash can be purged, and its
+// movies_genres rows go with it (on delete cascade).
+func (m *PostgresDBRepo) PurgeMovie(ctx context.Context, id int) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "PurgeMovie")
+	defer cancel()
+
+	stmt := `delete from movies where id = $1 and deleted_at is not null`
+
+	result, err := m.conn().ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+// UpdateMovieGenres replaces the whole set of genres of a movie. The old rows are deleted
+// and the new ones inserted inside one transaction, so a failure half way through leaves
+// the movie with its previous genres instead of with none.
+func (m *PostgresDBRepo) UpdateMovieGenres(ctx context.Context, id int, genreIDs []int) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "UpdateMovieGenres")
+	defer cancel()
+
+	return m.transaction(ctx, func(tx *PostgresDBRepo) error {
+		_, err := tx.conn().ExecContext(ctx, `delete from movies_genres where movie_id = $1`, id)
+		if err != nil {
+			return err
+		}
+
+		for _, genreID := range genreIDs {
+			stmt := `insert into movies_genres (movie_id, genre_id) values ($1, $2)`
+			_, err = tx.conn().ExecContext(ctx, stmt, id, genreID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (m *PostgresDBRepo) AllGenres(ctx context.Context) ([]*models.Genre, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "AllGenres")
+	defer cancel()
+
+	query := `select id, genre, created_at, updated_at from genres order by genre`
+
+	rows, err := m.conn().QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var genres []*models.Genre
+
+	for rows.Next() {
+		var g models.Genre
+		err := rows.Scan(
+			&g.ID,
+			&g.Genre,
+			&g.CreatedAt,
+			&g.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &g)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+// MovieGenres loads the genres of many movies with a single query, for the lists that
+// show them
+func (m *PostgresDBRepo) MovieGenres(ctx context.Context, movieIDs []int) (map[int][]*models.Genre, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "MovieGenres")
+	defer cancel()
+
+	genres := make(map[int][]*models.Genre, len(movieIDs))
+	if len(movieIDs) == 0 {
+		return genres, nil
+	}
+
+	var in []string
+	var args []interface{}
+	for _, id := range movieIDs {
+		genres[id] = []*models.Genre{}
+		args = append(args, id)
+		in = append(in, fmt.Sprintf("$%d", len(args)))
+	}
+
+	query := fmt.Sprintf(`
+		select
+			mg.movie_id, g.id, g.genre, g.created_at, g.updated_at
+		from
+			movies_genres mg
+			join genres g on (g.id = mg.genre_id)
+		where
+			mg.movie_id in (%s)
+		order by
+			g.genre
+	`, strings.Join(in, ", "))
+
+	rows, err := m.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movieID int
+		var g models.Genre
+		err := rows.Scan(
+			&movieID,
+			&g.ID,
+			&g.Genre,
+			&g.CreatedAt,
+			&g.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		genres[movieID] = append(genres[movieID], &g)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+// InsertMovieRevision stores the next revision of a movie and returns its number. The
+// number is worked out in the insert itself; the unique constraint on (movie_id,
+// revision) catches two changes racing for the same number.
+func (m *PostgresDBRepo) InsertMovieRevision(ctx context.Context, rev models.MovieRevision) (int, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "InsertMovieRevision")
+	defer cancel()
+
+	changedFields, snapshot, err := marshalRevision(rev)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `
+		insert into movie_revisions
+			(movie_id, revision, action, user_id, changed_fields, snapshot, created_at)
+		values (
+			$1,
+			(select coalesce(max(revision), 0) + 1 from movie_revisions where movie_id = $1),
+			$2, nullif($3, 0), $4, $5, $6
+		)
+		returning revision
+	`
+
+	var revision int
+
+	err = m.conn().QueryRowContext(ctx, stmt,
+		rev.MovieID,
+		rev.Action,
+		rev.UserID,
+		changedFields,
+		snapshot,
+		rev.CreatedAt,
+	).Scan(&revision)
+	if err != nil {
+		return 0, err
+	}
+
+	return revision, nil
+}
+
+// revisionColumns are the columns scanRevision reads, from movie_revisions r left joined
+// with the users u who made them
+const revisionColumns = `
+	r.id, r.movie_id, r.revision, r.action,
+	coalesce(r.user_id, 0), coalesce(u.email, ''),
+	r.changed_fields, r.snapshot, r.created_at
+`
+
+// MovieRevisions lists the revisions of a movie, the latest first
+func (m *PostgresDBRepo) MovieRevisions(ctx context.Context, movieID int) ([]*models.MovieRevision, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "MovieRevisions")
+	defer cancel()
+
+	query := `
+		select` + revisionColumns + `
+		from
+			movie_revisions r
+			left join users u on (u.id = r.user_id)
+		where
+			r.movie_id = $1
+		order by
+			r.revision desc
+	`
+
+	rows, err := m.conn().QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRevisions(rows)
+}
+
+// MovieRevision returns one revision of a movie, or sql.ErrNoRows
+func (m *PostgresDBRepo) MovieRevision(ctx context.Context, movieID, revision int) (*models.MovieRevision, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "MovieRevision")
+	defer cancel()
+
+	query := `
+		select` + revisionColumns + `
+		from
+			movie_revisions r
+			left join users u on (u.id = r.user_id)
+		where
+			r.movie_id = $1 and r.revision = $2
+	`
+
+	return scanRevision(m.conn().QueryRowContext(ctx, query, movieID, revision))
+}
+
+// marshalRevision turns the json columns of a revision into text, which both databases
+// accept for them
+func marshalRevision(rev models.MovieRevision) (string, string, error) {
+	if rev.ChangedFields == nil {
+		rev.ChangedFields = []string{}
+	}
+
+	changedFields, err := json.Marshal(rev.ChangedFields)
+	if err != nil {
+		return "", "", err
+	}
+
+	snapshot, err := json.Marshal(rev.Snapshot)
+	if err != nil {
+		return "", "", err
+	}
+
+	return string(changedFields), string(snapshot), nil
+}
+
+func scanRevision(row scanner) (*models.MovieRevision, error) {
+	var rev models.MovieRevision
+	var changedFields, snapshot []byte
+
+	err := row.Scan(
+		&rev.ID,
+		&rev.MovieID,
+		&rev.Revision,
+		&rev.Action,
+		&rev.UserID,
+		&rev.UserEmail,
+		&changedFields,
+		&snapshot,
+		&rev.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(changedFields, &rev.ChangedFields)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(snapshot, &rev.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rev, nil
+}
+
+func scanRevisions(rows *sql.Rows) ([]*models.MovieRevision, error) {
+	revisions := []*models.MovieRevision{}
+
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// checkRowsAffected turns a statement that touched no rows into sql.ErrNoRows, so that
+// handlers can tell "there is no such movie" apart from a real database error.
+func checkRowsAffected(result sql.Result) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// versionArg is the parameter of a version condition, null when there is none
+func versionArg(version time.Time) interface{} {
+	if version.IsZero() {
+		return nil
+	}
+	return version
+}
@@ -0,0 +1,1135 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/textsearch"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// SQLiteDBRepo is the DatabaseRepo for small installs that keep the whole catalogue in
+// a single SQLite file. The queries mirror the ones of PostgresDBRepo. What SQLite can't
+// do by itself (stemmed full text search and trigram matching) is done in Go on top of
+// plain queries, which is fine for the few hundred movies such an install holds.
+type SQLiteDBRepo struct {
+	DB       *sql.DB
+	Timeouts repository.Timeouts
+	Tx       repository.TxOptions
+
+	tx *sql.Tx // set on the copies handed out by WithTx
+}
+
+// release dates are stored as plain yyyy-mm-dd text, so that they compare correctly
+// against the dates built for filters and cursors
+const sqliteDate = "2006-01-02"
+
+// the updated_at of movies is stored in UTC to the microsecond, the precision of the
+// versions the API hands out as ETags, so that a version compares as plain text
+const sqliteTimestamp = "2006-01-02 15:04:05.000000"
+
+// sqliteTime is t as it is stored in updated_at
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimestamp)
+}
+
+func (m *SQLiteDBRepo) Connection() *sql.DB {
+	return m.DB
+}
+
+// conn returns the transaction the repo was handed by WithTx, or the connection pool
+func (m *SQLiteDBRepo) conn() querier {
+	if m.tx != nil {
+		return m.tx
+	}
+	return m.DB
+}
+
+func (m *SQLiteDBRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	return m.transaction(ctx, func(tx *SQLiteDBRepo) error {
+		return fn(tx)
+	})
+}
+
+// transaction runs fn with a copy of the repo whose statements all go through one
+// transaction. A repo that already is in a transaction just keeps using it.
+func (m *SQLiteDBRepo) transaction(ctx context.Context, fn func(tx *SQLiteDBRepo) error) error {
+	if m.tx != nil {
+		return fn(m)
+	}
+
+	return runInTx(ctx, m.DB, m.Tx, isBusy, func(tx *sql.Tx) error {
+		return fn(&SQLiteDBRepo{DB: m.DB, Timeouts: m.Timeouts, Tx: m.Tx, tx: tx})
+	})
+}
+
+// isBusy tells whether SQLite gave up on a transaction because another connection held
+// the database lock for longer than the busy timeout.
+func isBusy(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	// the low byte is the primary result code, SQLITE_BUSY is 5 and SQLITE_LOCKED is 6
+	code := sqliteErr.Code() & 0xff
+	return code == 5 || code == 6
+}
+
+func (m *SQLiteDBRepo) AllMovies(ctx context.Context, opts repository.MovieListOptions) (*repository.MoviePage, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "AllMovies")
+	defer cancel()
+
+	sort := opts.SortColumn()
+	if !repository.ValidSort(sort) {
+		return nil, fmt.Errorf("cannot sort movies by %q", sort)
+	}
+
+	// movies in the trash are never listed
+	where := []string{"deleted_at is null"}
+	var args []interface{}
+
+	if len(opts.MPAARatings) > 0 {
+		in := strings.TrimSuffix(strings.Repeat("?, ", len(opts.MPAARatings)), ", ")
+		where = append(where, fmt.Sprintf("mpaa_rating in (%s)", in))
+		for _, rating := range opts.MPAARatings {
+			args = append(args, rating)
+		}
+	}
+
+	if opts.YearFrom > 0 {
+		where = append(where, "release_date >= ?")
+		args = append(args, fmt.Sprintf("%04d-01-01", opts.YearFrom))
+	}
+	if opts.YearTo > 0 {
+		where = append(where, "release_date < ?")
+		args = append(args, fmt.Sprintf("%04d-01-01", opts.YearTo+1))
+	}
+	if opts.RuntimeMin > 0 {
+		where = append(where, "runtime >= ?")
+		args = append(args, opts.RuntimeMin)
+	}
+	if opts.RuntimeMax > 0 {
+		where = append(where, "runtime <= ?")
+		args = append(args, opts.RuntimeMax)
+	}
+	// instr rather than like, a % or _ in the search is nothing special
+	if opts.TitleContains != "" {
+		where = append(where, "instr(lower(title), lower(?)) > 0")
+		args = append(args, opts.TitleContains)
+	}
+
+	direction, compare := "asc", ">"
+	if opts.Desc {
+		direction, compare = "desc", "<"
+	}
+
+	if opts.After != "" {
+		cursor, err := repository.DecodeCursor(opts.After, sort)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := cursorValue(cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		// dates are text in SQLite, so the cursor date goes back in as text too
+		if t, ok := value.(time.Time); ok {
+			value = t.Format(sqliteDate)
+		}
+
+		where = append(where, fmt.Sprintf("(%s, id) %s (?, ?)", sort, compare))
+		args = append(args, value, cursor.ID)
+	}
+
+	query := `
+		select
+			id, title, release_date, runtime,
+			mpaa_rating, description, coalesce(image, ''),
+			created_at, updated_at
+		from
+			movies
+		where
+	` + strings.Join(where, " and ")
+
+	query += fmt.Sprintf(" order by %s %s, id %s", sort, direction, direction)
+
+	if opts.Limit > 0 {
+		query += " limit ?"
+		args = append(args, opts.Limit+1)
+	}
+
+	rows, err := m.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies, err := scanMovies(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return repository.NewMoviePage(movies, opts), nil
+}
+
+func (m *SQLiteDBRepo) AllMoviesByGenre(ctx context.Context, genreID int) ([]*models.Movie, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "AllMoviesByGenre")
+	defer cancel()
+
+	query := `
+		select
+			id, title, release_date, runtime,
+			mpaa_rating, description, coalesce(image, ''),
+			created_at, updated_at
+		from
+			movies
+		where
+			deleted_at is null
+			and id in (select movie_id from movies_genres where genre_id = ?)
+		order by
+			title
+	`
+
+	rows, err := m.conn().QueryContext(ctx, query, genreID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanMovies(rows)
+}
+
+func (m *SQLiteDBRepo) OneMovie(ctx context.Context, id int) (*models.Movie, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "OneMovie")
+	defer cancel()
+
+	// the same single round trip as in postgres, with json_group_array doing what
+	// json_agg does there
+	query := `
+		select
+			m.id, m.title, m.release_date, m.runtime,
+			m.mpaa_rating, m.description, coalesce(m.image, ''),
+			m.created_at, m.updated_at,
+			coalesce(
+				json_group_array(json_object('id', g.id, 'genre', g.genre))
+					filter (where g.id is not null),
+				'[]'
+			)
+		from
+			movies m
+			left join movies_genres mg on (mg.movie_id = m.id)
+			left join genres g on (g.id = mg.genre_id)
+		where
+			m.id = ? and m.deleted_at is null
+		group by
+			m.id
+	`
+
+	movie, err := scanMovieWithGenres(m.conn().QueryRowContext(ctx, query, id))
+	if err != nil {
+		return nil, err
+	}
+
+	// json_group_array has no order by, so the genres are sorted here
+	sortGenres(movie.Genres)
+
+	return movie, nil
+}
+
+// ExportMovies walks the whole catalogue like the postgres version does
+func (m *SQLiteDBRepo) ExportMovies(ctx context.Context, fn func(movie *models.Movie) error) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "ExportMovies")
+	defer cancel()
+
+	query := `
+		select
+			m.id, m.title, m.release_date, m.runtime,
+			m.mpaa_rating, m.description, coalesce(m.image, ''),
+			m.created_at, m.updated_at,
+			coalesce(
+				json_group_array(json_object('id', g.id, 'genre', g.genre))
+					filter (where g.id is not null),
+				'[]'
+			)
+		from
+			movies m
+			left join movies_genres mg on (mg.movie_id = m.id)
+			left join genres g on (g.id = mg.genre_id)
+		where
+			m.deleted_at is null
+		group by
+			m.id
+		order by
+			m.id
+	`
+
+	rows, err := m.conn().QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		movie, err := scanMovieWithGenres(rows)
+		if err != nil {
+			return err
+		}
+
+		sortGenres(movie.Genres)
+
+		err = fn(movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func sortGenres(genres []*models.Genre) {
+	sort.Slice(genres, func(i, j int) bool {
+		return genres[i].Genre < genres[j].Genre
+	})
+}
+
+// SearchMovies finds movies having every word of the query in their title or their
+// description. SQLite narrows the movies down with like, ranking and highlighting happen
+// in Go. There is no stemming, a word only has to start with what was typed.
+func (m *SQLiteDBRepo) SearchMovies(ctx context.Context, query string, opts repository.SearchOptions) ([]*repository.SearchResult, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "SearchMovies")
+	defer cancel()
+
+	if !repository.ValidSearchLanguage(opts.LanguageOrDefault()) {
+		return nil, fmt.Errorf("unsupported search language %q", opts.Language)
+	}
+
+	terms := textsearch.Terms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	where := []string{"deleted_at is null"}
+	var args []interface{}
+
+	for _, term := range terms {
+		pattern := "%" + likeEscaper.Replace(term) + "%"
+		where = append(where, `(title like ? escape '\' or description like ? escape '\')`)
+		args = append(args, pattern, pattern)
+	}
+
+	stmt := `
+		select
+			id, title, release_date, runtime,
+			mpaa_rating, description, coalesce(image, ''),
+			created_at, updated_at
+		from
+			movies
+		where
+	` + strings.Join(where, " and ")
+
+	rows, err := m.conn().QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies, err := scanMovies(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*repository.SearchResult
+
+	for _, movie := range movies {
+		// like only checked that the words are somewhere in the text, Match wants them at
+		// the start of a word. The title counts twice, as with the weights in postgres.
+		rank := textsearch.Match(terms, movie.Title+" "+movie.Description)
+		if rank == 0 {
+			continue
+		}
+		rank += textsearch.Match(terms, movie.Title)
+
+		results = append(results, &repository.SearchResult{
+			Movie:          movie,
+			Rank:           rank,
+			TitleHighlight: textsearch.Highlight(movie.Title, terms),
+			Snippet:        textsearch.Snippet(movie.Description, terms, 25),
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return results[i].Movie.ID < results[j].Movie.ID
+	})
+
+	if opts.Offset >= len(results) {
+		return nil, nil
+	}
+	results = results[opts.Offset:]
+
+	if opts.Limit > 0 && len(results) > opts.Limit {
+		results = results[:opts.Limit]
+	}
+
+	return results, nil
+}
+
+// likeEscaper keeps % and _ typed by the user from acting as wildcards of a like pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// SuggestTitles scores every title against the prefix with the same trigram similarity
+// pg_trgm uses, titles starting with the prefix first.
+func (m *SQLiteDBRepo) SuggestTitles(ctx context.Context, prefix string, limit int) ([]*repository.Suggestion, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "SuggestTitles")
+	defer cancel()
+
+	rows, err := m.conn().QueryContext(ctx, `select id, title from movies where deleted_at is null`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type scored struct {
+		suggestion *repository.Suggestion
+		isPrefix   bool
+		score      float64
+	}
+
+	lowerPrefix := strings.ToLower(prefix)
+
+	var candidates []scored
+
+	for rows.Next() {
+		var s repository.Suggestion
+		err := rows.Scan(&s.ID, &s.Title)
+		if err != nil {
+			return nil, err
+		}
+
+		isPrefix := strings.HasPrefix(strings.ToLower(s.Title), lowerPrefix)
+		score := textsearch.WordSimilarity(prefix, s.Title)
+
+		if isPrefix || score >= textsearch.SimilarityThreshold {
+			candidates = append(candidates, scored{suggestion: &s, isPrefix: isPrefix, score: score})
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		switch {
+		case a.isPrefix != b.isPrefix:
+			return a.isPrefix
+		case a.score != b.score:
+			return a.score > b.score
+		default:
+			return a.suggestion.Title < b.suggestion.Title
+		}
+	})
+
+	var suggestions []*repository.Suggestion
+	for i, c := range candidates {
+		if limit > 0 && i == limit {
+			break
+		}
+		suggestions = append(suggestions, c.suggestion)
+	}
+
+	return suggestions, nil
+}
+
+func (m *SQLiteDBRepo) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "GetUserByEmail")
+	defer cancel()
+
+	query := `select id, email, first_name, last_name, password, email_verified, is_admin,
+			created_at, updated_at, tokens_revoked_at from users where lower(email) = lower(?)`
+
+	var user models.User
+	row := m.conn().QueryRowContext(ctx, query, email)
+
+	err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.FirstName,
+		&user.LastName,
+		&user.Password,
+		&user.EmailVerified,
+		&user.IsAdmin,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.TokensRevokedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (m *SQLiteDBRepo) GetUserByID(ctx context.Context, id int) (*models.User, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "GetUserByID")
+	defer cancel()
+
+	query := `select id, email, first_name, last_name, password, email_verified, is_admin,
+			created_at, updated_at, tokens_revoked_at from users where id = ?`
+
+	var user models.User
+	row := m.conn().QueryRowContext(ctx, query, id)
+
+	err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.FirstName,
+		&user.LastName,
+		&user.Password,
+		&user.EmailVerified,
+		&user.IsAdmin,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.TokensRevokedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (m *SQLiteDBRepo) CreateUser(ctx context.Context, user models.User) (int, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "CreateUser")
+	defer cancel()
+
+	stmt := `insert into users (email, first_name, last_name, password, email_verified,
+			is_admin, created_at, updated_at)
+			values (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := m.conn().ExecContext(ctx, stmt,
+		user.Email,
+		user.FirstName,
+		user.LastName,
+		user.Password,
+		user.EmailVerified,
+		user.IsAdmin,
+		user.CreatedAt,
+		user.UpdatedAt,
+	)
+	if err != nil {
+		// SQLITE_CONSTRAINT_UNIQUE, the only unique index of users is the one on the email
+		var sqliteErr *sqlite.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.Code() == 2067 {
+			return 0, repository.ErrDuplicateEmail
+		}
+		return 0, err
+	}
+
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(newID), nil
+}
+
+func (m *SQLiteDBRepo) VerifyUserEmail(ctx context.Context, id int, at time.Time) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "VerifyUserEmail")
+	defer cancel()
+
+	result, err := m.conn().ExecContext(ctx, `update users set email_verified = 1, updated_at = ? where id = ?`, at, id)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+func (m *SQLiteDBRepo) UpdateUserPassword(ctx context.Context, id int, password string, at time.Time) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "UpdateUserPassword")
+	defer cancel()
+
+	result, err := m.conn().ExecContext(ctx, `update users set password = ?, updated_at = ? where id = ?`, password, at, id)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+func (m *SQLiteDBRepo) RevokeUserTokens(ctx context.Context, id int, at time.Time) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "RevokeUserTokens")
+	defer cancel()
+
+	return m.transaction(ctx, func(tx *SQLiteDBRepo) error {
+		result, err := tx.conn().ExecContext(ctx, `update users set tokens_revoked_at = ? where id = ?`, at, id)
+		if err != nil {
+			return err
+		}
+
+		err = checkRowsAffected(result)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.conn().ExecContext(ctx,
+			`update refresh_tokens set revoked_at = ? where user_id = ? and revoked_at is null`, at, id)
+		return err
+	})
+}
+
+func (m *SQLiteDBRepo) InsertRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "InsertRefreshToken")
+	defer cancel()
+
+	stmt := `insert into refresh_tokens (id, family_id, user_id, expires_at, created_at)
+			values (?, ?, ?, ?, ?)`
+
+	_, err := m.conn().ExecContext(ctx, stmt,
+		token.ID,
+		token.FamilyID,
+		token.UserID,
+		token.ExpiresAt,
+		token.CreatedAt,
+	)
+
+	return err
+}
+
+func (m *SQLiteDBRepo) GetRefreshToken(ctx context.Context, id string) (*models.RefreshToken, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "GetRefreshToken")
+	defer cancel()
+
+	query := `select id, family_id, user_id, expires_at, used_at, revoked_at, created_at
+			from refresh_tokens where id = ?`
+
+	var token models.RefreshToken
+
+	err := m.conn().QueryRowContext(ctx, query, id).Scan(
+		&token.ID,
+		&token.FamilyID,
+		&token.UserID,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.RevokedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (m *SQLiteDBRepo) UseRefreshToken(ctx context.Context, id string, at time.Time) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "UseRefreshToken")
+	defer cancel()
+
+	result, err := m.conn().ExecContext(ctx,
+		`update refresh_tokens set used_at = ?2
+		where id = ?1 and used_at is null and revoked_at is null and julianday(expires_at) > julianday(?2)`,
+		id, at,
+	)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+func (m *SQLiteDBRepo) RevokeRefreshFamily(ctx context.Context, familyID string, at time.Time) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "RevokeRefreshFamily")
+	defer cancel()
+
+	_, err := m.conn().ExecContext(ctx,
+		`update refresh_tokens set revoked_at = ?2 where family_id = ?1 and revoked_at is null`,
+		familyID, at,
+	)
+
+	return err
+}
+
+func (m *SQLiteDBRepo) PurgeRefreshTokens(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "PurgeRefreshTokens")
+	defer cancel()
+
+	result, err := m.conn().ExecContext(ctx,
+		`delete from refresh_tokens where julianday(expires_at) < julianday(?)`, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (m *SQLiteDBRepo) InsertPasswordReset(ctx context.Context, reset models.PasswordReset) (int, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "InsertPasswordReset")
+	defer cancel()
+
+	stmt := `insert into password_resets (user_id, token_hash, expires_at, created_at)
+			values (?, ?, ?, ?)`
+
+	result, err := m.conn().ExecContext(ctx, stmt,
+		reset.UserID,
+		reset.TokenHash,
+		reset.ExpiresAt,
+		reset.CreatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(newID), nil
+}
+
+func (m *SQLiteDBRepo) UsePasswordReset(ctx context.Context, tokenHash string, at time.Time) (*models.PasswordReset, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "UsePasswordReset")
+	defer cancel()
+
+	var reset models.PasswordReset
+
+	err := m.transaction(ctx, func(tx *SQLiteDBRepo) error {
+		// the update only matches a pending token, two requests with the same token can't
+		// both get it back
+		err := tx.conn().QueryRowContext(ctx,
+			`update password_resets set used_at = ?2
+			where token_hash = ?1 and used_at is null and julianday(expires_at) > julianday(?2)
+			returning id, user_id, token_hash, expires_at, used_at, created_at`,
+			tokenHash, at,
+		).Scan(
+			&reset.ID,
+			&reset.UserID,
+			&reset.TokenHash,
+			&reset.ExpiresAt,
+			&reset.UsedAt,
+			&reset.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.conn().ExecContext(ctx,
+			`update password_resets set used_at = ?2 where user_id = ?1 and used_at is null`,
+			reset.UserID, at,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &reset, nil
+}
+
+func (m *SQLiteDBRepo) InsertMovie(ctx context.Context, movie models.Movie) (int, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "InsertMovie")
+	defer cancel()
+
+	stmt := `insert into movies (title, description, release_date, runtime,
+			mpaa_rating, image, created_at, updated_at)
+			values (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := m.conn().ExecContext(ctx, stmt,
+		movie.Title,
+		movie.Description,
+		movie.ReleaseDate.Format(sqliteDate),
+		movie.RunTime,
+		movie.MPAARating,
+		movie.Image,
+		movie.CreatedAt,
+		sqliteTime(movie.UpdatedAt),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(newID), nil
+}
+
+func (m *SQLiteDBRepo) UpdateMovie(ctx context.Context, movie models.Movie, version time.Time) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "UpdateMovie")
+	defer cancel()
+
+	stmt := `update movies set title = ?1, description = ?2, release_date = ?3,
+			runtime = ?4, mpaa_rating = ?5, image = ?6, updated_at = ?7
+			where id = ?8 and deleted_at is null and (?9 is null or ` + sqliteSameTime("updated_at", "?9") + `)`
+
+	result, err := m.conn().ExecContext(ctx, stmt,
+		movie.Title,
+		movie.Description,
+		movie.ReleaseDate.Format(sqliteDate),
+		movie.RunTime,
+		movie.MPAARating,
+		movie.Image,
+		sqliteTime(movie.UpdatedAt),
+		movie.ID,
+		sqliteVersionArg(version),
+	)
+	if err != nil {
+		return err
+	}
+
+	return m.checkVersionedChange(ctx, result, movie.ID)
+}
+
+// DeleteMovie moves a movie to the trash
+func (m *SQLiteDBRepo) DeleteMovie(ctx context.Context, id int, version time.Time) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "DeleteMovie")
+	defer cancel()
+
+	stmt := `update movies set deleted_at = ?1, updated_at = ?1 where id = ?2 and deleted_at is null
+			and (?3 is null or ` + sqliteSameTime("updated_at", "?3") + `)`
+
+	result, err := m.conn().ExecContext(ctx, stmt, sqliteTime(time.Now()), id, sqliteVersionArg(version))
+	if err != nil {
+		return err
+	}
+
+	return m.checkVersionedChange(ctx, result, id)
+}
+
+// checkVersionedChange tells why a conditional change of a movie did nothing
+func (m *SQLiteDBRepo) checkVersionedChange(ctx context.Context, result sql.Result, id int) error {
+	err := checkRowsAffected(result)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	var exists bool
+	err = m.conn().QueryRowContext(ctx,
+		`select exists(select 1 from movies where id = ? and deleted_at is null)`, id,
+	).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return repository.ErrVersionMismatch
+	}
+	return sql.ErrNoRows
+}
+
+// sqliteVersionArg is the parameter of a version condition, null when there is none
+func sqliteVersionArg(version time.Time) interface{} {
+	if version.IsZero() {
+		return nil
+	}
+	return sqliteTime(version)
+}
+
+// sqliteSameTime compares the stored timestamp column to the version b, a parameter
+// given by sqliteVersionArg, to the microsecond. Rows written before updated_at was kept
+// in the form of sqliteTime, like the movies of the first migration, can have another
+// offset or number of digits; they are brought to that form first, strftime alone would
+// only keep milliseconds.
+func sqliteSameTime(column, b string) string {
+	return fmt.Sprintf("(%[1]s = %[2]s or %[3]s = %[2]s)", column, b, sqliteMicros(column))
+}
+
+// sqliteMicros is the SQL for a timestamp column in the form of sqliteTime. The digits
+// after the seconds go on until the offset, "+01:00", "-05:00" or "Z", or the end.
+func sqliteMicros(column string) string {
+	fraction := fmt.Sprintf(`case
+			when substr(%[1]s, 20, 1) <> '.' then ''
+			when substr(%[1]s, -6, 1) in ('+', '-') and substr(%[1]s, -3, 1) = ':' then substr(%[1]s, 21, length(%[1]s) - 26)
+			when substr(%[1]s, -1) = 'Z' then substr(%[1]s, 21, length(%[1]s) - 21)
+			else substr(%[1]s, 21)
+		end`, column)
+
+	return fmt.Sprintf("strftime('%%Y-%%m-%%d %%H:%%M:%%S', %s) || '.' || substr((%s) || '000000', 1, 6)", column, fraction)
+}
+
+// LastMovieChange returns when a movie was last created, changed, deleted or restored.
+// Timestamps are text, so the latest one is picked by julianday rather than by max.
+func (m *SQLiteDBRepo) LastMovieChange(ctx context.Context) (time.Time, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "LastMovieChange")
+	defer cancel()
+
+	query := `select updated_at from movies where updated_at is not null
+			order by julianday(updated_at) desc limit 1`
+
+	var last time.Time
+
+	err := m.conn().QueryRowContext(ctx, query).Scan(&last)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, err
+	}
+
+	return last, nil
+}
+
+func (m *SQLiteDBRepo) TrashedMovies(ctx context.Context) ([]*models.Movie, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "TrashedMovies")
+	defer cancel()
+
+	query := `
+		select
+			id, title, release_date, runtime,
+			mpaa_rating, description, coalesce(image, ''),
+			created_at, updated_at, deleted_at
+		from
+			movies
+		where
+			deleted_at is not null
+		order by
+			deleted_at desc, id
+	`
+
+	rows, err := m.conn().QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTrashedMovies(rows)
+}
+
+func (m *SQLiteDBRepo) RestoreMovie(ctx context.Context, id int) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "RestoreMovie")
+	defer cancel()
+
+	stmt := `update movies set deleted_at = null, updated_at = ? where id = ? and deleted_at is not null`
+
+	result, err := m.conn().ExecContext(ctx, stmt, sqliteTime(time.Now()), id)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+func (m *SQLiteDBRepo) PurgeMovie(ctx context.Context, id int) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "PurgeMovie")
+	defer cancel()
+
+	result, err := m.conn().ExecContext(ctx, `delete from movies where id = ? and deleted_at is not null`, id)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+func (m *SQLiteDBRepo) UpdateMovieGenres(ctx context.Context, id int, genreIDs []int) error {
+	ctx, cancel := m.Timeouts.Context(ctx, "UpdateMovieGenres")
+	defer cancel()
+
+	return m.transaction(ctx, func(tx *SQLiteDBRepo) error {
+		_, err := tx.conn().ExecContext(ctx, `delete from movies_genres where movie_id = ?`, id)
+		if err != nil {
+			return err
+		}
+
+		for _, genreID := range genreIDs {
+			stmt := `insert into movies_genres (movie_id, genre_id) values (?, ?)`
+			_, err = tx.conn().ExecContext(ctx, stmt, id, genreID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (m *SQLiteDBRepo) AllGenres(ctx context.Context) ([]*models.Genre, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "AllGenres")
+	defer cancel()
+
+	query := `select id, genre, created_at, updated_at from genres order by genre`
+
+	rows, err := m.conn().QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var genres []*models.Genre
+
+	for rows.Next() {
+		var g models.Genre
+		err := rows.Scan(
+			&g.ID,
+			&g.Genre,
+			&g.CreatedAt,
+			&g.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &g)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+// MovieGenres loads the genres of many movies with a single query, for the lists that
+// show them
+func (m *SQLiteDBRepo) MovieGenres(ctx context.Context, movieIDs []int) (map[int][]*models.Genre, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "MovieGenres")
+	defer cancel()
+
+	genres := make(map[int][]*models.Genre, len(movieIDs))
+	if len(movieIDs) == 0 {
+		return genres, nil
+	}
+
+	var args []interface{}
+	for _, id := range movieIDs {
+		genres[id] = []*models.Genre{}
+		args = append(args, id)
+	}
+	in := strings.TrimSuffix(strings.Repeat("?, ", len(movieIDs)), ", ")
+
+	query := fmt.Sprintf(`
+		select
+			mg.movie_id, g.id, g.genre, g.created_at, g.updated_at
+		from
+			movies_genres mg
+			join genres g on (g.id = mg.genre_id)
+		where
+			mg.movie_id in (%s)
+		order by
+			g.genre
+	`, in)
+
+	rows, err := m.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movieID int
+		var g models.Genre
+		err := rows.Scan(
+			&movieID,
+			&g.ID,
+			&g.Genre,
+			&g.CreatedAt,
+			&g.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		genres[movieID] = append(genres[movieID], &g)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+func (m *SQLiteDBRepo) InsertMovieRevision(ctx context.Context, rev models.MovieRevision) (int, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "InsertMovieRevision")
+	defer cancel()
+
+	changedFields, snapshot, err := marshalRevision(rev)
+	if err != nil {
+		return 0, err
+	}
+
+	var userID interface{}
+	if rev.UserID > 0 {
+		userID = rev.UserID
+	}
+
+	stmt := `
+		insert into movie_revisions
+			(movie_id, revision, action, user_id, changed_fields, snapshot, created_at)
+		values (
+			?1,
+			(select coalesce(max(revision), 0) + 1 from movie_revisions where movie_id = ?1),
+			?2, ?3, ?4, ?5, ?6
+		)
+		returning revision
+	`
+
+	var revision int
+
+	err = m.conn().QueryRowContext(ctx, stmt,
+		rev.MovieID,
+		rev.Action,
+		userID,
+		changedFields,
+		snapshot,
+		rev.CreatedAt,
+	).Scan(&revision)
+	if err != nil {
+		return 0, err
+	}
+
+	return revision, nil
+}
+
+func (m *SQLiteDBRepo) MovieRevisions(ctx context.Context, movieID int) ([]*models.MovieRevision, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "MovieRevisions")
+	defer cancel()
+
+	query := `
+		select` + revisionColumns + `
+		from
+			movie_revisions r
+			left join users u on (u.id = r.user_id)
+		where
+			r.movie_id = ?
+		order by
+			r.revision desc
+	`
+
+	rows, err := m.conn().QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRevisions(rows)
+}
+
+func (m *SQLiteDBRepo) MovieRevision(ctx context.Context, movieID, revision int) (*models.MovieRevision, error) {
+	ctx, cancel := m.Timeouts.Context(ctx, "MovieRevision")
+	defer cancel()
+
+	query := `
+		select` + revisionColumns + `
+		from
+			movie_revisions r
+			left join users u on (u.id = r.user_id)
+		where
+			r.movie_id = ? and r.revision = ?
+	`
+
+	return scanRevision(m.conn().QueryRowContext(ctx, query, movieID, revision))
+}
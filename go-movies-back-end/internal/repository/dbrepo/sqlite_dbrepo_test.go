@@ -0,0 +1,191 @@
+package dbrepo
+
+import (
+	"backend/internal/migrations"
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/repository/memrepo"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// newSQLiteRepo returns a repository on a migrated SQLite database, which has the same
+// fixtures as memrepo.DefaultFixtures
+func newSQLiteRepo(t *testing.T) *SQLiteDBRepo {
+	t.Helper()
+
+	source := filepath.Join(t.TempDir(), "movies.db") + "?_pragma=foreign_keys(1)&_time_format=sqlite"
+	db, err := sql.Open("sqlite", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	m, err := migrations.New(db, migrations.SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &SQLiteDBRepo{DB: db}
+}
+
+// repos returns the SQLite repository and the memory one it is meant to behave like
+func repos(t *testing.T) map[string]repository.DatabaseRepo {
+	t.Helper()
+
+	mem := memrepo.New()
+	err := mem.Seed(memrepo.DefaultFixtures())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]repository.DatabaseRepo{
+		"sqlite": newSQLiteRepo(t),
+		"memory": mem,
+	}
+}
+
+// etagVersion is the version a client sends back for a movie, its updated_at to the
+// microsecond
+func etagVersion(movie *models.Movie) time.Time {
+	return time.UnixMicro(movie.UpdatedAt.UnixMicro()).UTC()
+}
+
+func TestMovieVersions(t *testing.T) {
+	ctx := context.Background()
+
+	for name, repo := range repos(t) {
+		t.Run(name, func(t *testing.T) {
+			movie, err := repo.OneMovie(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// an update stamps the movie with a time that has nanoseconds
+			read := etagVersion(movie)
+			movie.Title = "Highlander II"
+			movie.UpdatedAt = time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.Local)
+			err = repo.UpdateMovie(ctx, *movie, read)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			movie, err = repo.OneMovie(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			version := etagVersion(movie)
+
+			if movie.Title != "Highlander II" || !version.Equal(time.Date(2024, 5, 6, 7, 8, 9, 123456000, time.Local)) {
+				t.Fatalf("movie = %q at %v", movie.Title, movie.UpdatedAt)
+			}
+
+			tests := []struct {
+				name    string
+				version time.Time
+				err     error
+			}{
+				{"a microsecond later", version.Add(time.Microsecond), repository.ErrVersionMismatch},
+				{"a microsecond earlier", version.Add(-time.Microsecond), repository.ErrVersionMismatch},
+				{"the same millisecond", version.Truncate(time.Millisecond), repository.ErrVersionMismatch},
+				{"the version read", version, nil},
+				// the movie has just been changed by the previous case
+				{"the version read again", version, repository.ErrVersionMismatch},
+			}
+
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					movie.UpdatedAt = version.Add(time.Second)
+					err := repo.UpdateMovie(ctx, *movie, tt.version)
+					if !errors.Is(err, tt.err) {
+						t.Errorf("UpdateMovie() = %v, want %v", err, tt.err)
+					}
+				})
+			}
+
+			movie, err = repo.OneMovie(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = repo.DeleteMovie(ctx, 1, version)
+			if !errors.Is(err, repository.ErrVersionMismatch) {
+				t.Errorf("DeleteMovie() with an old version = %v", err)
+			}
+			err = repo.DeleteMovie(ctx, 1, etagVersion(movie))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = repo.DeleteMovie(ctx, 1, time.Time{})
+			if !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("DeleteMovie() of a deleted movie = %v", err)
+			}
+
+			// a zero version changes the movie whatever it is
+			movie, err = repo.OneMovie(ctx, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = repo.UpdateMovie(ctx, *movie, time.Time{})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// the movies the first migration inserted, or those written before updated_at was kept
+// to the microsecond, can have their time in another form
+func TestSQLiteSameTimeOldRows(t *testing.T) {
+	repo := newSQLiteRepo(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		stored  string
+		version time.Time
+		same    bool
+	}{
+		{"2022-09-23 00:00:00", time.Date(2022, 9, 23, 0, 0, 0, 0, time.UTC), true},
+		{"2022-09-23 00:00:00", time.Date(2022, 9, 23, 0, 0, 0, 1000, time.UTC), false},
+		{"2024-05-06 09:08:09.123456789+02:00", time.Date(2024, 5, 6, 7, 8, 9, 123456000, time.UTC), true},
+		{"2024-05-06 09:08:09.1234567+02:00", time.Date(2024, 5, 6, 7, 8, 9, 123457000, time.UTC), false},
+		{"2024-05-06 02:08:09.5-05:00", time.Date(2024, 5, 6, 7, 8, 9, 500000000, time.UTC), true},
+		{"2024-05-06T07:08:09.1234Z", time.Date(2024, 5, 6, 7, 8, 9, 123400000, time.UTC), true},
+		{"2024-05-06 07:08:09.123456", time.Date(2024, 5, 6, 7, 8, 9, 123456000, time.UTC), true},
+		{"2024-05-06 07:08:09.123456", time.Date(2024, 5, 6, 7, 8, 9, 123000000, time.UTC), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s at %s", tt.stored, tt.version.Format(time.RFC3339Nano)), func(t *testing.T) {
+			_, err := repo.DB.ExecContext(ctx, `update movies set updated_at = ? where id = 3`, tt.stored)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			movie, err := repo.OneMovie(ctx, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			movie.UpdatedAt = time.Now()
+			err = repo.UpdateMovie(ctx, *movie, tt.version)
+			if tt.same && err != nil {
+				t.Errorf("UpdateMovie() = %v, want the versions to match", err)
+			}
+			if !tt.same && !errors.Is(err, repository.ErrVersionMismatch) {
+				t.Errorf("UpdateMovie() = %v, want %v", err, repository.ErrVersionMismatch)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package dbrepo
+
+import (
+	"backend/internal/repository"
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// querier is what the repositories run their statements on: the connection pool, or the
+// transaction opened by WithTx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// runInTx begins a transaction, hands it to fn and commits it if fn succeeds. When the
+// database aborts the transaction because it clashed with another one (retryable tells
+// which errors mean that), the whole transaction is run again after a short pause.
+func runInTx(ctx context.Context, db *sql.DB, opts repository.TxOptions, retryable func(error) bool, fn func(tx *sql.Tx) error) error {
+	backoff := opts.FirstBackoff()
+
+	for attempt := 0; ; attempt++ {
+		err := runOnce(ctx, db, opts, fn)
+		if err == nil || !retryable(err) {
+			return err
+		}
+
+		if attempt == opts.RetryCount() {
+			return fmt.Errorf("transaction failed after %d attempts: %w", attempt+1, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func runOnce(ctx context.Context, db *sql.DB, opts repository.TxOptions, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: opts.Isolation})
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,140 @@
+package memrepo
+
+import (
+	"backend/internal/models"
+	"fmt"
+	"time"
+)
+
+// Fixtures is the data a MemoryDBRepo can be seeded with. The genres of a movie are
+// given by id in its GenresArray, just like when a movie is saved through the API.
+type Fixtures struct {
+	Genres []*models.Genre
+	Movies []*models.Movie
+	Users  []*models.User
+}
+
+// Seed loads fixtures into the repository. Rows that come with an id keep it, the
+// others get the next free one.
+func (m *MemoryDBRepo) Seed(f Fixtures) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, genre := range f.Genres {
+		g := *genre
+		m.genres[g.ID] = &g
+	}
+
+	for _, movie := range f.Movies {
+		mv := copyMovie(movie)
+		if mv.ID == 0 {
+			mv.ID = m.nextMovieID
+		}
+		if mv.ID >= m.nextMovieID {
+			m.nextMovieID = mv.ID + 1
+		}
+
+		for _, genreID := range movie.GenresArray {
+			if _, ok := m.genres[genreID]; !ok {
+				return fmt.Errorf("movie %q: genre %d does not exist", mv.Title, genreID)
+			}
+		}
+
+		m.movies[mv.ID] = mv
+		m.movieGenres[mv.ID] = append([]int(nil), movie.GenresArray...)
+	}
+
+	for _, user := range f.Users {
+		u := *user
+		if u.ID == 0 {
+			u.ID = m.nextUserID
+		}
+		if u.ID >= m.nextUserID {
+			m.nextUserID = u.ID + 1
+		}
+
+		m.users[u.ID] = &u
+	}
+
+	return nil
+}
+
+// DefaultFixtures returns the same genres, movies and admin user that
+// sql/create_tables.sql puts into a fresh postgres database. The admin can log in as
+// admin@example.com with the password "secret".
+func DefaultFixtures() Fixtures {
+	created := time.Date(2022, 9, 23, 0, 0, 0, 0, time.UTC)
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	var f Fixtures
+
+	genres := []string{
+		"Comedy", "Sci-Fi", "Horror", "Romance", "Action", "Thriller", "Drama",
+		"Mystery", "Crime", "Animation", "Adventure", "Fantasy", "Superhero",
+	}
+	for i, name := range genres {
+		f.Genres = append(f.Genres, &models.Genre{
+			ID:        i + 1,
+			Genre:     name,
+			CreatedAt: created,
+			UpdatedAt: created,
+		})
+	}
+
+	f.Movies = []*models.Movie{
+		{
+			ID:          1,
+			Title:       "Highlander",
+			ReleaseDate: date(1986, time.March, 7),
+			RunTime:     116,
+			MPAARating:  "R",
+			Description: "He fought his first battle on the Scottish Highlands in 1536. He will fight his greatest battle on the streets of New York City in 1986. His name is Connor MacLeod. He is immortal.",
+			Image:       "/8Z8dptJEypuLoOQro1WugD855YE.jpg",
+			GenresArray: []int{5, 12},
+			CreatedAt:   created,
+			UpdatedAt:   created,
+		},
+		{
+			ID:          2,
+			Title:       "Raiders of the Lost Ark",
+			ReleaseDate: date(1981, time.June, 12),
+			RunTime:     115,
+			MPAARating:  "PG-13",
+			Description: "Archaeology professor Indiana Jones ventures to seize a biblical artefact known as the Ark of the Covenant. While doing so, he puts up a fight against Renee and a troop of Nazis.",
+			Image:       "/ceG9VzoRAVGwivFU403Wc3AHRys.jpg",
+			GenresArray: []int{5, 11},
+			CreatedAt:   created,
+			UpdatedAt:   created,
+		},
+		{
+			ID:          3,
+			Title:       "The Godfather",
+			ReleaseDate: date(1972, time.March, 24),
+			RunTime:     175,
+			MPAARating:  "18A",
+			Description: "The aging patriarch of an organized crime dynasty in postwar New York City transfers control of his clandestine empire to his reluctant youngest son.",
+			Image:       "/3bhkrj58Vtu7enYsRolD1fZdja1.jpg",
+			GenresArray: []int{9, 7},
+			CreatedAt:   created,
+			UpdatedAt:   created,
+		},
+	}
+
+	f.Users = []*models.User{
+		{
+			ID:            1,
+			FirstName:     "Admin",
+			LastName:      "User",
+			Email:         "admin@example.com",
+			Password:      "$2a$14$wVsaPvJnJJsomWArouWCtusem6S/.Gauq/GjOIEHpyh2DAMmso1wy",
+			EmailVerified: true,
+			IsAdmin:       true,
+			CreatedAt:     created,
+			UpdatedAt:     created,
+		},
+	}
+
+	return f
+}
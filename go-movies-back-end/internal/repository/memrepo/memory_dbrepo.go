@@ -0,0 +1,1052 @@
+package memrepo
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/textsearch"
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryDBRepo is a DatabaseRepo that keeps everything in memory. It is meant for tests
+// and for running the API without a database. It is safe for concurrent use, and like
+// PostgresDBRepo it answers sql.ErrNoRows when something can't be found, so handlers
+// behave the same with both. Nothing here blocks, so the context is only checked for
+// having been cancelled before a method starts.
+type MemoryDBRepo struct {
+	mu sync.RWMutex
+
+	movies      map[int]*models.Movie
+	genres      map[int]*models.Genre
+	users       map[int]*models.User
+	movieGenres map[int][]int                   // movie id -> genre ids, the movies_genres table
+	revisions   map[int][]*models.MovieRevision // movie id -> its revisions, oldest first
+	resets      map[int]*models.PasswordReset
+	refresh     map[string]*models.RefreshToken // token id -> token
+
+	nextMovieID    int
+	nextUserID     int
+	nextRevisionID int
+	nextResetID    int
+}
+
+// New returns an empty repository. Use Seed to load fixtures into it.
+func New() *MemoryDBRepo {
+	return &MemoryDBRepo{
+		movies:         make(map[int]*models.Movie),
+		genres:         make(map[int]*models.Genre),
+		users:          make(map[int]*models.User),
+		movieGenres:    make(map[int][]int),
+		revisions:      make(map[int][]*models.MovieRevision),
+		resets:         make(map[int]*models.PasswordReset),
+		refresh:        make(map[string]*models.RefreshToken),
+		nextMovieID:    1,
+		nextUserID:     1,
+		nextRevisionID: 1,
+		nextResetID:    1,
+	}
+}
+
+// Connection returns nil, there is no database behind this repository
+func (m *MemoryDBRepo) Connection() *sql.DB {
+	return nil
+}
+
+// WithTx runs fn against a copy of the repository and puts the copy in place of the
+// current data only when fn succeeds. The repository stays locked meanwhile, so
+// transactions are serializable and never need to be retried.
+func (m *MemoryDBRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx := m.clone()
+
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+
+	// fn may have been cancelled half way without noticing, don't commit then
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.movies = tx.movies
+	m.genres = tx.genres
+	m.users = tx.users
+	m.movieGenres = tx.movieGenres
+	m.revisions = tx.revisions
+	m.resets = tx.resets
+	m.refresh = tx.refresh
+	m.nextMovieID = tx.nextMovieID
+	m.nextUserID = tx.nextUserID
+	m.nextRevisionID = tx.nextRevisionID
+	m.nextResetID = tx.nextResetID
+
+	return nil
+}
+
+// clone copies the data of the repository. Stored values are replaced and never changed
+// in place, so copying the maps is enough for the copy to be independent.
+func (m *MemoryDBRepo) clone() *MemoryDBRepo {
+	c := New()
+
+	for id, movie := range m.movies {
+		c.movies[id] = movie
+	}
+	for id, genre := range m.genres {
+		c.genres[id] = genre
+	}
+	for id, user := range m.users {
+		c.users[id] = user
+	}
+	for id, genreIDs := range m.movieGenres {
+		c.movieGenres[id] = genreIDs
+	}
+	// revisions are appended to, so the slices must not be shared
+	for id, revisions := range m.revisions {
+		c.revisions[id] = append([]*models.MovieRevision(nil), revisions...)
+	}
+	for id, reset := range m.resets {
+		c.resets[id] = reset
+	}
+	for id, token := range m.refresh {
+		c.refresh[id] = token
+	}
+
+	c.nextMovieID = m.nextMovieID
+	c.nextUserID = m.nextUserID
+	c.nextRevisionID = m.nextRevisionID
+	c.nextResetID = m.nextResetID
+
+	return c
+}
+
+func (m *MemoryDBRepo) AllMovies(ctx context.Context, opts repository.MovieListOptions) (*repository.MoviePage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sortBy := opts.SortColumn()
+	if !repository.ValidSort(sortBy) {
+		return nil, fmt.Errorf("cannot sort movies by %q", sortBy)
+	}
+
+	var after *repository.Cursor
+	if opts.After != "" {
+		cursor, err := repository.DecodeCursor(opts.After, sortBy)
+		if err != nil {
+			return nil, err
+		}
+		after = &cursor
+	}
+
+	ratings := make(map[string]bool)
+	for _, r := range opts.MPAARatings {
+		ratings[r] = true
+	}
+
+	title := strings.ToLower(opts.TitleContains)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var movies []*models.Movie
+
+	for _, movie := range m.movies {
+		year := movie.ReleaseDate.Year()
+
+		switch {
+		case movie.DeletedAt != nil:
+			continue
+		case len(ratings) > 0 && !ratings[movie.MPAARating]:
+			continue
+		case opts.YearFrom > 0 && year < opts.YearFrom:
+			continue
+		case opts.YearTo > 0 && year > opts.YearTo:
+			continue
+		case opts.RuntimeMin > 0 && movie.RunTime < opts.RuntimeMin:
+			continue
+		case opts.RuntimeMax > 0 && movie.RunTime > opts.RuntimeMax:
+			continue
+		case title != "" && !strings.Contains(strings.ToLower(movie.Title), title):
+			continue
+		}
+
+		if after != nil {
+			c := compareToCursor(movie, *after)
+			if (!opts.Desc && c <= 0) || (opts.Desc && c >= 0) {
+				continue
+			}
+		}
+
+		movies = append(movies, copyMovie(movie))
+	}
+
+	sort.Slice(movies, func(i, j int) bool {
+		c := compareToCursor(movies[i], repository.MovieCursor(movies[j], sortBy))
+		if opts.Desc {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	if opts.Limit > 0 && len(movies) > opts.Limit+1 {
+		movies = movies[:opts.Limit+1]
+	}
+
+	return repository.NewMoviePage(movies, opts), nil
+}
+
+// compareToCursor orders a movie against the position a cursor points at, first by the
+// sort column and then by id, the same order the postgres repository uses.
+func compareToCursor(movie *models.Movie, cursor repository.Cursor) int {
+	var c int
+
+	switch cursor.Sort {
+	case repository.SortByReleaseDate:
+		// dates formatted as yyyy-mm-dd sort the same way as the dates themselves
+		c = strings.Compare(movie.ReleaseDate.Format("2006-01-02"), cursor.Value)
+	case repository.SortByRuntime:
+		n, _ := strconv.Atoi(cursor.Value)
+		c = movie.RunTime - n
+	default:
+		c = strings.Compare(movie.Title, cursor.Value)
+	}
+
+	if c == 0 {
+		c = movie.ID - cursor.ID
+	}
+
+	return c
+}
+
+func (m *MemoryDBRepo) AllMoviesByGenre(ctx context.Context, genreID int) ([]*models.Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var movies []*models.Movie
+
+	for id, genreIDs := range m.movieGenres {
+		if m.movies[id].DeletedAt != nil {
+			continue
+		}
+
+		for _, g := range genreIDs {
+			if g == genreID {
+				movies = append(movies, copyMovie(m.movies[id]))
+				break
+			}
+		}
+	}
+
+	sortByTitle(movies)
+
+	return movies, nil
+}
+
+func (m *MemoryDBRepo) OneMovie(ctx context.Context, id int) (*models.Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	movie, ok := m.live(id)
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	out := copyMovie(movie)
+	out.Genres = m.genresOf(id)
+
+	return out, nil
+}
+
+// ExportMovies hands every movie to fn in id order. The movies are copied first, so
+// that a slow fn doesn't keep the repository locked.
+func (m *MemoryDBRepo) ExportMovies(ctx context.Context, fn func(movie *models.Movie) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	movies := make([]*models.Movie, 0, len(m.movies))
+	for id, movie := range m.movies {
+		if movie.DeletedAt != nil {
+			continue
+		}
+
+		out := copyMovie(movie)
+		out.Genres = m.genresOf(id)
+		movies = append(movies, out)
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(movies, func(i, j int) bool {
+		return movies[i].ID < movies[j].ID
+	})
+
+	for _, movie := range movies {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := fn(movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// genresOf returns the genres of a movie sorted by name. The caller must hold the lock.
+func (m *MemoryDBRepo) genresOf(movieID int) []*models.Genre {
+	genres := []*models.Genre{}
+
+	for _, id := range m.movieGenres[movieID] {
+		g := *m.genres[id]
+		genres = append(genres, &g)
+	}
+
+	sort.Slice(genres, func(i, j int) bool {
+		return genres[i].Genre < genres[j].Genre
+	})
+
+	return genres
+}
+
+// SearchMovies looks for movies having every word of the query in their title or their
+// description. There is no stemming here, a word only has to start with what was typed,
+// so the language of the options is accepted but makes no difference.
+func (m *MemoryDBRepo) SearchMovies(ctx context.Context, query string, opts repository.SearchOptions) ([]*repository.SearchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if !repository.ValidSearchLanguage(opts.LanguageOrDefault()) {
+		return nil, fmt.Errorf("unsupported search language %q", opts.Language)
+	}
+
+	terms := textsearch.Terms(query)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var results []*repository.SearchResult
+
+	for _, movie := range m.movies {
+		if movie.DeletedAt != nil {
+			continue
+		}
+
+		// like the weights of the postgres search, the title counts more than the description
+		rank := textsearch.Match(terms, movie.Title+" "+movie.Description)
+		if rank == 0 {
+			continue
+		}
+		rank += textsearch.Match(terms, movie.Title)
+
+		results = append(results, &repository.SearchResult{
+			Movie:          copyMovie(movie),
+			Rank:           rank,
+			TitleHighlight: textsearch.Highlight(movie.Title, terms),
+			Snippet:        textsearch.Snippet(movie.Description, terms, 25),
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return results[i].Movie.ID < results[j].Movie.ID
+	})
+
+	return paginate(results, opts.Offset, opts.Limit), nil
+}
+
+func (m *MemoryDBRepo) SuggestTitles(ctx context.Context, prefix string, limit int) ([]*repository.Suggestion, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	lowerPrefix := strings.ToLower(prefix)
+
+	type scored struct {
+		suggestion *repository.Suggestion
+		isPrefix   bool
+		score      float64
+	}
+
+	m.mu.RLock()
+
+	var candidates []scored
+
+	for _, movie := range m.movies {
+		if movie.DeletedAt != nil {
+			continue
+		}
+
+		isPrefix := strings.HasPrefix(strings.ToLower(movie.Title), lowerPrefix)
+		score := textsearch.WordSimilarity(prefix, movie.Title)
+
+		if isPrefix || score >= textsearch.SimilarityThreshold {
+			candidates = append(candidates, scored{
+				suggestion: &repository.Suggestion{ID: movie.ID, Title: movie.Title},
+				isPrefix:   isPrefix,
+				score:      score,
+			})
+		}
+	}
+
+	m.mu.RUnlock()
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		switch {
+		case a.isPrefix != b.isPrefix:
+			return a.isPrefix
+		case a.score != b.score:
+			return a.score > b.score
+		default:
+			return a.suggestion.Title < b.suggestion.Title
+		}
+	})
+
+	var suggestions []*repository.Suggestion
+	for _, c := range paginate(candidates, 0, limit) {
+		suggestions = append(suggestions, c.suggestion)
+	}
+
+	return suggestions, nil
+}
+
+func (m *MemoryDBRepo) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if strings.EqualFold(user.Email, email) {
+			u := *user
+			return &u, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryDBRepo) GetUserByID(ctx context.Context, id int) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	u := *user
+	return &u, nil
+}
+
+func (m *MemoryDBRepo) CreateUser(ctx context.Context, user models.User) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if strings.EqualFold(u.Email, user.Email) {
+			return 0, repository.ErrDuplicateEmail
+		}
+	}
+
+	user.ID = m.nextUserID
+	m.nextUserID++
+
+	m.users[user.ID] = &user
+
+	return user.ID, nil
+}
+
+func (m *MemoryDBRepo) VerifyUserEmail(ctx context.Context, id int, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	u := *user
+	u.EmailVerified = true
+	u.UpdatedAt = at
+	m.users[id] = &u
+
+	return nil
+}
+
+func (m *MemoryDBRepo) UpdateUserPassword(ctx context.Context, id int, password string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	u := *user
+	u.Password = password
+	u.UpdatedAt = at
+	m.users[id] = &u
+
+	return nil
+}
+
+func (m *MemoryDBRepo) RevokeUserTokens(ctx context.Context, id int, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	u := *user
+	u.TokensRevokedAt = &at
+	m.users[id] = &u
+
+	for tokenID, token := range m.refresh {
+		if token.UserID == id && token.RevokedAt == nil {
+			t := *token
+			t.RevokedAt = &at
+			m.refresh[tokenID] = &t
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryDBRepo) InsertRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.refresh[token.ID]; ok {
+		return fmt.Errorf("refresh token %s already exists", token.ID)
+	}
+
+	token.UsedAt = nil
+	token.RevokedAt = nil
+	m.refresh[token.ID] = &token
+
+	return nil
+}
+
+func (m *MemoryDBRepo) GetRefreshToken(ctx context.Context, id string) (*models.RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	token, ok := m.refresh[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	t := *token
+	return &t, nil
+}
+
+func (m *MemoryDBRepo) UseRefreshToken(ctx context.Context, id string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, ok := m.refresh[id]
+	if !ok || token.UsedAt != nil || token.RevokedAt != nil || !token.ExpiresAt.After(at) {
+		return sql.ErrNoRows
+	}
+
+	t := *token
+	t.UsedAt = &at
+	m.refresh[id] = &t
+
+	return nil
+}
+
+func (m *MemoryDBRepo) RevokeRefreshFamily(ctx context.Context, familyID string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, token := range m.refresh {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			t := *token
+			t.RevokedAt = &at
+			m.refresh[id] = &t
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryDBRepo) PurgeRefreshTokens(ctx context.Context, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var purged int64
+	for id, token := range m.refresh {
+		if token.ExpiresAt.Before(before) {
+			delete(m.refresh, id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+func (m *MemoryDBRepo) InsertPasswordReset(ctx context.Context, reset models.PasswordReset) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reset.ID = m.nextResetID
+	reset.UsedAt = nil
+	m.nextResetID++
+
+	m.resets[reset.ID] = &reset
+
+	return reset.ID, nil
+}
+
+func (m *MemoryDBRepo) UsePasswordReset(ctx context.Context, tokenHash string, at time.Time) (*models.PasswordReset, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var found *models.PasswordReset
+	for _, reset := range m.resets {
+		if reset.TokenHash == tokenHash && reset.UsedAt == nil && reset.ExpiresAt.After(at) {
+			found = reset
+			break
+		}
+	}
+	if found == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	for id, reset := range m.resets {
+		if reset.UserID == found.UserID && reset.UsedAt == nil {
+			r := *reset
+			r.UsedAt = &at
+			m.resets[id] = &r
+		}
+	}
+
+	r := *m.resets[found.ID]
+	return &r, nil
+}
+
+func (m *MemoryDBRepo) InsertMovie(ctx context.Context, movie models.Movie) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	movie.ID = m.nextMovieID
+	movie.DeletedAt = nil
+	m.nextMovieID++
+
+	m.movies[movie.ID] = copyMovie(&movie)
+
+	return movie.ID, nil
+}
+
+func (m *MemoryDBRepo) UpdateMovie(ctx context.Context, movie models.Movie, version time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.live(movie.ID)
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	if !sameVersion(existing, version) {
+		return repository.ErrVersionMismatch
+	}
+
+	// created_at is never touched by an update
+	movie.CreatedAt = existing.CreatedAt
+	movie.DeletedAt = nil
+	m.movies[movie.ID] = copyMovie(&movie)
+
+	return nil
+}
+
+// DeleteMovie moves a movie to the trash
+func (m *MemoryDBRepo) DeleteMovie(ctx context.Context, id int, version time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	movie, ok := m.live(id)
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	if !sameVersion(movie, version) {
+		return repository.ErrVersionMismatch
+	}
+
+	deleted := copyMovie(movie)
+	now := time.Now().UTC()
+	deleted.DeletedAt = &now
+	deleted.UpdatedAt = now
+	m.movies[id] = deleted
+
+	return nil
+}
+
+// LastMovieChange returns when a movie was last created, changed, deleted or restored
+func (m *MemoryDBRepo) LastMovieChange(ctx context.Context) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var last time.Time
+	for _, movie := range m.movies {
+		if movie.UpdatedAt.After(last) {
+			last = movie.UpdatedAt
+		}
+	}
+
+	return last, nil
+}
+
+func (m *MemoryDBRepo) TrashedMovies(ctx context.Context) ([]*models.Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	movies := []*models.Movie{}
+	for _, movie := range m.movies {
+		if movie.DeletedAt != nil {
+			movies = append(movies, copyMovie(movie))
+		}
+	}
+
+	sort.Slice(movies, func(i, j int) bool {
+		if !movies[i].DeletedAt.Equal(*movies[j].DeletedAt) {
+			return movies[i].DeletedAt.After(*movies[j].DeletedAt)
+		}
+		return movies[i].ID < movies[j].ID
+	})
+
+	return movies, nil
+}
+
+func (m *MemoryDBRepo) RestoreMovie(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	movie, ok := m.movies[id]
+	if !ok || movie.DeletedAt == nil {
+		return sql.ErrNoRows
+	}
+
+	restored := copyMovie(movie)
+	restored.DeletedAt = nil
+	restored.UpdatedAt = time.Now().UTC()
+	m.movies[id] = restored
+
+	return nil
+}
+
+func (m *MemoryDBRepo) PurgeMovie(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	movie, ok := m.movies[id]
+	if !ok || movie.DeletedAt == nil {
+		return sql.ErrNoRows
+	}
+
+	delete(m.movies, id)
+	delete(m.movieGenres, id)
+	delete(m.revisions, id)
+
+	return nil
+}
+
+// live returns a movie that is not in the trash. The caller must hold the lock.
+func (m *MemoryDBRepo) live(id int) (*models.Movie, bool) {
+	movie, ok := m.movies[id]
+	if !ok || movie.DeletedAt != nil {
+		return nil, false
+	}
+	return movie, true
+}
+
+// sameVersion tells whether a movie still has the version a caller read. Versions come
+// back from clients to the microsecond, the precision postgres keeps.
+func sameVersion(movie *models.Movie, version time.Time) bool {
+	return version.IsZero() || movie.UpdatedAt.Truncate(time.Microsecond).Equal(version)
+}
+
+func (m *MemoryDBRepo) UpdateMovieGenres(ctx context.Context, id int, genreIDs []int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.movies[id]; !ok {
+		return sql.ErrNoRows
+	}
+
+	// check every genre before changing anything, so that the update is all or nothing
+	for _, genreID := range genreIDs {
+		if _, ok := m.genres[genreID]; !ok {
+			return fmt.Errorf("genre %d does not exist", genreID)
+		}
+	}
+
+	m.movieGenres[id] = append([]int(nil), genreIDs...)
+
+	return nil
+}
+
+func (m *MemoryDBRepo) AllGenres(ctx context.Context) ([]*models.Genre, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var genres []*models.Genre
+	for _, genre := range m.genres {
+		g := *genre
+		genres = append(genres, &g)
+	}
+
+	sort.Slice(genres, func(i, j int) bool {
+		return genres[i].Genre < genres[j].Genre
+	})
+
+	return genres, nil
+}
+
+func (m *MemoryDBRepo) MovieGenres(ctx context.Context, movieIDs []int) (map[int][]*models.Genre, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	genres := make(map[int][]*models.Genre, len(movieIDs))
+	for _, id := range movieIDs {
+		genres[id] = m.genresOf(id)
+	}
+
+	return genres, nil
+}
+
+// copyMovie returns a copy of a movie that shares nothing with the stored one, so that
+// callers can't change what is in the repository behind its back.
+func copyMovie(movie *models.Movie) *models.Movie {
+	out := *movie
+	out.Genres = nil
+	out.GenresArray = nil
+	if movie.DeletedAt != nil {
+		deletedAt := *movie.DeletedAt
+		out.DeletedAt = &deletedAt
+	}
+	return &out
+}
+
+func sortByTitle(movies []*models.Movie) {
+	sort.Slice(movies, func(i, j int) bool {
+		if movies[i].Title != movies[j].Title {
+			return movies[i].Title < movies[j].Title
+		}
+		return movies[i].ID < movies[j].ID
+	})
+}
+
+// paginate applies an offset and a limit (zero means no limit) to a slice
+func paginate[T any](items []T, offset, limit int) []T {
+	if offset >= len(items) {
+		return nil
+	}
+	items = items[offset:]
+
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+
+	return items
+}
+
+func (m *MemoryDBRepo) InsertMovieRevision(ctx context.Context, rev models.MovieRevision) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.movies[rev.MovieID]; !ok {
+		return 0, fmt.Errorf("movie %d does not exist", rev.MovieID)
+	}
+
+	rev.ID = m.nextRevisionID
+	m.nextRevisionID++
+	rev.Revision = len(m.revisions[rev.MovieID]) + 1
+	rev.UserEmail = ""
+
+	m.revisions[rev.MovieID] = append(m.revisions[rev.MovieID], copyRevision(&rev))
+
+	return rev.Revision, nil
+}
+
+func (m *MemoryDBRepo) MovieRevisions(ctx context.Context, movieID int) ([]*models.MovieRevision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	revisions := []*models.MovieRevision{}
+
+	stored := m.revisions[movieID]
+	for i := len(stored) - 1; i >= 0; i-- {
+		revisions = append(revisions, m.withUser(stored[i]))
+	}
+
+	return revisions, nil
+}
+
+func (m *MemoryDBRepo) MovieRevision(ctx context.Context, movieID, revision int) (*models.MovieRevision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored := m.revisions[movieID]
+	if revision < 1 || revision > len(stored) {
+		return nil, sql.ErrNoRows
+	}
+
+	return m.withUser(stored[revision-1]), nil
+}
+
+// withUser copies a revision and fills in the email of whoever made it, like the join
+// with users does in the databases. The caller must hold the lock.
+func (m *MemoryDBRepo) withUser(rev *models.MovieRevision) *models.MovieRevision {
+	out := copyRevision(rev)
+	if user, ok := m.users[rev.UserID]; ok {
+		out.UserEmail = user.Email
+	}
+	return out
+}
+
+func copyRevision(rev *models.MovieRevision) *models.MovieRevision {
+	out := *rev
+	out.ChangedFields = append([]string{}, rev.ChangedFields...)
+
+	out.Snapshot.Genres = nil
+	for _, g := range rev.Snapshot.Genres {
+		genre := *g
+		out.Snapshot.Genres = append(out.Snapshot.Genres, &genre)
+	}
+
+	return &out
+}
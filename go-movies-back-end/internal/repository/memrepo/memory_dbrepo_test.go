@@ -0,0 +1,304 @@
+package memrepo
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func seeded(t *testing.T) *MemoryDBRepo {
+	t.Helper()
+
+	repo := New()
+	err := repo.Seed(DefaultFixtures())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repo
+}
+
+func TestDefaultFixtures(t *testing.T) {
+	ctx := context.Background()
+	repo := seeded(t)
+
+	page, err := repo.AllMovies(ctx, repository.MovieListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var titles []string
+	for _, movie := range page.Movies {
+		titles = append(titles, movie.Title)
+	}
+	if fmt.Sprint(titles) != "[Highlander Raiders of the Lost Ark The Godfather]" {
+		t.Errorf("movies are %v", titles)
+	}
+
+	movie, err := repo.OneMovie(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movie.Genres) != 2 || movie.Genres[0].Genre != "Crime" || movie.Genres[1].Genre != "Drama" {
+		t.Errorf("genres of The Godfather are %v, want Crime and Drama in that order", movie.Genres)
+	}
+
+	genres, err := repo.AllGenres(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(genres) != 13 {
+		t.Errorf("%d genres, want 13", len(genres))
+	}
+
+	// emails are matched whatever their case, like the unique index of the databases
+	admin, err := repo.GetUserByEmail(ctx, "Admin@Example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !admin.IsAdmin || !admin.EmailVerified {
+		t.Errorf("the admin isn't a verified admin: %+v", admin)
+	}
+	if ok, err := admin.PasswordMatches("secret"); !ok || err != nil {
+		t.Errorf(`the admin password isn't "secret": %v`, err)
+	}
+}
+
+func TestSeedUnknownGenre(t *testing.T) {
+	err := New().Seed(Fixtures{Movies: []*models.Movie{{Title: "Nowhere", GenresArray: []int{42}}}})
+	if err == nil {
+		t.Error("a movie with a genre that doesn't exist was seeded")
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	ctx := context.Background()
+	repo := seeded(t)
+
+	_, err := repo.OneMovie(ctx, 99)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("OneMovie(99) = %v, want sql.ErrNoRows", err)
+	}
+
+	_, err = repo.GetUserByID(ctx, 99)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserByID(99) = %v, want sql.ErrNoRows", err)
+	}
+}
+
+// the movies handed out are copies, changing them doesn't change the repository
+func TestReturnsCopies(t *testing.T) {
+	ctx := context.Background()
+	repo := seeded(t)
+
+	movie, err := repo.OneMovie(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	movie.Title = "changed"
+	movie.Genres[0].Genre = "changed"
+
+	again, err := repo.OneMovie(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Title != "Highlander" || again.Genres[0].Genre != "Action" {
+		t.Errorf("the stored movie changed: %q, %q", again.Title, again.Genres[0].Genre)
+	}
+}
+
+func TestWithTxRollsBack(t *testing.T) {
+	ctx := context.Background()
+	repo := seeded(t)
+	failed := errors.New("failed")
+
+	err := repo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		_, err := tx.InsertMovie(ctx, models.Movie{Title: "Lost"})
+		if err != nil {
+			return err
+		}
+
+		err = tx.DeleteMovie(ctx, 1, time.Time{})
+		if err != nil {
+			return err
+		}
+
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("WithTx = %v, want the error of fn", err)
+	}
+
+	page, err := repo.AllMovies(ctx, repository.MovieListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Movies) != 3 {
+		t.Errorf("%d movies after a rollback, want 3", len(page.Movies))
+	}
+	if page.Movies[0].Title != "Highlander" {
+		t.Errorf("the delete wasn't rolled back, the first movie is %q", page.Movies[0].Title)
+	}
+}
+
+func TestUpdateMovieVersion(t *testing.T) {
+	ctx := context.Background()
+	repo := seeded(t)
+
+	movie, err := repo.OneMovie(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version := movie.UpdatedAt
+	movie.RunTime = 120
+	movie.UpdatedAt = version.Add(time.Hour)
+
+	err = repo.UpdateMovie(ctx, *movie, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second writer read the movie before the first one saved it
+	err = repo.UpdateMovie(ctx, *movie, version)
+	if !errors.Is(err, repository.ErrVersionMismatch) {
+		t.Errorf("UpdateMovie with a stale version = %v, want ErrVersionMismatch", err)
+	}
+}
+
+// Run with -race: every method may be called from many requests at once
+func TestConcurrentUse(t *testing.T) {
+	ctx := context.Background()
+	repo := seeded(t)
+
+	const writers = 20
+	ids := make(chan int, writers*2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			id, err := repo.InsertMovie(ctx, models.Movie{Title: fmt.Sprintf("Movie %02d", i)})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ids <- id
+
+			// a transaction sees its own writes and nobody else's half done ones
+			err = repo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+				id, err := tx.InsertMovie(ctx, models.Movie{Title: fmt.Sprintf("Tx movie %02d", i)})
+				if err != nil {
+					return err
+				}
+				ids <- id
+
+				return tx.UpdateMovieGenres(ctx, id, []int{1, 2})
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := repo.AllMovies(ctx, repository.MovieListOptions{Limit: 5})
+			if err != nil {
+				t.Error(err)
+			}
+			_, err = repo.OneMovie(ctx, 1)
+			if err != nil {
+				t.Error(err)
+			}
+			_, err = repo.MovieGenres(ctx, []int{1, 2, 3})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[int]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("id %d was given twice", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != writers*2 {
+		t.Errorf("%d movies inserted, want %d", len(seen), writers*2)
+	}
+
+	page, err := repo.AllMovies(ctx, repository.MovieListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Movies) != 3+writers*2 {
+		t.Errorf("%d movies, want %d", len(page.Movies), 3+writers*2)
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	repo := seeded(t)
+
+	_, err := repo.AllMovies(ctx, repository.MovieListOptions{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("AllMovies = %v, want context.Canceled", err)
+	}
+
+	err = repo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		t.Error("fn ran with a cancelled context")
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("WithTx = %v, want context.Canceled", err)
+	}
+}
+
+func TestAllMoviesFilters(t *testing.T) {
+	ctx := context.Background()
+	repo := seeded(t)
+
+	tests := []struct {
+		name string
+		opts repository.MovieListOptions
+		want string
+	}{
+		{"title", repository.MovieListOptions{TitleContains: "GOD"}, "[The Godfather]"},
+		{"rating", repository.MovieListOptions{MPAARatings: []string{"R", "PG-13"}}, "[Highlander Raiders of the Lost Ark]"},
+		{"years", repository.MovieListOptions{YearFrom: 1980, YearTo: 1985}, "[Raiders of the Lost Ark]"},
+		{"runtime", repository.MovieListOptions{RuntimeMin: 116}, "[Highlander The Godfather]"},
+		{"sorted", repository.MovieListOptions{Sort: repository.SortByReleaseDate, Desc: true}, "[Highlander Raiders of the Lost Ark The Godfather]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := repo.AllMovies(ctx, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var titles []string
+			for _, movie := range page.Movies {
+				titles = append(titles, movie.Title)
+			}
+			if got := fmt.Sprint(titles); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package repository
+
+import (
+	"backend/internal/models"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// The columns a movie list can be sorted by
+const (
+	SortByTitle       = "title"
+	SortByReleaseDate = "release_date"
+	SortByRuntime     = "runtime"
+)
+
+// ErrInvalidCursor is returned when the "after" cursor can't be decoded or was issued
+// for a different sort order than the one being requested.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// The sizes of a page of movies, in the REST and the GraphQL listings alike
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// MovieListOptions describes one page of a movie listing. The zero value lists every
+// movie sorted by title.
+type MovieListOptions struct {
+	After string // cursor returned as NextCursor by the previous page
+	Limit int    // zero means no limit
+
+	Sort string // one of the SortBy constants, defaults to SortByTitle
+	Desc bool
+
+	MPAARatings []string
+	YearFrom    int
+	YearTo      int
+	RuntimeMin  int
+	RuntimeMax  int
+
+	TitleContains string // ignoring case
+}
+
+// MoviePage is one page of a movie listing. NextCursor is empty on the last page.
+type MoviePage struct {
+	Movies     []*models.Movie `json:"movies"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// SortColumn returns the column to sort by, falling back to the title.
+func (o MovieListOptions) SortColumn() string {
+	if o.Sort == "" {
+		return SortByTitle
+	}
+	return o.Sort
+}
+
+// ValidSort reports whether the given column is one we know how to sort by.
+func ValidSort(sort string) bool {
+	switch sort {
+	case SortByTitle, SortByReleaseDate, SortByRuntime:
+		return true
+	}
+	return false
+}
+
+// Cursor is the position of the last movie of a page. Pages are ordered by the sort
+// column and then by id, so the pair always points at exactly one movie.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// MovieCursor returns the cursor pointing at the given movie for the given sort.
+func MovieCursor(movie *models.Movie, sort string) Cursor {
+	c := Cursor{Sort: sort, ID: movie.ID}
+
+	switch sort {
+	case SortByReleaseDate:
+		c.Value = movie.ReleaseDate.Format("2006-01-02")
+	case SortByRuntime:
+		c.Value = strconv.Itoa(movie.RunTime)
+	default:
+		c.Value = movie.Title
+	}
+
+	return c
+}
+
+// NewMoviePage builds a page out of the movies a repository loaded for opts. Repositories
+// fetch one movie more than opts.Limit: if that extra movie is there, there is a next page
+// and the cursor points at the last movie that is actually returned.
+func NewMoviePage(movies []*models.Movie, opts MovieListOptions) *MoviePage {
+	page := &MoviePage{Movies: movies}
+
+	if opts.Limit > 0 && len(movies) > opts.Limit {
+		page.Movies = movies[:opts.Limit]
+		page.NextCursor = EncodeCursor(MovieCursor(page.Movies[opts.Limit-1], opts.SortColumn()))
+	}
+
+	if page.Movies == nil {
+		page.Movies = []*models.Movie{}
+	}
+
+	return page
+}
+
+// EncodeCursor turns a cursor into the opaque string handed out to clients.
+func EncodeCursor(c Cursor) string {
+	out, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(out)
+}
+
+// DecodeCursor reverses EncodeCursor and checks that the cursor belongs to the given sort.
+func DecodeCursor(s, sort string) (Cursor, error) {
+	var c Cursor
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	err = json.Unmarshal(b, &c)
+	if err != nil || c.Sort != sort {
+		return c, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+// DefaultSearchLanguage is the text search configuration used when none is asked for
+const DefaultSearchLanguage = "english"
+
+// searchLanguages are the languages SearchMovies knows how to stem. The names are the
+// ones of the text search configurations that ship with postgres.
+var searchLanguages = map[string]bool{
+	"simple":     true,
+	"danish":     true,
+	"dutch":      true,
+	"english":    true,
+	"finnish":    true,
+	"french":     true,
+	"german":     true,
+	"hungarian":  true,
+	"italian":    true,
+	"norwegian":  true,
+	"portuguese": true,
+	"romanian":   true,
+	"russian":    true,
+	"spanish":    true,
+	"swedish":    true,
+	"turkish":    true,
+}
+
+// ValidSearchLanguage reports whether SearchMovies supports the given language.
+func ValidSearchLanguage(language string) bool {
+	return searchLanguages[language]
+}
+
+// SearchOptions controls a full text search. The zero value searches in english and
+// returns every match.
+type SearchOptions struct {
+	Language string // one of the languages accepted by ValidSearchLanguage
+	Limit    int    // zero means no limit
+	Offset   int
+}
+
+// LanguageOrDefault returns the language to search in, falling back to english.
+func (o SearchOptions) LanguageOrDefault() string {
+	if o.Language == "" {
+		return DefaultSearchLanguage
+	}
+	return o.Language
+}
+
+// SearchResult is one movie matching a full text search. The highlights are HTML: the title
+// and parts of the description, escaped, with every matching word wrapped in <mark></mark>.
+type SearchResult struct {
+	Movie          *models.Movie `json:"movie"`
+	Rank           float64       `json:"rank"`
+	TitleHighlight string        `json:"title_highlight"`
+	Snippet        string        `json:"snippet"`
+}
+
+// Suggestion is one title completion offered while the user is still typing
+type Suggestion struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+}
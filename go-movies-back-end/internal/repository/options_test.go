@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"backend/internal/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	movie := &models.Movie{
+		ID:          7,
+		Title:       "The Godfather",
+		ReleaseDate: time.Date(1972, time.March, 24, 0, 0, 0, 0, time.UTC),
+		RunTime:     175,
+	}
+
+	tests := []struct {
+		sort  string
+		value string
+	}{
+		{SortByTitle, "The Godfather"},
+		{SortByReleaseDate, "1972-03-24"},
+		{SortByRuntime, "175"},
+	}
+
+	for _, tt := range tests {
+		c := MovieCursor(movie, tt.sort)
+		if c.Value != tt.value || c.ID != 7 {
+			t.Errorf("MovieCursor(%s) = %+v, want the value %q and the id 7", tt.sort, c, tt.value)
+		}
+
+		decoded, err := DecodeCursor(EncodeCursor(c), tt.sort)
+		if err != nil {
+			t.Errorf("DecodeCursor(%s): %v", tt.sort, err)
+		}
+		if decoded != c {
+			t.Errorf("DecodeCursor(%s) = %+v, want %+v", tt.sort, decoded, c)
+		}
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	title := EncodeCursor(Cursor{Sort: SortByTitle, Value: "Alien", ID: 1})
+
+	// a cursor is only good for the order it was made for
+	_, err := DecodeCursor(title, SortByRuntime)
+	if !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("a title cursor for a runtime sort: %v, want ErrInvalidCursor", err)
+	}
+
+	for _, s := range []string{"not base64!", "bm90IGpzb24"} {
+		_, err := DecodeCursor(s, SortByTitle)
+		if !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("DecodeCursor(%q) = %v, want ErrInvalidCursor", s, err)
+		}
+	}
+}
+
+func TestNewMoviePage(t *testing.T) {
+	movies := []*models.Movie{{ID: 1, Title: "A"}, {ID: 2, Title: "B"}, {ID: 3, Title: "C"}}
+
+	// the repository loaded one more than the limit, so there is a next page
+	page := NewMoviePage(movies, MovieListOptions{Limit: 2})
+	if len(page.Movies) != 2 {
+		t.Fatalf("%d movies, want 2", len(page.Movies))
+	}
+
+	c, err := DecodeCursor(page.NextCursor, SortByTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ID != 2 || c.Value != "B" {
+		t.Errorf("the cursor points at %+v, want the last movie of the page", c)
+	}
+
+	page = NewMoviePage(movies, MovieListOptions{Limit: 3})
+	if len(page.Movies) != 3 || page.NextCursor != "" {
+		t.Errorf("last page: %d movies and cursor %q, want 3 and none", len(page.Movies), page.NextCursor)
+	}
+
+	// an empty page is a list, not null
+	page = NewMoviePage(nil, MovieListOptions{Limit: 3})
+	if page.Movies == nil {
+		t.Error("an empty page has nil movies")
+	}
+}
@@ -2,12 +2,99 @@ package repository
 
 import (
 	"backend/internal/models"
+	"context"
 	"database/sql"
+	"errors"
+	"time"
 )
 
+// ErrVersionMismatch is returned by a conditional change of a movie that has been
+// changed by someone else since the caller read it
+var ErrVersionMismatch = errors.New("the movie has been changed since it was read")
+
+// ErrDuplicateEmail is returned by CreateUser when a user with the same email, ignoring
+// case, already exists
+var ErrDuplicateEmail = errors.New("a user with this email already exists")
+
 type DatabaseRepo interface {
 	Connection() *sql.DB
-	AllMovies() ([]*models.Movie, error)
-	GetUserByEmail(email string) (*models.User, error)
-	GetUserByID(id int) (*models.User, error)
+
+	// WithTx runs fn inside a transaction. Everything fn does through the repo it is
+	// handed is committed when fn returns nil and rolled back when it returns an error.
+	// A transaction the database aborted because of a concurrent one is run again, so fn
+	// must not have side effects outside of the repo. Calling WithTx on the repo handed
+	// to fn makes the inner work part of the outer transaction.
+	WithTx(ctx context.Context, fn func(repo DatabaseRepo) error) error
+
+	AllMovies(ctx context.Context, opts MovieListOptions) (*MoviePage, error)
+	AllMoviesByGenre(ctx context.Context, genreID int) ([]*models.Movie, error)
+	OneMovie(ctx context.Context, id int) (*models.Movie, error)
+
+	// LastMovieChange returns the latest updated_at of all movies, those in the trash
+	// included. Deleting and restoring a movie set its updated_at too, so any change of
+	// the catalogue moves it forward.
+	LastMovieChange(ctx context.Context) (time.Time, error)
+
+	ExportMovies(ctx context.Context, fn func(movie *models.Movie) error) error
+	SearchMovies(ctx context.Context, query string, opts SearchOptions) ([]*SearchResult, error)
+	SuggestTitles(ctx context.Context, prefix string, limit int) ([]*Suggestion, error)
+	InsertMovie(ctx context.Context, movie models.Movie) (int, error)
+
+	// UpdateMovie and DeleteMovie only change the movie while its updated_at is still
+	// version, the one the caller read, and return ErrVersionMismatch otherwise. A zero
+	// version changes the movie whatever it is.
+	UpdateMovie(ctx context.Context, movie models.Movie, version time.Time) error
+	DeleteMovie(ctx context.Context, id int, version time.Time) error
+
+	TrashedMovies(ctx context.Context) ([]*models.Movie, error)
+	RestoreMovie(ctx context.Context, id int) error
+	PurgeMovie(ctx context.Context, id int) error
+	InsertMovieRevision(ctx context.Context, rev models.MovieRevision) (int, error)
+	MovieRevisions(ctx context.Context, movieID int) ([]*models.MovieRevision, error)
+	MovieRevision(ctx context.Context, movieID, revision int) (*models.MovieRevision, error)
+	UpdateMovieGenres(ctx context.Context, id int, genreIDs []int) error
+	AllGenres(ctx context.Context) ([]*models.Genre, error)
+
+	// MovieGenres returns the genres of each of the movies, keyed by movie id, in one go
+	// rather than a OneMovie per movie. A movie without genres has an empty list.
+	MovieGenres(ctx context.Context, movieIDs []int) (map[int][]*models.Genre, error)
+
+	// GetUserByEmail ignores the case of the email, like the unique index on it does
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	GetUserByID(ctx context.Context, id int) (*models.User, error)
+
+	// CreateUser inserts a user whose password is already hashed and returns its id, or
+	// ErrDuplicateEmail when the email is taken
+	CreateUser(ctx context.Context, user models.User) (int, error)
+
+	// VerifyUserEmail records that the user confirmed their email
+	VerifyUserEmail(ctx context.Context, id int, at time.Time) error
+
+	// UpdateUserPassword replaces the password hash of a user
+	UpdateUserPassword(ctx context.Context, id int, password string, at time.Time) error
+
+	// RevokeUserTokens makes every refresh token issued to the user up to at invalid
+	RevokeUserTokens(ctx context.Context, id int, at time.Time) error
+
+	InsertRefreshToken(ctx context.Context, token models.RefreshToken) error
+	GetRefreshToken(ctx context.Context, id string) (*models.RefreshToken, error)
+
+	// UseRefreshToken marks a refresh token as exchanged for the next one. It returns
+	// sql.ErrNoRows when the token isn't pending anymore, e.g. because a concurrent request
+	// used it first.
+	UseRefreshToken(ctx context.Context, id string, at time.Time) error
+
+	// RevokeRefreshFamily revokes every token of a family that isn't revoked yet
+	RevokeRefreshFamily(ctx context.Context, familyID string, at time.Time) error
+
+	// PurgeRefreshTokens deletes the refresh tokens that expired before, and returns how
+	// many there were
+	PurgeRefreshTokens(ctx context.Context, before time.Time) (int64, error)
+
+	InsertPasswordReset(ctx context.Context, reset models.PasswordReset) (int, error)
+
+	// UsePasswordReset marks the reset with the token hash as used and returns it, or
+	// sql.ErrNoRows when there is no such reset or it was used or expired before at. The
+	// other pending resets of the user are marked as used too, one reset ends them all.
+	UsePasswordReset(ctx context.Context, tokenHash string, at time.Time) (*models.PasswordReset, error)
 }
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultTimeout is how long a repository operation may take when nothing else is configured
+const DefaultTimeout = time.Second * 3
+
+// Timeouts are the deadlines of repository operations. They come on top of the context
+// passed to every method: whichever ends first cancels the query.
+type Timeouts struct {
+	Default      time.Duration            // zero means DefaultTimeout
+	PerOperation map[string]time.Duration // keyed by method name, e.g. "SearchMovies"
+}
+
+// slowOperations are the operations that go over the whole catalogue and get more time
+// than the default unless configured otherwise
+var slowOperations = map[string]time.Duration{
+	"ExportMovies": time.Minute * 10,
+}
+
+// For returns the deadline of the named operation.
+func (t Timeouts) For(operation string) time.Duration {
+	if d, ok := t.PerOperation[operation]; ok && d > 0 {
+		return d
+	}
+
+	if d, ok := slowOperations[operation]; ok {
+		return d
+	}
+
+	if t.Default > 0 {
+		return t.Default
+	}
+
+	return DefaultTimeout
+}
+
+// Context derives the context an operation runs with from the caller's one.
+func (t Timeouts) Context(ctx context.Context, operation string) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, t.For(operation))
+}
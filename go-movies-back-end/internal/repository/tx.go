@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+)
+
+// DefaultTxRetries is how many times a transaction is tried again after a serialization
+// failure when nothing else is configured
+const DefaultTxRetries = 3
+
+// TxOptions configures the transactions started by WithTx.
+type TxOptions struct {
+	// Isolation is the isolation level of the transaction. sql.LevelDefault leaves it to
+	// the database (read committed for postgres). SQLite transactions are always
+	// serializable, whatever is asked for.
+	Isolation sql.IsolationLevel
+
+	// Retries is how many more times a transaction is run when the database aborted it
+	// because it conflicted with a concurrent one. Zero means DefaultTxRetries, a
+	// negative value disables retrying.
+	Retries int
+
+	// Backoff is the pause before the first retry, doubled for every further one.
+	// Zero means 10ms.
+	Backoff time.Duration
+}
+
+// RetryCount returns how many times a failed transaction may be tried again.
+func (o TxOptions) RetryCount() int {
+	switch {
+	case o.Retries < 0:
+		return 0
+	case o.Retries == 0:
+		return DefaultTxRetries
+	}
+	return o.Retries
+}
+
+// FirstBackoff returns the pause before the first retry.
+func (o TxOptions) FirstBackoff() time.Duration {
+	if o.Backoff > 0 {
+		return o.Backoff
+	}
+	return time.Millisecond * 10
+}
@@ -0,0 +1,181 @@
+// Package revisions keeps the history of the changes made to movies. After every change a
+// snapshot of the movie is stored as its next revision, together with who made the change
+// and which fields it touched. Any two revisions can be compared, and a movie can be
+// rolled back to an earlier revision.
+package revisions
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"sort"
+	"time"
+)
+
+// The actions a revision can record
+const (
+	ActionCreate   = "create"
+	ActionUpdate   = "update"
+	ActionDelete   = "delete"
+	ActionRestore  = "restore"
+	ActionRollback = "rollback"
+	ActionImport   = "import"
+	ActionEnrich   = "enrich"
+)
+
+// FieldChange is one field that differs between two revisions
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// fields are the fields of a movie the history keeps track of, in the order changes are
+// reported. The values are what ends up in a FieldChange.
+var fields = []struct {
+	name  string
+	value func(movie *models.Movie) interface{}
+}{
+	{"title", func(m *models.Movie) interface{} { return m.Title }},
+	{"release_date", func(m *models.Movie) interface{} { return m.ReleaseDate.Format("2006-01-02") }},
+	{"runtime", func(m *models.Movie) interface{} { return m.RunTime }},
+	{"mpaa_rating", func(m *models.Movie) interface{} { return m.MPAARating }},
+	{"description", func(m *models.Movie) interface{} { return m.Description }},
+	{"image", func(m *models.Movie) interface{} { return m.Image }},
+	{"genres", func(m *models.Movie) interface{} { return genreNames(m) }},
+}
+
+// Diff lists the fields that differ between two versions of a movie. A nil from stands
+// for a movie that didn't exist yet, every field that has a value counts as changed.
+func Diff(from, to *models.Movie) []FieldChange {
+	changes := []FieldChange{}
+
+	for _, f := range fields {
+		after := f.value(to)
+
+		var before interface{}
+		if from != nil {
+			before = f.value(from)
+			if equal(before, after) {
+				continue
+			}
+		} else if isEmpty(after) {
+			continue
+		}
+
+		changes = append(changes, FieldChange{Field: f.name, From: before, To: after})
+	}
+
+	return changes
+}
+
+// Record stores the movie as it is now in repo as its next revision. The changed fields
+// are worked out against the previous revision. A movie that was last changed before
+// revisions were kept has none, so its first revision lists every field.
+func Record(ctx context.Context, repo repository.DatabaseRepo, movieID, userID int, action string) (*models.MovieRevision, error) {
+	movie, err := repo.OneMovie(ctx, movieID)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous *models.Movie
+
+	history, err := repo.MovieRevisions(ctx, movieID)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) > 0 {
+		previous = &history[0].Snapshot
+	}
+
+	rev := models.MovieRevision{
+		MovieID:       movieID,
+		Action:        action,
+		UserID:        userID,
+		ChangedFields: []string{},
+		Snapshot:      *movie,
+		CreatedAt:     time.Now().UTC(),
+	}
+
+	for _, change := range Diff(previous, movie) {
+		rev.ChangedFields = append(rev.ChangedFields, change.Field)
+	}
+
+	revision, err := repo.InsertMovieRevision(ctx, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.MovieRevision(ctx, movieID, revision)
+}
+
+// Rollback puts a movie back the way it was at the given revision, genres included, and
+// records that as a new revision. Run it in a transaction. version is the updated_at of
+// the movie the client saw, as for UpdateMovie. sql.ErrNoRows means that there is no such
+// revision, or that the movie is in the trash.
+func Rollback(ctx context.Context, repo repository.DatabaseRepo, movieID, revision, userID int, version time.Time) (*models.MovieRevision, error) {
+	target, err := repo.MovieRevision(ctx, movieID, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	movie := target.Snapshot
+	movie.ID = movieID
+	movie.UpdatedAt = time.Now().UTC()
+
+	err = repo.UpdateMovie(ctx, movie, version)
+	if err != nil {
+		return nil, err
+	}
+
+	genreIDs := []int{}
+	for _, g := range movie.Genres {
+		genreIDs = append(genreIDs, g.ID)
+	}
+
+	err = repo.UpdateMovieGenres(ctx, movieID, genreIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return Record(ctx, repo, movieID, userID, ActionRollback)
+}
+
+func genreNames(movie *models.Movie) []string {
+	names := []string{}
+	for _, g := range movie.Genres {
+		names = append(names, g.Genre)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func equal(a, b interface{}) bool {
+	as, ok := a.([]string)
+	if !ok {
+		return a == b
+	}
+
+	bs := b.([]string)
+	if len(as) != len(bs) {
+		return false
+	}
+	for i := range as {
+		if as[i] != bs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func isEmpty(v interface{}) bool {
+	switch v := v.(type) {
+	case string:
+		return v == ""
+	case int:
+		return v == 0
+	case []string:
+		return len(v) == 0
+	}
+	return false
+}
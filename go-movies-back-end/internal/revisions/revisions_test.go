@@ -0,0 +1,109 @@
+package revisions
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/repository/memrepo"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	from := &models.Movie{
+		Title:       "Highlander",
+		ReleaseDate: time.Date(1986, time.March, 7, 0, 0, 0, 0, time.UTC),
+		RunTime:     116,
+		MPAARating:  "R",
+		Genres:      []*models.Genre{{Genre: "Fantasy"}, {Genre: "Action"}},
+	}
+
+	// the same genres in another order are the same genres
+	to := *from
+	to.Genres = []*models.Genre{{Genre: "Action"}, {Genre: "Fantasy"}}
+	if changes := Diff(from, &to); len(changes) != 0 {
+		t.Errorf("no change gave %+v", changes)
+	}
+
+	to.RunTime = 120
+	to.Description = "He is immortal."
+	to.Genres = []*models.Genre{{Genre: "Action"}}
+
+	changes := Diff(from, &to)
+	want := "[{runtime 116 120} {description  He is immortal.} {genres [Action Fantasy] [Action]}]"
+	if got := fmt.Sprint(changes); got != want {
+		t.Errorf("Diff = %s, want %s", got, want)
+	}
+}
+
+// a movie compared with nothing lists the fields that have a value
+func TestDiffFromNothing(t *testing.T) {
+	to := &models.Movie{
+		Title:       "Alien",
+		ReleaseDate: time.Date(1979, time.May, 25, 0, 0, 0, 0, time.UTC),
+	}
+
+	changes := Diff(nil, to)
+	want := "[{title <nil> Alien} {release_date <nil> 1979-05-25}]"
+	if got := fmt.Sprint(changes); got != want {
+		t.Errorf("Diff = %s, want %s", got, want)
+	}
+}
+
+func TestRecordAndRollback(t *testing.T) {
+	ctx := context.Background()
+
+	repo := memrepo.New()
+	err := repo.Seed(memrepo.DefaultFixtures())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a movie changed before revisions were kept gets every field in its first one
+	first, err := Record(ctx, repo, 1, 1, ActionUpdate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.ChangedFields) < 4 {
+		t.Errorf("first revision changed %v", first.ChangedFields)
+	}
+	if first.CreatedAt.Location() != time.UTC {
+		t.Errorf("revision made at %v, want UTC", first.CreatedAt)
+	}
+
+	movie, err := repo.OneMovie(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version := movie.UpdatedAt.Truncate(time.Microsecond) // as an ETag has it
+
+	movie.RunTime = 120
+	movie.UpdatedAt = time.Now().UTC()
+	err = repo.UpdateMovie(ctx, *movie, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Record(ctx, repo, 1, 1, ActionUpdate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the rollback checks the version like an update
+	_, err = Rollback(ctx, repo, 1, first.Revision, 1, version)
+	if !errors.Is(err, repository.ErrVersionMismatch) {
+		t.Fatalf("rollback over an old version: %v", err)
+	}
+
+	rev, err := Rollback(ctx, repo, 1, first.Revision, 1, movie.UpdatedAt.Truncate(time.Microsecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev.Action != ActionRollback || rev.Snapshot.RunTime != first.Snapshot.RunTime {
+		t.Errorf("rollback revision = %+v", rev)
+	}
+	if fmt.Sprint(rev.ChangedFields) != "[runtime]" {
+		t.Errorf("rollback changed %v, want [runtime]", rev.ChangedFields)
+	}
+}
@@ -0,0 +1,204 @@
+// Package textsearch has the small text matching helpers used by the repositories that
+// can't hand full text search and trigram matching off to postgres.
+package textsearch
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// Words splits a text into lower case words, dropping punctuation.
+func Words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Trigrams returns the set of trigrams of a text the way pg_trgm builds them: every word
+// is padded with two spaces in front and one behind, so "cat" gives "  c", " ca", "cat"
+// and "at ".
+func Trigrams(s string) map[string]bool {
+	trigrams := make(map[string]bool)
+
+	for _, word := range Words(s) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			trigrams[string(padded[i:i+3])] = true
+		}
+	}
+
+	return trigrams
+}
+
+// WordSimilarity tells how well the text s matches some part of text, from 0 to 1. It is
+// the share of the trigrams of s that can be found in text, which is close to what
+// word_similarity of pg_trgm does, and good enough to forgive a missing or wrong letter.
+func WordSimilarity(s, text string) float64 {
+	needle := Trigrams(s)
+	if len(needle) == 0 {
+		return 0
+	}
+
+	haystack := Trigrams(text)
+
+	found := 0
+	for t := range needle {
+		if haystack[t] {
+			found++
+		}
+	}
+
+	return float64(found) / float64(len(needle))
+}
+
+// SimilarityThreshold is the score from which WordSimilarity counts as a match. It is the
+// default of the pg_trgm.word_similarity_threshold setting.
+const SimilarityThreshold = 0.6
+
+// Match scores a text against the words of a search. Every word of the search has to be
+// found in the text (a text word starting with the search word counts, so "fight" finds
+// "fighting"), otherwise the score is zero. The more often the words occur, the higher
+// the score.
+func Match(terms []string, text string) float64 {
+	if len(terms) == 0 {
+		return 0
+	}
+
+	words := Words(text)
+	score := 0.0
+
+	for _, term := range terms {
+		found := 0
+		for _, w := range words {
+			if strings.HasPrefix(w, term) {
+				found++
+			}
+		}
+
+		if found == 0 {
+			return 0
+		}
+
+		score += float64(found)
+	}
+
+	return score / float64(len(words)+1)
+}
+
+// StartSel and StopSel mark the matching words of a text until MarkHTML turns them into
+// <mark></mark>. They are in the private use area of Unicode, which movie texts don't use,
+// so that the text can be escaped for HTML without the markers.
+const (
+	StartSel = "\uE000"
+	StopSel  = "\uE001"
+)
+
+var marks = strings.NewReplacer(StartSel, "<mark>", StopSel, "</mark>")
+
+// MarkHTML escapes text for HTML, titles and descriptions can have "<" in them, and then
+// turns the StartSel and StopSel markers into <mark></mark>.
+func MarkHTML(text string) string {
+	return marks.Replace(html.EscapeString(text))
+}
+
+// Highlight escapes text for HTML and wraps every word of it that starts with one of the
+// terms in <mark></mark>, like the postgres repository does with ts_headline.
+func Highlight(text string, terms []string) string {
+	var b strings.Builder
+
+	runes := []rune(text)
+	start := -1
+
+	flush := func(end int) {
+		word := string(runes[start:end])
+		lower := strings.ToLower(word)
+
+		for _, term := range terms {
+			if strings.HasPrefix(lower, term) {
+				b.WriteString(StartSel + word + StopSel)
+				return
+			}
+		}
+
+		b.WriteString(word)
+	}
+
+	for i, r := range runes {
+		isWord := unicode.IsLetter(r) || unicode.IsNumber(r)
+
+		switch {
+		case isWord && start < 0:
+			start = i
+		case !isWord && start >= 0:
+			flush(i)
+			start = -1
+		}
+
+		if !isWord {
+			b.WriteRune(r)
+		}
+	}
+
+	if start >= 0 {
+		flush(len(runes))
+	}
+
+	return MarkHTML(b.String())
+}
+
+// Snippet returns the part of a long text around the first word that matches one of the
+// terms, cut to about maxWords words, with the matching words highlighted.
+func Snippet(text string, terms []string, maxWords int) string {
+	fields := strings.Fields(text)
+	if len(fields) <= maxWords {
+		return Highlight(text, terms)
+	}
+
+	first := 0
+	for i, f := range fields {
+		if matchesAny(Words(f), terms) {
+			first = i
+			break
+		}
+	}
+
+	// keep a few words in front of the match for context
+	from := first - maxWords/4
+	if from < 0 {
+		from = 0
+	}
+	to := from + maxWords
+	if to > len(fields) {
+		to = len(fields)
+		from = to - maxWords
+	}
+
+	return Highlight(strings.Join(fields[from:to], " "), terms)
+}
+
+func matchesAny(words, terms []string) bool {
+	for _, w := range words {
+		for _, term := range terms {
+			if strings.HasPrefix(w, term) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Terms returns the distinct words of a search, in the order they were typed.
+func Terms(query string) []string {
+	seen := make(map[string]bool)
+
+	var terms []string
+	for _, w := range Words(query) {
+		if !seen[w] {
+			seen[w] = true
+			terms = append(terms, w)
+		}
+	}
+
+	return terms
+}
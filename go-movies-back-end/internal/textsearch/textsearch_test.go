@@ -0,0 +1,182 @@
+package textsearch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestTerms(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", nil},
+		{"Godfather", []string{"godfather"}},
+		{"the  Lost ark, THE end", []string{"the", "lost", "ark", "end"}},
+		{"--- !!!", nil},
+		{"Amélie 2001", []string{"amélie", "2001"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got := Terms(tt.query)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Terms(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrigrams(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"cat", `["  c" " ca" "at " "cat"]`},
+		{"Cat, cat!", `["  c" " ca" "at " "cat"]`},
+		{"a b", `["  a" "  b" " a " " b "]`},
+		{"é", `["  é" " é "]`},
+		{"", `[]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			var got []string
+			for trigram := range Trigrams(tt.text) {
+				got = append(got, trigram)
+			}
+			sort.Strings(got)
+
+			if fmt.Sprintf("%q", got) != tt.want {
+				t.Errorf("Trigrams(%q) = %q, want %s", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWordSimilarity(t *testing.T) {
+	tests := []struct {
+		search string
+		title  string
+		match  bool
+	}{
+		{"godfather", "The Godfather", true},
+		{"GODFATHER", "The Godfather", true},
+		{"godfater", "The Godfather", true},
+		{"hilander", "Highlander", true},
+		{"lost ark", "Raiders of the Lost Ark", true},
+		{"alien", "The Godfather", false},
+		{"raiders", "Highlander", false},
+		{"", "The Godfather", false},
+		{"!!", "The Godfather", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.search+" in "+tt.title, func(t *testing.T) {
+			score := WordSimilarity(tt.search, tt.title)
+			if score < 0 || score > 1 {
+				t.Fatalf("score %v out of 0..1", score)
+			}
+			if (score >= SimilarityThreshold) != tt.match {
+				t.Errorf("WordSimilarity(%q, %q) = %v, want a match %v", tt.search, tt.title, score, tt.match)
+			}
+		})
+	}
+
+	// the exact word scores above a word with a typo
+	if WordSimilarity("godfather", "The Godfather") <= WordSimilarity("godfater", "The Godfather") {
+		t.Error("a typo scores as well as the exact word")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	text := "A fighter fights the fight of his life"
+
+	tests := []struct {
+		name  string
+		terms []string
+		found bool
+	}{
+		{"prefix", []string{"fight"}, true},
+		{"every term", []string{"fight", "life"}, true},
+		{"a term missing", []string{"fight", "death"}, false},
+		{"inside a word", []string{"ight"}, false},
+		{"no terms", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score := Match(tt.terms, text)
+			if (score > 0) != tt.found {
+				t.Errorf("Match(%q) = %v, want found %v", tt.terms, score, tt.found)
+			}
+		})
+	}
+
+	// more occurrences rank higher
+	if Match([]string{"fight"}, text) <= Match([]string{"life"}, text) {
+		t.Error("three fights don't rank above one life")
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		terms []string
+		want  string
+	}{
+		{"word", "The Godfather", []string{"godfather"}, "The <mark>Godfather</mark>"},
+		{"prefix", "Fighting fights", []string{"fight"}, "<mark>Fighting</mark> <mark>fights</mark>"},
+		{"no match", "The Godfather", []string{"alien"}, "The Godfather"},
+		{"punctuation", "Alien: the director's cut", []string{"alien", "cut"}, "<mark>Alien</mark>: the director&#39;s <mark>cut</mark>"},
+		// titles and descriptions come from admins and imports, they aren't HTML
+		{"markup", `<script>alert("x")</script> Alien`, []string{"alien"}, "&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; <mark>Alien</mark>"},
+		{"markup term", "<b>bold</b>", []string{"b"}, "&lt;<mark>b</mark>&gt;<mark>bold</mark>&lt;/<mark>b</mark>&gt;"},
+		{"ampersand", "Tom & Jerry", []string{"jerry"}, "Tom &amp; <mark>Jerry</mark>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Highlight(tt.text, tt.terms)
+			if got != tt.want {
+				t.Errorf("Highlight(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarkHTML(t *testing.T) {
+	// what ts_headline gives back for a title with markup in it
+	headline := "<img src=x onerror=alert(1)> " + StartSel + "Alien" + StopSel
+
+	want := "&lt;img src=x onerror=alert(1)&gt; <mark>Alien</mark>"
+	if got := MarkHTML(headline); got != want {
+		t.Errorf("MarkHTML(%q) = %q, want %q", headline, got, want)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	short := "A short <description>"
+	if got := Snippet(short, []string{"short"}, 10); got != "A <mark>short</mark> &lt;description&gt;" {
+		t.Errorf("Snippet of a short text = %q", got)
+	}
+
+	words := make([]string, 100)
+	for i := range words {
+		words[i] = fmt.Sprintf("w%d", i)
+	}
+	words[60] = "needle"
+
+	got := Snippet(strings.Join(words, " "), []string{"needle"}, 20)
+
+	fields := strings.Fields(got)
+	if len(fields) != 20 {
+		t.Errorf("%d words in the snippet, want 20", len(fields))
+	}
+	if !strings.Contains(got, "<mark>needle</mark>") || !strings.HasPrefix(got, "w55 ") {
+		t.Errorf("snippet = %q, want the needle with 5 words in front", got)
+	}
+}
@@ -16,6 +16,13 @@ SET xmloption = content;
 SET client_min_messages = warning;
 SET row_security = off;
 
+--
+-- Name: pg_trgm; Type: EXTENSION; Schema: -; Owner: -
+--
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm WITH SCHEMA public;
+
+
 SET default_tablespace = '';
 
 SET default_table_access_method = heap;
@@ -246,6 +253,41 @@ ALTER TABLE ONLY public.users
     ADD CONSTRAINT users_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: movies_title_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX movies_title_id_idx ON public.movies USING btree (title, id);
+
+
+--
+-- Name: movies_release_date_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX movies_release_date_id_idx ON public.movies USING btree (release_date, id);
+
+
+--
+-- Name: movies_runtime_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX movies_runtime_id_idx ON public.movies USING btree (runtime, id);
+
+
+--
+-- Name: movies_search_english_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX movies_search_english_idx ON public.movies USING gin ((setweight(to_tsvector('english', coalesce(title, '')), 'A') || setweight(to_tsvector('english', coalesce(description, '')), 'B')));
+
+
+--
+-- Name: movies_title_trgm_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX movies_title_trgm_idx ON public.movies USING gin (title public.gin_trgm_ops);
+
+
 --
 -- Name: movies_genres movies_genres_genre_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
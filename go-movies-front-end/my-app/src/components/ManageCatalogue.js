@@ -1,8 +1,29 @@
 import { useEffect, useState } from "react";
 import { Link, useNavigate, useOutletContext } from "react-router-dom";
 
+// fetchMovies gets a page of the catalogue, the first one when after is empty. The API
+// sends next_cursor along with every page but the last one.
+const fetchMovies = (jwtToken, after) => {
+    const headers = new Headers();
+    headers.append("Content-Type", "application/json");
+    headers.append("Authorization", "Bearer " + jwtToken);
+
+    const requestOptions = {
+        method: "GET",
+        headers: headers,
+    }
+
+    let url = `/admin/movies`;
+    if (after !== "") {
+        url += `?after=${encodeURIComponent(after)}`;
+    }
+
+    return fetch(url, requestOptions).then((response) => response.json());
+}
+
 const ManageCatalogue = () => {
     const [movies, setMovies] = useState([]);
+    const [nextCursor, setNextCursor] = useState("");
     const { jwtToken } = useOutletContext();
     const navigate = useNavigate();
 
@@ -11,19 +32,11 @@ const ManageCatalogue = () => {
             navigate("/login");
             return
         }
-        const headers = new Headers();
-        headers.append("Content-Type", "application/json");
-        headers.append("Authorization", "Bearer " + jwtToken);
-
-        const requestOptions = {
-            method: "GET",
-            headers: headers,
-        }
 
-        fetch(`/admin/movies`, requestOptions)
-            .then((response) => response.json())
+        fetchMovies(jwtToken, "")
             .then((data) => {
-                setMovies(data);
+                setMovies(data.movies ?? []);
+                setNextCursor(data.next_cursor ?? "");
             })
             .catch(err => {
                 console.log(err);
@@ -31,6 +44,17 @@ const ManageCatalogue = () => {
 
     }, [jwtToken, navigate]);
 
+    const loadMore = () => {
+        fetchMovies(jwtToken, nextCursor)
+            .then((data) => {
+                setMovies((movies) => movies.concat(data.movies ?? []));
+                setNextCursor(data.next_cursor ?? "");
+            })
+            .catch(err => {
+                console.log(err);
+            })
+    }
+
     return(
         <div>
             <h2>Manage Catalogue</h2>
@@ -57,6 +81,11 @@ const ManageCatalogue = () => {
                     ))}
                 </tbody>
             </table>
+            {nextCursor !== "" &&
+                <button className="btn btn-outline-secondary" onClick={loadMore}>
+                    Load more
+                </button>
+            }
         </div>
     )
 }
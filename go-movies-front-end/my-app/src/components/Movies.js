@@ -1,22 +1,34 @@
 import { useEffect, useState } from "react";
 import { Link } from "react-router-dom";
 
+// fetchMovies gets a page of movies, the first one when after is empty. The API sends
+// next_cursor along with every page but the last one.
+const fetchMovies = (after) => {
+    const headers = new Headers();
+    headers.append("Content-Type", "application/json");
+
+    const requestOptions = {
+        method: "GET",
+        headers: headers,
+    }
+
+    let url = `http://localhost:8080/movies`;
+    if (after !== "") {
+        url += `?after=${encodeURIComponent(after)}`;
+    }
+
+    return fetch(url, requestOptions).then((response) => response.json());
+}
+
 const Movies = () => {
     const [movies, setMovies] = useState([]);
+    const [nextCursor, setNextCursor] = useState("");
 
     useEffect( () => {
-        const headers = new Headers();
-        headers.append("Content-Type", "application/json");
-
-        const requestOptions = {
-            method: "GET",
-            headers: headers,
-        }
-
-        fetch(`http://localhost:8080/movies`, requestOptions)
-            .then((response) => response.json())
+        fetchMovies("")
             .then((data) => {
-                setMovies(data);
+                setMovies(data.movies ?? []);
+                setNextCursor(data.next_cursor ?? "");
             })
             .catch(err => {
                 console.log(err);
@@ -24,6 +36,17 @@ const Movies = () => {
 
     }, []);
 
+    const loadMore = () => {
+        fetchMovies(nextCursor)
+            .then((data) => {
+                setMovies((movies) => movies.concat(data.movies ?? []));
+                setNextCursor(data.next_cursor ?? "");
+            })
+            .catch(err => {
+                console.log(err);
+            })
+    }
+
     return(
         <div>
             <h2>Movies</h2>
@@ -50,6 +73,11 @@ const Movies = () => {
                     ))}
                 </tbody>
             </table>
+            {nextCursor !== "" &&
+                <button className="btn btn-outline-secondary" onClick={loadMore}>
+                    Load more
+                </button>
+            }
         </div>
     )
 }